@@ -0,0 +1,108 @@
+package acme
+
+import (
+  "context"
+  "crypto/sha256"
+  "encoding/base64"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net"
+  "net/http"
+  "strings"
+  "time"
+)
+
+
+const (
+  CHALLENGE_HTTP01 = "http-01"
+  CHALLENGE_DNS01 = "dns-01"
+
+  // The maximum size of the response to an http-01 challenge.
+  MAX_CHALLENGE_RESPONSE_SIZE = 4096
+)
+
+
+// Looks up the TXT records for dns-01 challenges. *net.Resolver implements
+// this interface.
+type Resolver interface {
+  LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+
+// Return a resolver that queries the DNS server at address (host:port).
+func NewResolver(address string) Resolver {
+  return &net.Resolver{
+    PreferGo: true,
+    Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+      d := net.Dialer{}
+      return d.DialContext(ctx, network, address)
+    },
+  }
+}
+
+
+// Validate the challenge for the account with the given key thumbprint.
+// A nil Problem means that the challenge was met.
+func (self *Server) validate(challenge *Challenge, identifier Identifier, thumbprint string) *Problem {
+  keyAuthorization := challenge.Token + "." + thumbprint
+  ctx, cancel := context.WithTimeout(context.Background(), 30 * time.Second)
+  defer cancel()
+
+  switch challenge.Type {
+    case CHALLENGE_HTTP01:
+      return self.validateHTTP01(ctx, identifier.Value, challenge.Token, keyAuthorization)
+    case CHALLENGE_DNS01:
+      return self.validateDNS01(ctx, identifier.Value, keyAuthorization)
+    default:
+      return malformed("Unsupported challenge type: %s", challenge.Type)
+  }
+}
+
+
+func (self *Server) validateHTTP01(ctx context.Context, domain string, token string, keyAuthorization string) *Problem {
+  host := domain
+  if self.HTTPPort != 0 && self.HTTPPort != 80 {
+    host = net.JoinHostPort(domain, fmt.Sprint(self.HTTPPort))
+  }
+  url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token)
+  req, err := http.NewRequest(http.MethodGet, url, nil)
+  if err != nil {
+    return malformed("%s", err)
+  }
+  response, err := self.HTTPClient.Do(req.WithContext(ctx))
+  if err != nil {
+    return newProblem(http.StatusBadRequest, "connection", "GET %s: %s", url, err)
+  }
+  defer response.Body.Close()
+  if response.StatusCode != http.StatusOK {
+    return newProblem(http.StatusForbidden, "unauthorized", "GET %s: %s", url, response.Status)
+  }
+  buf, err := ioutil.ReadAll(io.LimitReader(response.Body, MAX_CHALLENGE_RESPONSE_SIZE))
+  if err != nil {
+    return newProblem(http.StatusBadRequest, "connection", "GET %s: %s", url, err)
+  }
+  if strings.TrimSpace(string(buf)) != keyAuthorization {
+    return newProblem(http.StatusForbidden, "incorrectResponse",
+      "The response from %s does not match the key authorization.", url)
+  }
+  return nil
+}
+
+
+func (self *Server) validateDNS01(ctx context.Context, domain string, keyAuthorization string) *Problem {
+  name := "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+  h := sha256.Sum256([]byte(keyAuthorization))
+  expected := base64.RawURLEncoding.EncodeToString(h[:])
+  records, err := self.Resolver.LookupTXT(ctx, name)
+  if err != nil {
+    return newProblem(http.StatusBadRequest, "dns", "TXT %s: %s", name, err)
+  }
+  for _, record := range records {
+    if strings.TrimSpace(record) == expected {
+      return nil
+    }
+  }
+  return newProblem(http.StatusForbidden, "incorrectResponse",
+    "No TXT record of %s matches the key authorization.", name)
+}
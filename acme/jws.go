@@ -0,0 +1,72 @@
+package acme
+
+import (
+  "crypto"
+  "encoding/base64"
+  "encoding/json"
+  "net/http"
+
+  "github.com/cochiseruhulessin/cloud-pki/oidc"
+)
+
+
+// A JWS in the flattened JSON serialization (RFC 7515, section 7.2.2).
+type jsonWebSignature struct {
+  Protected string `json:"protected"`
+  Payload string `json:"payload"`
+  Signature string `json:"signature"`
+}
+
+
+type protectedHeader struct {
+  Algorithm string `json:"alg"`
+  Nonce string `json:"nonce"`
+  URL string `json:"url"`
+  KeyID string `json:"kid"`
+  JWK *oidc.JSONWebKey `json:"jwk"`
+}
+
+
+func parseJWS(body []byte) (*jsonWebSignature, *protectedHeader, error) {
+  jws := jsonWebSignature{}
+  if err := json.Unmarshal(body, &jws); err != nil {
+    return nil, nil, malformed("The request is not a flattened JWS.")
+  }
+  buf, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+  if err != nil {
+    return nil, nil, malformed("Malformed protected header.")
+  }
+  header := protectedHeader{}
+  if err := json.Unmarshal(buf, &header); err != nil {
+    return nil, nil, malformed("Malformed protected header.")
+  }
+  if (header.KeyID == "") == (header.JWK == nil) {
+    return nil, nil, malformed("Specify either jwk or kid in the protected header.")
+  }
+  return &jws, &header, nil
+}
+
+
+// Verify the signature of the JWS with pub and return its payload.
+// POST-as-GET requests have an empty payload.
+func (self *jsonWebSignature) verify(alg string, pub crypto.PublicKey) ([]byte, error) {
+  switch alg {
+    case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA":
+    default:
+      return nil, newProblem(http.StatusBadRequest, "badSignatureAlgorithm",
+        "Unsupported algorithm: %s", alg)
+  }
+  signature, err := base64.RawURLEncoding.DecodeString(self.Signature)
+  if err != nil {
+    return nil, malformed("Malformed signature.")
+  }
+  signed := []byte(self.Protected + "." + self.Payload)
+  if err := oidc.VerifySignature(alg, pub, signed, signature); err != nil {
+    return nil, newProblem(http.StatusForbidden, "unauthorized", "Invalid signature: %s", err)
+  }
+  payload, err := base64.RawURLEncoding.DecodeString(self.Payload)
+  if err != nil {
+    return nil, malformed("Malformed payload.")
+  }
+  return payload, nil
+}
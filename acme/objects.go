@@ -0,0 +1,149 @@
+package acme
+
+import (
+  "crypto/rand"
+  "encoding/base64"
+  "sync"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/oidc"
+)
+
+
+const (
+  STATUS_PENDING = "pending"
+  STATUS_PROCESSING = "processing"
+  STATUS_READY = "ready"
+  STATUS_VALID = "valid"
+  STATUS_INVALID = "invalid"
+  STATUS_DEACTIVATED = "deactivated"
+)
+
+
+type Identifier struct {
+  Type string `json:"type"`
+  Value string `json:"value"`
+}
+
+
+type Account struct {
+  ID string `json:"-"`
+  URL string `json:"-"`
+  Key *oidc.JSONWebKey `json:"-"`
+  Thumbprint string `json:"-"`
+  Status string `json:"status"`
+  Contact []string `json:"contact,omitempty"`
+  TermsOfServiceAgreed bool `json:"termsOfServiceAgreed,omitempty"`
+  Orders string `json:"orders"`
+}
+
+
+type Order struct {
+  ID string `json:"-"`
+  URL string `json:"-"`
+  AccountID string `json:"-"`
+  Status string `json:"status"`
+  Expires time.Time `json:"expires"`
+  Identifiers []Identifier `json:"identifiers"`
+  Authorizations []string `json:"authorizations"`
+  Finalize string `json:"finalize"`
+  Certificate string `json:"certificate,omitempty"`
+  Error *Problem `json:"error,omitempty"`
+
+  authorizations []*Authorization
+}
+
+
+type Authorization struct {
+  ID string `json:"-"`
+  URL string `json:"-"`
+  AccountID string `json:"-"`
+  Identifier Identifier `json:"identifier"`
+  Status string `json:"status"`
+  Expires time.Time `json:"expires"`
+  Challenges []*Challenge `json:"challenges"`
+  Wildcard bool `json:"wildcard,omitempty"`
+}
+
+
+type Challenge struct {
+  ID string `json:"-"`
+  Type string `json:"type"`
+  URL string `json:"url"`
+  Status string `json:"status"`
+  Token string `json:"token"`
+  Validated *time.Time `json:"validated,omitempty"`
+  Error *Problem `json:"error,omitempty"`
+
+  authorization *Authorization
+}
+
+
+// Holds the state of the server in memory. All objects are protected by
+// the mutex.
+type store struct {
+  mu sync.Mutex
+  nonces map[string]time.Time
+  nonceOrder []string
+
+  // The number of requests per client address since the start of window.
+  requests map[string]int
+  window time.Time
+  accounts map[string]*Account
+  thumbprints map[string]*Account
+  orders map[string]*Order
+  authorizations map[string]*Authorization
+  challenges map[string]*Challenge
+
+  // PEM-encoded certificate chains by order.
+  certificates map[string][]byte
+}
+
+
+func newStore() *store {
+  return &store{
+    nonces: map[string]time.Time{},
+    accounts: map[string]*Account{},
+    thumbprints: map[string]*Account{},
+    orders: map[string]*Order{},
+    authorizations: map[string]*Authorization{},
+    challenges: map[string]*Challenge{},
+    certificates: map[string][]byte{},
+  }
+}
+
+
+// Update the status of the order from its authorizations.
+func (self *Order) updateStatus(now time.Time) {
+  if self.Status != STATUS_PENDING {
+    return
+  }
+  if now.After(self.Expires) {
+    self.Status = STATUS_INVALID
+    return
+  }
+  ready := true
+  for _, authz := range self.authorizations {
+    switch authz.Status {
+      case STATUS_INVALID, STATUS_DEACTIVATED:
+        self.Status = STATUS_INVALID
+        return
+      case STATUS_VALID:
+      default:
+        ready = false
+    }
+  }
+  if ready {
+    self.Status = STATUS_READY
+  }
+}
+
+
+// Return a random, URL-safe identifier with 128 bits of entropy.
+func newID() string {
+  buf := make([]byte, 16)
+  if _, err := rand.Read(buf); err != nil {
+    panic(err)
+  }
+  return base64.RawURLEncoding.EncodeToString(buf)
+}
@@ -0,0 +1,51 @@
+package acme
+
+import (
+  "fmt"
+  "net/http"
+)
+
+
+const ERROR_NAMESPACE = "urn:ietf:params:acme:error:"
+
+
+// An error document as specified in RFC 7807.
+type Problem struct {
+  Type string `json:"type"`
+  Detail string `json:"detail"`
+  Status int `json:"status"`
+}
+
+
+func (self *Problem) Error() string {
+  return self.Detail
+}
+
+
+func newProblem(status int, kind string, format string, args ...interface{}) *Problem {
+  return &Problem{
+    Type: ERROR_NAMESPACE + kind,
+    Detail: fmt.Sprintf(format, args...),
+    Status: status,
+  }
+}
+
+
+func malformed(format string, args ...interface{}) *Problem {
+  return newProblem(http.StatusBadRequest, "malformed", format, args...)
+}
+
+
+func unauthorized(format string, args ...interface{}) *Problem {
+  return newProblem(http.StatusForbidden, "unauthorized", format, args...)
+}
+
+
+func notFound() *Problem {
+  return newProblem(http.StatusNotFound, "malformed", "The resource does not exist.")
+}
+
+
+func serverInternal(err error) *Problem {
+  return newProblem(http.StatusInternalServerError, "serverInternal", "%s", err)
+}
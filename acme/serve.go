@@ -0,0 +1,59 @@
+package acme
+
+import (
+  "flag"
+  "log"
+  "net/http"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Serve an ACME directory at /acme/directory that issues certificates
+// with the CA and profile of the configuration.
+func HandleServe(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var listen string
+  var baseURL string
+  var tlsCert string
+  var tlsKey string
+  var httpPort int
+
+  parser := flag.NewFlagSet("serve", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&listen, "listen", ":8080",
+    "specifies the address to listen on.")
+  parser.StringVar(&baseURL, "url", "",
+    "specifies the external URL of the server, e.g. https://acme.example.com.")
+  parser.StringVar(&tlsCert, "tls-cert", "",
+    "specifies the TLS certificate of the server.")
+  parser.StringVar(&tlsKey, "tls-key", "",
+    "specifies the TLS private key of the server.")
+  parser.IntVar(&httpPort, "http-port", 80,
+    "specifies the port on which http-01 challenges are validated.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  server, err := NewServer(backend, &opts)
+  if err != nil { log.Fatal(err) }
+  server.BaseURL = baseURL
+  server.HTTPPort = httpPort
+
+  mux := http.NewServeMux()
+  mux.Handle("/acme/", server)
+  log.Printf("Listening on %s", listen)
+  if tlsCert != "" {
+    err = http.ListenAndServeTLS(listen, tlsCert, tlsKey, mux)
+  } else {
+    err = http.ListenAndServe(listen, mux)
+  }
+  log.Fatal(err)
+}
@@ -0,0 +1,798 @@
+package acme
+
+import (
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/json"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "net"
+  "net/http"
+  "strings"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/oidc"
+  pki "github.com/cochiseruhulessin/cloud-pki/x509"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+const (
+  MAX_REQUEST_SIZE = 64 * 1024
+  NONCE_LIFETIME = time.Hour
+
+  // The maximum number of outstanding nonces; the oldest are dropped.
+  MAX_NONCES = 10000
+
+  // The maximum number of requests, each of which creates a nonce, per
+  // client address and minute.
+  MAX_REQUESTS_PER_MINUTE = 300
+  DEFAULT_ORDER_VALIDITY = "24h"
+)
+
+
+// An ACME (RFC 8555) server that issues certificates with the CA and
+// profile that are specified in its configuration. Its state is kept in
+// memory.
+type Server struct {
+  // The URL at which the server is reachable, e.g.
+  // https://acme.example.com. If empty, it is derived from the requests.
+  BaseURL string
+
+  // Looks up the TXT records of dns-01 challenges.
+  Resolver Resolver
+
+  // Fetches the responses to http-01 challenges.
+  HTTPClient *http.Client
+
+  // The port on which http-01 challenges are validated (default 80).
+  HTTPPort int
+
+  backend backends.Backend
+  opts *dto.X509ConfigurationDTO
+  profile *dto.X509Profile
+  challenges []string
+  orderValidity time.Duration
+  chain []byte
+  store *store
+}
+
+
+// An authenticated request. account is nil for requests to newAccount,
+// which are signed with jwk.
+type request struct {
+  payload []byte
+  account *Account
+  jwk *oidc.JSONWebKey
+}
+
+
+func NewServer(backend backends.Backend, opts *dto.X509ConfigurationDTO) (*Server, error) {
+  conf := opts.Acme
+  profile, err := opts.GetProfile(conf.Profile)
+  if err != nil {
+    return nil, err
+  }
+  issuer, err := opts.GetSignerCertificate()
+  if err != nil {
+    return nil, err
+  }
+  challenges := conf.Challenges
+  if len(challenges) == 0 {
+    challenges = []string{CHALLENGE_HTTP01, CHALLENGE_DNS01}
+  }
+  for _, c := range challenges {
+    if c != CHALLENGE_HTTP01 && c != CHALLENGE_DNS01 {
+      return nil, errors.New(fmt.Sprintf("Unsupported challenge type: %s", c))
+    }
+  }
+  validity := conf.OrderValidity
+  if validity == "" {
+    validity = DEFAULT_ORDER_VALIDITY
+  }
+  orderValidity, err := dto.ParseDuration(validity)
+  if err != nil {
+    return nil, err
+  }
+  var resolver Resolver = net.DefaultResolver
+  if conf.Resolver != "" {
+    resolver = NewResolver(conf.Resolver)
+  }
+  return &Server{
+    Resolver: resolver,
+    HTTPClient: &http.Client{Timeout: 30 * time.Second},
+    HTTPPort: 80,
+    backend: backend,
+    opts: opts,
+    profile: profile,
+    challenges: challenges,
+    orderValidity: orderValidity,
+    chain: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw}),
+    store: newStore(),
+  }, nil
+}
+
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if !self.allowRequest(r) {
+    w.Header().Set("Retry-After", "60")
+    writeProblem(w, newProblem(http.StatusTooManyRequests, "rateLimited", "Too many requests."))
+    return
+  }
+  base := self.getBaseURL(r)
+  w.Header().Set("Link", fmt.Sprintf("<%s/acme/directory>;rel=\"index\"", base))
+  w.Header().Set("Replay-Nonce", self.newNonce())
+  w.Header().Set("Cache-Control", "no-store")
+
+  parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/acme/"), "/"), "/")
+  switch {
+    case parts[0] == "directory" && r.Method == http.MethodGet:
+      writeJSON(w, http.StatusOK, map[string]interface{}{
+        "newNonce": base + "/acme/new-nonce",
+        "newAccount": base + "/acme/new-account",
+        "newOrder": base + "/acme/new-order",
+        "meta": map[string]interface{}{"externalAccountRequired": false},
+      })
+      return
+    case parts[0] == "new-nonce" && r.Method == http.MethodHead:
+      w.WriteHeader(http.StatusOK)
+      return
+    case parts[0] == "new-nonce" && r.Method == http.MethodGet:
+      w.WriteHeader(http.StatusNoContent)
+      return
+    case r.Method != http.MethodPost:
+      writeProblem(w, newProblem(http.StatusMethodNotAllowed, "malformed", "Use POST."))
+      return
+  }
+
+  req, problem := self.authenticate(r, base, parts[0] == "new-account")
+  if problem != nil {
+    writeProblem(w, problem)
+    return
+  }
+  id := ""
+  if len(parts) > 1 {
+    id = parts[1]
+  }
+  switch {
+    case parts[0] == "new-account" && len(parts) == 1:
+      problem = self.newAccount(w, base, req)
+    case parts[0] == "account" && len(parts) == 2:
+      problem = self.updateAccount(w, id, req)
+    case parts[0] == "account" && len(parts) == 3 && parts[2] == "orders":
+      problem = self.listOrders(w, id, req)
+    case parts[0] == "new-order" && len(parts) == 1:
+      problem = self.newOrder(w, base, req)
+    case parts[0] == "order" && len(parts) == 2:
+      problem = self.getOrder(w, id, req)
+    case parts[0] == "order" && len(parts) == 3 && parts[2] == "finalize":
+      problem = self.finalize(w, base, id, req)
+    case parts[0] == "authz" && len(parts) == 2:
+      problem = self.getAuthorization(w, id, req)
+    case parts[0] == "chall" && len(parts) == 2:
+      problem = self.respondChallenge(w, id, req)
+    case parts[0] == "cert" && len(parts) == 2:
+      problem = self.getCertificate(w, id, req)
+    default:
+      problem = notFound()
+  }
+  if problem != nil {
+    writeProblem(w, problem)
+  }
+}
+
+
+func (self *Server) getBaseURL(r *http.Request) string {
+  if self.BaseURL != "" {
+    return strings.TrimSuffix(self.BaseURL, "/")
+  }
+  scheme := "http"
+  if r.TLS != nil {
+    scheme = "https"
+  }
+  return scheme + "://" + r.Host
+}
+
+
+// Verify the JWS in the body of the request, its nonce and its URL, and
+// return the payload and the account that signed it.
+func (self *Server) authenticate(r *http.Request, base string, newAccount bool) (*request, *Problem) {
+  if r.Header.Get("Content-Type") != "application/jose+json" {
+    return nil, malformed("The Content-Type must be application/jose+json.")
+  }
+  body, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE + 1))
+  if err != nil || len(body) > MAX_REQUEST_SIZE {
+    return nil, malformed("The request is too large.")
+  }
+  jws, header, err := parseJWS(body)
+  if err != nil {
+    return nil, err.(*Problem)
+  }
+  if header.URL != base + r.URL.Path {
+    return nil, unauthorized("The url in the protected header does not match the request.")
+  }
+  if !self.useNonce(header.Nonce) {
+    return nil, newProblem(http.StatusBadRequest, "badNonce", "Invalid or expired nonce.")
+  }
+
+  req := &request{}
+  var key *oidc.JSONWebKey
+  switch {
+    case newAccount && header.JWK == nil:
+      return nil, malformed("Requests to newAccount must include a jwk.")
+    case newAccount:
+      key = header.JWK
+      req.jwk = header.JWK
+    case header.JWK != nil:
+      return nil, malformed("Requests must include the kid of the account.")
+    default:
+      id := strings.TrimPrefix(header.KeyID, base + "/acme/account/")
+      self.store.mu.Lock()
+      account := self.store.accounts[id]
+      self.store.mu.Unlock()
+      if account == nil {
+        return nil, newProblem(http.StatusBadRequest, "accountDoesNotExist",
+          "The account does not exist.")
+      }
+      if account.Status != STATUS_VALID {
+        return nil, unauthorized("The account is %s.", account.Status)
+      }
+      key = account.Key
+      req.account = account
+  }
+  pub, err := key.PublicKey()
+  if err != nil {
+    return nil, newProblem(http.StatusBadRequest, "badPublicKey", "%s", err)
+  }
+  req.payload, err = jws.verify(header.Algorithm, pub)
+  if err != nil {
+    return nil, err.(*Problem)
+  }
+  return req, nil
+}
+
+
+// Return a new nonce. Nonces are kept in the order in which they were
+// created, so that used and expired nonces are dropped from the front, as
+// are the oldest ones if there are more than MAX_NONCES.
+func (self *Server) newNonce() string {
+  nonce := newID()
+  now := time.Now()
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  for len(self.store.nonceOrder) > 0 {
+    oldest := self.store.nonceOrder[0]
+    t, ok := self.store.nonces[oldest]
+    if ok && now.Sub(t) < NONCE_LIFETIME && len(self.store.nonceOrder) < MAX_NONCES {
+      break
+    }
+    delete(self.store.nonces, oldest)
+    self.store.nonceOrder = self.store.nonceOrder[1:]
+  }
+  self.store.nonces[nonce] = now
+  self.store.nonceOrder = append(self.store.nonceOrder, nonce)
+  return nonce
+}
+
+
+// Count the request against the limit of its client address for the
+// current minute, and return false if the limit is exceeded.
+func (self *Server) allowRequest(r *http.Request) bool {
+  client, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err != nil {
+    client = r.RemoteAddr
+  }
+  now := time.Now()
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  if now.Sub(self.store.window) >= time.Minute {
+    self.store.window = now
+    self.store.requests = map[string]int{}
+  }
+  self.store.requests[client]++
+  return self.store.requests[client] <= MAX_REQUESTS_PER_MINUTE
+}
+
+
+func (self *Server) useNonce(nonce string) bool {
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  t, ok := self.store.nonces[nonce]
+  delete(self.store.nonces, nonce)
+  return ok && time.Since(t) < NONCE_LIFETIME
+}
+
+
+func (self *Server) newAccount(w http.ResponseWriter, base string, req *request) *Problem {
+  payload := struct {
+    Contact []string `json:"contact"`
+    TermsOfServiceAgreed bool `json:"termsOfServiceAgreed"`
+    OnlyReturnExisting bool `json:"onlyReturnExisting"`
+  }{}
+  if err := json.Unmarshal(req.payload, &payload); err != nil {
+    return malformed("%s", err)
+  }
+  thumbprint, err := req.jwk.Thumbprint()
+  if err != nil {
+    return newProblem(http.StatusBadRequest, "badPublicKey", "%s", err)
+  }
+
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  if account := self.store.thumbprints[thumbprint]; account != nil {
+    w.Header().Set("Location", account.URL)
+    writeJSON(w, http.StatusOK, account)
+    return nil
+  }
+  if payload.OnlyReturnExisting {
+    return newProblem(http.StatusBadRequest, "accountDoesNotExist",
+      "The account does not exist.")
+  }
+  if problem := checkContacts(payload.Contact); problem != nil {
+    return problem
+  }
+  id := newID()
+  account := &Account{
+    ID: id,
+    URL: base + "/acme/account/" + id,
+    Key: req.jwk,
+    Thumbprint: thumbprint,
+    Status: STATUS_VALID,
+    Contact: payload.Contact,
+    TermsOfServiceAgreed: payload.TermsOfServiceAgreed,
+    Orders: base + "/acme/account/" + id + "/orders",
+  }
+  self.store.accounts[id] = account
+  self.store.thumbprints[thumbprint] = account
+  log.Printf("Created account %s", id)
+  w.Header().Set("Location", account.URL)
+  writeJSON(w, http.StatusCreated, account)
+  return nil
+}
+
+
+func (self *Server) updateAccount(w http.ResponseWriter, id string, req *request) *Problem {
+  if req.account.ID != id {
+    return unauthorized("The request is not signed by the key of the account.")
+  }
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  account := req.account
+  if len(req.payload) > 0 {
+    payload := struct {
+      Contact []string `json:"contact"`
+      Status string `json:"status"`
+    }{}
+    if err := json.Unmarshal(req.payload, &payload); err != nil {
+      return malformed("%s", err)
+    }
+    if payload.Contact != nil {
+      if problem := checkContacts(payload.Contact); problem != nil {
+        return problem
+      }
+      account.Contact = payload.Contact
+    }
+    switch payload.Status {
+      case "":
+      case STATUS_DEACTIVATED:
+        account.Status = STATUS_DEACTIVATED
+        log.Printf("Deactivated account %s", id)
+      default:
+        return malformed("Invalid status: %s", payload.Status)
+    }
+  }
+  writeJSON(w, http.StatusOK, account)
+  return nil
+}
+
+
+func (self *Server) listOrders(w http.ResponseWriter, id string, req *request) *Problem {
+  if req.account.ID != id {
+    return unauthorized("The request is not signed by the key of the account.")
+  }
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  urls := []string{}
+  for _, order := range self.store.orders {
+    if order.AccountID == id {
+      urls = append(urls, order.URL)
+    }
+  }
+  writeJSON(w, http.StatusOK, map[string][]string{"orders": urls})
+  return nil
+}
+
+
+func (self *Server) newOrder(w http.ResponseWriter, base string, req *request) *Problem {
+  payload := struct {
+    Identifiers []Identifier `json:"identifiers"`
+    NotBefore string `json:"notBefore"`
+    NotAfter string `json:"notAfter"`
+  }{}
+  if err := json.Unmarshal(req.payload, &payload); err != nil {
+    return malformed("%s", err)
+  }
+  if payload.NotBefore != "" || payload.NotAfter != "" {
+    return malformed("The validity period is determined by the CA; omit notBefore and notAfter.")
+  }
+  identifiers, problem := self.checkIdentifiers(payload.Identifiers)
+  if problem != nil {
+    return problem
+  }
+
+  now := time.Now()
+  id := newID()
+  order := &Order{
+    ID: id,
+    URL: base + "/acme/order/" + id,
+    AccountID: req.account.ID,
+    Status: STATUS_PENDING,
+    Expires: now.Add(self.orderValidity).UTC(),
+    Identifiers: identifiers,
+    Authorizations: []string{},
+    Finalize: base + "/acme/order/" + id + "/finalize",
+  }
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  for _, identifier := range identifiers {
+    authz := self.newAuthorization(base, req.account, identifier, order.Expires)
+    order.authorizations = append(order.authorizations, authz)
+    order.Authorizations = append(order.Authorizations, authz.URL)
+  }
+  self.store.orders[id] = order
+  w.Header().Set("Location", order.URL)
+  writeJSON(w, http.StatusCreated, order)
+  return nil
+}
+
+
+// Normalize the identifiers of an order and verify that they are allowed
+// by the profile and the name policies of the CA.
+func (self *Server) checkIdentifiers(identifiers []Identifier) ([]Identifier, *Problem) {
+  if len(identifiers) == 0 {
+    return nil, malformed("Specify at least one identifier.")
+  }
+  result := []Identifier{}
+  seen := map[string]bool{}
+  names := []string{}
+  for _, identifier := range identifiers {
+    if identifier.Type != "dns" {
+      return nil, newProblem(http.StatusBadRequest, "unsupportedIdentifier",
+        "Unsupported identifier type: %s", identifier.Type)
+    }
+    name := strings.ToLower(strings.TrimSuffix(identifier.Value, "."))
+    if !validDomainName(name) {
+      return nil, newProblem(http.StatusBadRequest, "rejectedIdentifier",
+        "Invalid domain name: %s", identifier.Value)
+    }
+    if strings.HasPrefix(name, "*.") && !self.offers(CHALLENGE_DNS01) {
+      return nil, newProblem(http.StatusBadRequest, "rejectedIdentifier",
+        "Wildcard names require dns-01 challenges, which are not offered.")
+    }
+    if !seen[name] {
+      seen[name] = true
+      names = append(names, name)
+      result = append(result, Identifier{Type: "dns", Value: name})
+    }
+  }
+  if !self.profile.SubjectAltNames.Allows("dns") {
+    return nil, newProblem(http.StatusBadRequest, "rejectedIdentifier",
+      "The profile does not allow DNS names.")
+  }
+
+  // The subject is determined by the CSR, so only the names are checked
+  // here.
+  policy := self.opts.Policy
+  policy.Subject = dto.X509SubjectPolicy{}
+  profilePolicy := self.profile.Policy
+  profilePolicy.Subject = dto.X509SubjectPolicy{}
+  err := pki.CheckPolicy(&x509.CertificateRequest{DNSNames: names}, &policy, &profilePolicy)
+  if err != nil {
+    return nil, newProblem(http.StatusBadRequest, "rejectedIdentifier", "%s", err)
+  }
+  return result, nil
+}
+
+
+func (self *Server) newAuthorization(base string, account *Account, identifier Identifier, expires time.Time) *Authorization {
+  id := newID()
+  authz := &Authorization{
+    ID: id,
+    URL: base + "/acme/authz/" + id,
+    AccountID: account.ID,
+    Identifier: identifier,
+    Status: STATUS_PENDING,
+    Expires: expires,
+    Challenges: []*Challenge{},
+  }
+  if strings.HasPrefix(identifier.Value, "*.") {
+    authz.Identifier.Value = strings.TrimPrefix(identifier.Value, "*.")
+    authz.Wildcard = true
+  }
+  for _, kind := range self.challenges {
+    if authz.Wildcard && kind != CHALLENGE_DNS01 {
+      continue
+    }
+    cid := newID()
+    challenge := &Challenge{
+      ID: cid,
+      Type: kind,
+      URL: base + "/acme/chall/" + cid,
+      Status: STATUS_PENDING,
+      Token: newID() + newID(),
+      authorization: authz,
+    }
+    authz.Challenges = append(authz.Challenges, challenge)
+    self.store.challenges[cid] = challenge
+  }
+  self.store.authorizations[id] = authz
+  return authz
+}
+
+
+func (self *Server) getOrder(w http.ResponseWriter, id string, req *request) *Problem {
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  order := self.store.orders[id]
+  if order == nil {
+    return notFound()
+  }
+  if order.AccountID != req.account.ID {
+    return unauthorized("The order belongs to another account.")
+  }
+  order.updateStatus(time.Now())
+  writeJSON(w, http.StatusOK, order)
+  return nil
+}
+
+
+func (self *Server) finalize(w http.ResponseWriter, base string, id string, req *request) *Problem {
+  payload := struct {
+    CSR string `json:"csr"`
+  }{}
+  if err := json.Unmarshal(req.payload, &payload); err != nil {
+    return malformed("%s", err)
+  }
+  der, err := base64.RawURLEncoding.DecodeString(payload.CSR)
+  if err != nil {
+    return newProblem(http.StatusBadRequest, "badCSR", "The CSR is not base64url-encoded.")
+  }
+  csr, err := x509.ParseCertificateRequest(der)
+  if err == nil {
+    err = csr.CheckSignature()
+  }
+  if err != nil {
+    return newProblem(http.StatusBadRequest, "badCSR", "%s", err)
+  }
+
+  self.store.mu.Lock()
+  order := self.store.orders[id]
+  var problem *Problem
+  switch {
+    case order == nil:
+      problem = notFound()
+    case order.AccountID != req.account.ID:
+      problem = unauthorized("The order belongs to another account.")
+    default:
+      order.updateStatus(time.Now())
+      if order.Status != STATUS_READY {
+        problem = newProblem(http.StatusForbidden, "orderNotReady",
+          "The order is %s.", order.Status)
+      } else if problem = checkCSR(csr, order.Identifiers); problem == nil {
+        order.Status = STATUS_PROCESSING
+      }
+  }
+  self.store.mu.Unlock()
+  if problem != nil {
+    return problem
+  }
+
+  crt, err := pki.IssueCertificate(self.backend, self.opts, csr, self.profile, false, self.opts)
+
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  if err != nil {
+    order.Status = STATUS_INVALID
+    order.Error = newProblem(http.StatusBadRequest, "badCSR", "%s", err)
+    log.Printf("Order %s: %s", id, err)
+    return order.Error
+  }
+  chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt})
+  self.store.certificates[id] = append(chain, self.chain...)
+  order.Status = STATUS_VALID
+  order.Certificate = base + "/acme/cert/" + id
+  log.Printf("Issued a certificate for order %s (%s)", id, strings.Join(csr.DNSNames, ","))
+  w.Header().Set("Location", order.URL)
+  writeJSON(w, http.StatusOK, order)
+  return nil
+}
+
+
+func (self *Server) getAuthorization(w http.ResponseWriter, id string, req *request) *Problem {
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  authz := self.store.authorizations[id]
+  if authz == nil {
+    return notFound()
+  }
+  if authz.AccountID != req.account.ID {
+    return unauthorized("The authorization belongs to another account.")
+  }
+  if len(req.payload) > 0 {
+    payload := struct {
+      Status string `json:"status"`
+    }{}
+    if err := json.Unmarshal(req.payload, &payload); err != nil {
+      return malformed("%s", err)
+    }
+    if payload.Status != STATUS_DEACTIVATED {
+      return malformed("Invalid status: %s", payload.Status)
+    }
+    authz.Status = STATUS_DEACTIVATED
+  }
+  if authz.Status == STATUS_PENDING && time.Now().After(authz.Expires) {
+    authz.Status = STATUS_INVALID
+  }
+  writeJSON(w, http.StatusOK, authz)
+  return nil
+}
+
+
+// Start the validation of a challenge. The client polls the authorization
+// for the result.
+func (self *Server) respondChallenge(w http.ResponseWriter, id string, req *request) *Problem {
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  challenge := self.store.challenges[id]
+  if challenge == nil {
+    return notFound()
+  }
+  authz := challenge.authorization
+  if authz.AccountID != req.account.ID {
+    return unauthorized("The challenge belongs to another account.")
+  }
+  if len(req.payload) > 0 && challenge.Status == STATUS_PENDING && authz.Status == STATUS_PENDING {
+    challenge.Status = STATUS_PROCESSING
+    go self.runValidation(challenge, authz.Identifier, req.account.Thumbprint)
+  }
+  w.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"up\"", authz.URL))
+  writeJSON(w, http.StatusOK, challenge)
+  return nil
+}
+
+
+func (self *Server) runValidation(challenge *Challenge, identifier Identifier, thumbprint string) {
+  problem := self.validate(challenge, identifier, thumbprint)
+
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  authz := challenge.authorization
+  if problem != nil {
+    challenge.Status = STATUS_INVALID
+    challenge.Error = problem
+    authz.Status = STATUS_INVALID
+    log.Printf("Challenge %s for %s failed: %s", challenge.Type, identifier.Value, problem.Detail)
+    return
+  }
+  now := time.Now().UTC()
+  challenge.Status = STATUS_VALID
+  challenge.Validated = &now
+  authz.Status = STATUS_VALID
+  log.Printf("Challenge %s for %s succeeded", challenge.Type, identifier.Value)
+}
+
+
+func (self *Server) getCertificate(w http.ResponseWriter, id string, req *request) *Problem {
+  self.store.mu.Lock()
+  defer self.store.mu.Unlock()
+  order := self.store.orders[id]
+  chain := self.store.certificates[id]
+  if order == nil || chain == nil {
+    return notFound()
+  }
+  if order.AccountID != req.account.ID {
+    return unauthorized("The certificate belongs to another account.")
+  }
+  w.Header().Set("Content-Type", "application/pem-certificate-chain")
+  w.WriteHeader(http.StatusOK)
+  w.Write(chain)
+  return nil
+}
+
+
+func (self *Server) offers(kind string) bool {
+  for _, c := range self.challenges {
+    if c == kind {
+      return true
+    }
+  }
+  return false
+}
+
+
+// The CSR must request exactly the identifiers of the order. A common name
+// must be one of them.
+func checkCSR(csr *x509.CertificateRequest, identifiers []Identifier) *Problem {
+  if len(csr.EmailAddresses) + len(csr.IPAddresses) + len(csr.URIs) > 0 {
+    return newProblem(http.StatusBadRequest, "badCSR", "The CSR may only contain DNS names.")
+  }
+  expected := map[string]bool{}
+  for _, identifier := range identifiers {
+    expected[identifier.Value] = true
+  }
+  requested := map[string]bool{}
+  for _, name := range csr.DNSNames {
+    requested[strings.ToLower(name)] = true
+  }
+  if cn := strings.ToLower(csr.Subject.CommonName); cn != "" && !expected[cn] {
+    return newProblem(http.StatusBadRequest, "badCSR",
+      "The common name %s is not an identifier of the order.", cn)
+  }
+  for name := range requested {
+    if !expected[name] {
+      return newProblem(http.StatusBadRequest, "badCSR",
+        "%s is not an identifier of the order.", name)
+    }
+  }
+  for name := range expected {
+    if !requested[name] {
+      return newProblem(http.StatusBadRequest, "badCSR",
+        "The CSR does not request %s.", name)
+    }
+  }
+  return nil
+}
+
+
+func checkContacts(contacts []string) *Problem {
+  for _, c := range contacts {
+    if !strings.HasPrefix(c, "mailto:") {
+      return newProblem(http.StatusBadRequest, "unsupportedContact",
+        "Only mailto: contacts are supported.")
+    }
+  }
+  return nil
+}
+
+
+// A domain name consists of labels of letters, digits and hyphens. The
+// first label may be a wildcard.
+func validDomainName(name string) bool {
+  labels := strings.Split(name, ".")
+  if len(labels) < 2 || len(name) > 253 {
+    return false
+  }
+  for i, label := range labels {
+    if i == 0 && label == "*" {
+      continue
+    }
+    if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label) - 1] == '-' {
+      return false
+    }
+    for _, c := range label {
+      if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+
+func writeProblem(w http.ResponseWriter, problem *Problem) {
+  w.Header().Set("Content-Type", "application/problem+json")
+  w.WriteHeader(problem.Status)
+  json.NewEncoder(w).Encode(problem)
+}
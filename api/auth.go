@@ -0,0 +1,164 @@
+package api
+
+import (
+  "crypto/x509"
+  "errors"
+  "fmt"
+  "net"
+  "net/http"
+  "net/url"
+  "regexp"
+  "strings"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/oidc"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+const (
+  OPERATION_X509 = "x509"
+  OPERATION_SSH = "ssh"
+  OPERATION_REVOKE = "revoke"
+)
+
+
+// The authenticated caller of a request.
+type Caller struct {
+  // The common name and Subject Alternative Names of the client
+  // certificate, or the sub claim of the token.
+  Identities []string
+
+  // The claims of the token, if the caller authenticated with a token.
+  Claims oidc.Claims
+}
+
+
+func (self *Caller) String() string {
+  if len(self.Identities) == 0 {
+    return "anonymous"
+  }
+  return self.Identities[0]
+}
+
+
+// Authenticate the caller with the verified client certificate of the
+// connection, or with the bearer token in the Authorization header.
+func (self *Server) authenticate(r *http.Request) (*Caller, error) {
+  if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+    return &Caller{Identities: getCertificateNames(r.TLS.VerifiedChains[0][0])}, nil
+  }
+  auth := r.Header.Get("Authorization")
+  if !strings.HasPrefix(auth, "Bearer ") {
+    return nil, errors.New("Authenticate with a client certificate or a bearer token.")
+  }
+  if self.verifier == nil {
+    return nil, errors.New("Bearer tokens are not accepted.")
+  }
+  claims, err := self.verifier.Verify(strings.TrimPrefix(auth, "Bearer "), time.Now())
+  if err != nil {
+    return nil, err
+  }
+  if claims.String("sub") == "" {
+    return nil, errors.New("The token has no subject.")
+  }
+  return &Caller{Identities: []string{claims.String("sub")}, Claims: claims}, nil
+}
+
+
+// Return nil if a rule allows the caller to request the names from the
+// profile of the CA. The profile is not checked if it is empty.
+func (self *Server) authorize(caller *Caller, operation string, ca string, profile string, names []string) error {
+  for _, rule := range self.conf.Rules {
+    if !matchCaller(&rule, caller) || !matchAny(rule.Operations, operation) || !matchAny(rule.CAs, ca) {
+      continue
+    }
+    if profile != "" && !matchAny(rule.Profiles, profile) {
+      continue
+    }
+    allowed := true
+    for _, name := range names {
+      allowed = allowed && matchAny(rule.Names, name)
+    }
+    if allowed {
+      return nil
+    }
+  }
+  return errors.New(fmt.Sprintf("%s is not allowed to request %s from %s (profile: %s, names: %s).",
+    caller, operation, ca, profile, strings.Join(names, ", ")))
+}
+
+
+func getCertificateNames(crt *x509.Certificate) []string {
+  return getNames(crt.Subject.CommonName, crt.DNSNames, crt.EmailAddresses, crt.IPAddresses, crt.URIs)
+}
+
+
+func getRequestNames(csr *x509.CertificateRequest) []string {
+  return getNames(csr.Subject.CommonName, csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs)
+}
+
+
+// Return the common name and the Subject Alternative Names of a certificate
+// or CSR.
+func getNames(cn string, dns []string, emails []string, ips []net.IP, uris []*url.URL) []string {
+  names := []string{}
+  if cn != "" {
+    names = append(names, cn)
+  }
+  names = append(names, dns...)
+  names = append(names, emails...)
+  for _, ip := range ips {
+    names = append(names, ip.String())
+  }
+  for _, uri := range uris {
+    names = append(names, uri.String())
+  }
+  return names
+}
+
+
+func matchCaller(rule *dto.SigningServiceRule, caller *Caller) bool {
+  if len(rule.Identities) > 0 {
+    matched := false
+    for _, identity := range caller.Identities {
+      matched = matched || matchAny(rule.Identities, identity)
+    }
+    if !matched {
+      return false
+    }
+  }
+  for name, pattern := range rule.Claims {
+    matched := false
+    for _, value := range caller.Claims.Strings(name) {
+      matched = matched || matchPattern(pattern, value)
+    }
+    if !matched {
+      return false
+    }
+  }
+  return true
+}
+
+
+// Return true if s matches any of the patterns, or if there are no
+// patterns.
+func matchAny(patterns []string, s string) bool {
+  if len(patterns) == 0 {
+    return true
+  }
+  for _, pattern := range patterns {
+    if matchPattern(pattern, s) {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Match s against a pattern where * matches any sequence of characters.
+func matchPattern(pattern string, s string) bool {
+  expr := "^" + strings.Replace(regexp.QuoteMeta(pattern), "\\*", ".*", -1) + "$"
+  matched, _ := regexp.MatchString(expr, s)
+  return matched
+}
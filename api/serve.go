@@ -0,0 +1,59 @@
+package api
+
+import (
+  "crypto/tls"
+  "flag"
+  "log"
+  "net/http"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Serve the signing API with the CAs and rules of the configuration file.
+func HandleServe(stdin []byte, args []string, backend backends.Backend) {
+  var confFile string
+  var listen string
+
+  parser := flag.NewFlagSet("serve", flag.ExitOnError)
+  parser.StringVar(&confFile, "config", "",
+    "specifies the configuration file of the signing API.")
+  parser.StringVar(&listen, "listen", "",
+    "specifies the address to listen on (default :8443).")
+  parser.Parse(args)
+
+  if confFile == "" {
+    log.Fatal("The -config parameter is mandatory.")
+  }
+  conf := dto.SigningServiceConfiguration{}
+  err := conf.Load(confFile, nil)
+  if err != nil { log.Fatal(err) }
+  if listen == "" {
+    listen = conf.Listen
+  }
+  if listen == "" {
+    listen = ":8443"
+  }
+
+  server, err := NewServer(backend, &conf)
+  if err != nil { log.Fatal(err) }
+  clientCAs, err := server.ClientCAs()
+  if err != nil { log.Fatal(err) }
+
+  mux := http.NewServeMux()
+  mux.Handle("/v1/", server)
+  httpServer := &http.Server{Addr: listen, Handler: mux}
+  log.Printf("Listening on %s", listen)
+  if conf.TLS.Certificate == "" {
+    if clientCAs != nil {
+      log.Fatal("Client certificates require TLS; configure tls.certificate and tls.key.")
+    }
+    log.Fatal(httpServer.ListenAndServe())
+  }
+  httpServer.TLSConfig = &tls.Config{
+    ClientAuth: tls.VerifyClientCertIfGiven,
+    ClientCAs: clientCAs,
+  }
+  log.Fatal(httpServer.ListenAndServeTLS(conf.TLS.Certificate, conf.TLS.Key))
+}
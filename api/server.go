@@ -0,0 +1,378 @@
+package api
+
+import (
+  "crypto/x509"
+  "encoding/json"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/oidc"
+  sshca "github.com/cochiseruhulessin/cloud-pki/ssh"
+  pki "github.com/cochiseruhulessin/cloud-pki/x509"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/inventory"
+)
+
+
+const MAX_REQUEST_SIZE = 64 * 1024
+
+
+// Serves the signing API, so that callers can obtain certificates without
+// access to the KMS.
+type Server struct {
+  backend backends.Backend
+  conf *dto.SigningServiceConfiguration
+  cas map[string]*dto.X509ConfigurationDTO
+  verifier *oidc.Verifier
+}
+
+
+type x509SigningRequest struct {
+  CA string `json:"ca"`
+  Profile string `json:"profile"`
+  CSR string `json:"csr"`
+}
+
+
+type x509SigningResponse struct {
+  Certificate string `json:"certificate"`
+  Chain string `json:"chain"`
+  Serial string `json:"serial"`
+  NotAfter time.Time `json:"not_after"`
+}
+
+
+type sshSigningRequest struct {
+  CA string `json:"ca"`
+  Profile string `json:"profile"`
+  PublicKey string `json:"public_key"`
+  KeyID string `json:"key_id"`
+  Principals []string `json:"principals"`
+  Validity string `json:"validity"`
+}
+
+
+type sshSigningResponse struct {
+  Certificate string `json:"certificate"`
+  Serial uint64 `json:"serial"`
+  Principals []string `json:"principals"`
+  ValidBefore time.Time `json:"valid_before"`
+}
+
+
+type revocationRequest struct {
+  CA string `json:"ca"`
+  Serial string `json:"serial"`
+  Reason string `json:"reason"`
+}
+
+
+func NewServer(backend backends.Backend, conf *dto.SigningServiceConfiguration) (*Server, error) {
+  if len(conf.CAs) == 0 {
+    return nil, errors.New("Configure at least one CA.")
+  }
+  if conf.TLS.ClientCAs == "" && conf.JWT.JWKS == "" {
+    return nil, errors.New("Configure tls.client-cas or jwt.jwks.")
+  }
+  server := &Server{
+    backend: backend,
+    conf: conf,
+    cas: map[string]*dto.X509ConfigurationDTO{},
+  }
+  for name, fp := range conf.CAs {
+    opts := &dto.X509ConfigurationDTO{}
+    if err := opts.Load(fp, nil); err != nil {
+      return nil, errors.New(fmt.Sprintf("%s: %s", fp, err))
+    }
+    server.cas[name] = opts
+  }
+  if conf.JWT.JWKS != "" {
+    if conf.JWT.Issuer == "" || conf.JWT.Audience == "" {
+      return nil, errors.New("Configure jwt.issuer and jwt.audience.")
+    }
+    server.verifier = oidc.NewVerifier(conf.JWT.Issuer, conf.JWT.Audience, conf.JWT.JWKS)
+  }
+  return server, nil
+}
+
+
+// Return the CAs that issue client certificates, or nil if clients can not
+// authenticate with certificates.
+func (self *Server) ClientCAs() (*x509.CertPool, error) {
+  if self.conf.TLS.ClientCAs == "" {
+    return nil, nil
+  }
+  buf, err := ioutil.ReadFile(self.conf.TLS.ClientCAs)
+  if err != nil {
+    return nil, err
+  }
+  pool := x509.NewCertPool()
+  if !pool.AppendCertsFromPEM(buf) {
+    return nil, errors.New(fmt.Sprintf("No certificates found in %s", self.conf.TLS.ClientCAs))
+  }
+  return pool, nil
+}
+
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if strings.HasPrefix(r.URL.Path, "/v1/ca/") && r.Method == http.MethodGet {
+    self.getCACertificate(w, strings.TrimPrefix(r.URL.Path, "/v1/ca/"))
+    return
+  }
+  var handler func(http.ResponseWriter, *http.Request, *Caller)
+  switch r.URL.Path {
+    case "/v1/x509/sign":
+      handler = self.signCertificateRequest
+    case "/v1/ssh/sign":
+      handler = self.signPublicKey
+    case "/v1/x509/revoke":
+      handler = self.revokeCertificate
+    default:
+      writeError(w, http.StatusNotFound, errors.New("Not found."))
+      return
+  }
+  if r.Method != http.MethodPost {
+    writeError(w, http.StatusMethodNotAllowed, errors.New("Use POST."))
+    return
+  }
+  caller, err := self.authenticate(r)
+  if err != nil {
+    writeError(w, http.StatusUnauthorized, err)
+    return
+  }
+  handler(w, r, caller)
+}
+
+
+func (self *Server) getCA(name string) (*dto.X509ConfigurationDTO, error) {
+  opts, ok := self.cas[name]
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("Unknown CA: %s", name))
+  }
+  return opts, nil
+}
+
+
+func (self *Server) getCACertificate(w http.ResponseWriter, name string) {
+  opts, err := self.getCA(name)
+  if err != nil {
+    writeError(w, http.StatusNotFound, err)
+    return
+  }
+  crt, err := opts.GetSignerCertificate()
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  w.Header().Set("Content-Type", "application/pem-certificate-chain")
+  w.WriteHeader(http.StatusOK)
+  pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})
+}
+
+
+func (self *Server) signCertificateRequest(w http.ResponseWriter, r *http.Request, caller *Caller) {
+  req := x509SigningRequest{}
+  if err := decodeRequest(w, r, &req); err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  opts, err := self.getCA(req.CA)
+  if err != nil {
+    writeError(w, http.StatusNotFound, err)
+    return
+  }
+  profileName := req.Profile
+  if profileName == "" {
+    profileName = opts.Defaults.Profile
+  }
+  profile, err := opts.GetProfile(profileName)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  block, _ := pem.Decode([]byte(req.CSR))
+  if block == nil {
+    writeError(w, http.StatusBadRequest, errors.New("The CSR must be PEM-encoded."))
+    return
+  }
+  csr, err := x509.ParseCertificateRequest(block.Bytes)
+  if err == nil {
+    err = csr.CheckSignature()
+  }
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  if err := self.authorize(caller, OPERATION_X509, req.CA, profileName, getRequestNames(csr)); err != nil {
+    writeError(w, http.StatusForbidden, err)
+    return
+  }
+
+  der, err := pki.IssueCertificate(self.backend, opts, csr, profile, false, opts)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  crt, err := x509.ParseCertificate(der)
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  issuer, err := opts.GetSignerCertificate()
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  log.Printf("Issued certificate %x for %s to %s (CA: %s, profile: %s)", crt.SerialNumber,
+    crt.Subject, caller, req.CA, profileName)
+  writeJSON(w, http.StatusOK, x509SigningResponse{
+    Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+    Chain: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})),
+    Serial: fmt.Sprintf("%x", crt.SerialNumber),
+    NotAfter: crt.NotAfter.UTC(),
+  })
+}
+
+
+func (self *Server) signPublicKey(w http.ResponseWriter, r *http.Request, caller *Caller) {
+  req := sshSigningRequest{}
+  if err := decodeRequest(w, r, &req); err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  opts, err := self.getCA(req.CA)
+  if err != nil {
+    writeError(w, http.StatusNotFound, err)
+    return
+  }
+  profileName := req.Profile
+  if profileName == "" {
+    profileName = opts.SecureShell.DefaultProfile
+  }
+  if len(req.Principals) == 0 {
+    writeError(w, http.StatusBadRequest, errors.New("Specify the principals."))
+    return
+  }
+  if err := self.authorize(caller, OPERATION_SSH, req.CA, profileName, req.Principals); err != nil {
+    writeError(w, http.StatusForbidden, err)
+    return
+  }
+  // The key ID identifies the user in the logs of sshd, so it is always
+  // the identity of the caller.
+  if req.KeyID != "" && req.KeyID != caller.String() {
+    writeError(w, http.StatusForbidden, errors.New(fmt.Sprintf(
+      "The key ID must be the identity of the caller, %s.", caller)))
+    return
+  }
+  key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  cr := sshca.CertificateRequest{
+    KeyID: caller.String(),
+    Principals: req.Principals,
+  }
+  if req.Validity != "" {
+    if cr.Validity, err = dto.ParseDuration(req.Validity); err != nil {
+      writeError(w, http.StatusBadRequest, err)
+      return
+    }
+  }
+
+  crt, err := sshca.SignSshPublicKey(self.backend, key, opts, profileName, &cr)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  log.Printf("Issued SSH certificate %d for %s to %s (CA: %s, principals: %s)", crt.Serial,
+    crt.KeyId, caller, req.CA, strings.Join(crt.ValidPrincipals, ","))
+  writeJSON(w, http.StatusOK, sshSigningResponse{
+    Certificate: string(ssh.MarshalAuthorizedKey(crt)),
+    Serial: crt.Serial,
+    Principals: crt.ValidPrincipals,
+    ValidBefore: time.Unix(int64(crt.ValidBefore), 0).UTC(),
+  })
+}
+
+
+// Record the revocation of a certificate in the inventory of the CA. The
+// caller must be allowed to request the names of the certificate. This does
+// not revoke the certificate for relying parties: cloud-pki publishes no
+// CRL or OCSP response, so only x509 verify -inventory reads the record.
+func (self *Server) revokeCertificate(w http.ResponseWriter, r *http.Request, caller *Caller) {
+  req := revocationRequest{}
+  if err := decodeRequest(w, r, &req); err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  opts, err := self.getCA(req.CA)
+  if err != nil {
+    writeError(w, http.StatusNotFound, err)
+    return
+  }
+  if opts.Inventory == "" {
+    writeError(w, http.StatusBadRequest, errors.New(fmt.Sprintf("The CA %s has no inventory.", req.CA)))
+    return
+  }
+  issuer, err := opts.GetSignerCertificate()
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  serial := strings.ToLower(strings.Replace(req.Serial, ":", "", -1))
+  inv := inventory.Open(opts.Inventory)
+  issued, _, err := inv.Find(issuer.Subject.String(), serial)
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  if issued == nil {
+    writeError(w, http.StatusNotFound, errors.New(fmt.Sprintf("Unknown certificate: %s", serial)))
+    return
+  }
+  crt, err := x509.ParseCertificate(issued.Certificate)
+  if err != nil {
+    writeError(w, http.StatusInternalServerError, err)
+    return
+  }
+  if err := self.authorize(caller, OPERATION_REVOKE, req.CA, "", getCertificateNames(crt)); err != nil {
+    writeError(w, http.StatusForbidden, err)
+    return
+  }
+  record, err := inv.Revoke(issuer.Subject.String(), serial, req.Reason)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  log.Printf("Revoked certificate %s of %s for %s (CA: %s, reason: %s)", serial, crt.Subject,
+    caller, req.CA, record.Reason)
+  writeJSON(w, http.StatusOK, record)
+}
+
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) error {
+  decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE))
+  return decoder.Decode(v)
+}
+
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+
+func writeError(w http.ResponseWriter, status int, err error) {
+  writeJSON(w, status, map[string]string{"error": err.Error()})
+}
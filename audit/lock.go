@@ -0,0 +1,19 @@
+package audit
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "syscall"
+)
+
+
+// Take an exclusive lock on f, waiting for other processes that hold it.
+// The lock serializes appends to the file across processes; the kernel
+// releases it when f is closed, also when the process is killed.
+func Lock(f *os.File) error {
+  if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+    return errors.New(fmt.Sprintf("Unable to lock %s: %s", f.Name(), err))
+  }
+  return nil
+}
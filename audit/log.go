@@ -0,0 +1,232 @@
+package audit
+
+import (
+  "bufio"
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "os/user"
+  "sync"
+  "time"
+)
+
+
+const (
+  OPERATION_X509_CERTIFICATE = "x509-certificate"
+  OPERATION_X509_REQUEST = "x509-request"
+  OPERATION_SSH_CERTIFICATE = "ssh-certificate"
+  OPERATION_SSH_SIGNATURE = "ssh-signature"
+)
+
+
+// The size of the tail of the log that is read to find the last record.
+const TAIL_SIZE = 64 * 1024
+
+
+// Serializes appends to the audit logs of this process; a lock on the file
+// serializes them across processes.
+var mu sync.Mutex
+
+
+// An append-only log of the signatures made with the keys of the CAs, with
+// one JSON record per line. Each record contains the hash of the line that
+// precedes it, so that records can not be changed, removed or reordered
+// without breaking the chain.
+//
+// A record is appended after the signature is made, because it contains
+// the hash of what was signed. If the process fails in between, e.g. when
+// the log can not be written, the signature exists without a record; the
+// signature is then not returned, but the KMS audit logs still show it.
+type Log struct {
+  Path string
+}
+
+
+// A line of the audit log. Sequence numbers start at 1; the first record
+// has no previous hash.
+type Record struct {
+  Sequence uint64 `json:"seq"`
+  Time time.Time `json:"time"`
+  Operation string `json:"operation"`
+
+  // The identifier of the key that signed.
+  CA string `json:"ca"`
+  Issuer string `json:"issuer,omitempty"`
+  Subject string `json:"subject,omitempty"`
+  Serial string `json:"serial,omitempty"`
+
+  // The SHA-256 hash of the data that was signed, e.g. the
+  // TBSCertificate, in hexadecimal.
+  TBSHash string `json:"tbs_sha256"`
+  Operator string `json:"operator"`
+  Previous string `json:"prev,omitempty"`
+}
+
+
+func Open(path string) *Log {
+  return &Log{Path: path}
+}
+
+
+// Return a record of a signature over tbs, made with the key of the CA.
+func NewRecord(operation string, ca string, tbs []byte) *Record {
+  digest := sha256.Sum256(tbs)
+  return &Record{
+    Operation: operation,
+    CA: ca,
+    TBSHash: hex.EncodeToString(digest[:]),
+    Operator: Operator(),
+  }
+}
+
+
+// Return the user and host that run this process.
+func Operator() string {
+  name := "unknown"
+  if u, err := user.Current(); err == nil {
+    name = u.Username
+  }
+  host, err := os.Hostname()
+  if err != nil {
+    return name
+  }
+  return name + "@" + host
+}
+
+
+// Append the record to the log, after the last record. The sequence number,
+// time and previous hash of the record are set.
+func (self *Log) Append(record *Record) error {
+  mu.Lock()
+  defer mu.Unlock()
+  f, err := os.OpenFile(self.Path, os.O_RDWR | os.O_APPEND | os.O_CREATE, 0600)
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  if err := Lock(f); err != nil {
+    return err
+  }
+  last, err := readLastLine(f)
+  if err != nil {
+    return err
+  }
+  record.Sequence = 1
+  record.Previous = ""
+  if last != nil {
+    previous := Record{}
+    if err := json.Unmarshal(last, &previous); err != nil {
+      return errors.New(fmt.Sprintf("%s: the last record is invalid: %s", self.Path, err))
+    }
+    record.Sequence = previous.Sequence + 1
+    record.Previous = Hash(last)
+  }
+  record.Time = time.Now().UTC()
+  buf, err := json.Marshal(record)
+  if err != nil {
+    return err
+  }
+  if _, err = f.Write(append(buf, '\n')); err != nil {
+    return err
+  }
+  return f.Sync()
+}
+
+
+// The result of verifying the chain of an audit log.
+type Verification struct {
+  Records uint64
+
+  // The hash of the last record, which may be kept elsewhere to detect
+  // that records were removed from the end of the log.
+  Head string
+  Errors []error
+}
+
+
+// Check that the sequence numbers have no gaps and that each record
+// contains the hash of the record that precedes it. If head is not empty,
+// the log must contain a record with that hash.
+func (self *Log) Verify(head string) (*Verification, error) {
+  f, err := os.Open(self.Path)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  result := &Verification{Errors: []error{}}
+  fail := func(n int, format string, args ...interface{}) {
+    result.Errors = append(result.Errors,
+      errors.New(fmt.Sprintf("%s:%d: ", self.Path, n) + fmt.Sprintf(format, args...)))
+  }
+  foundHead := false
+  previous := ""
+  scanner := bufio.NewScanner(f)
+  scanner.Buffer(make([]byte, TAIL_SIZE), TAIL_SIZE)
+  for n := 1; scanner.Scan(); n++ {
+    line := scanner.Bytes()
+    record := Record{}
+    if err := json.Unmarshal(line, &record); err != nil {
+      fail(n, "invalid record: %s", err)
+      previous = Hash(line)
+      continue
+    }
+    result.Records++
+    if record.Sequence != result.Records {
+      fail(n, "expected sequence number %d, found %d", result.Records, record.Sequence)
+      result.Records = record.Sequence
+    }
+    if record.Previous != previous {
+      fail(n, "the previous hash does not match the record before it")
+    }
+    previous = Hash(line)
+    foundHead = foundHead || previous == head
+  }
+  if err := scanner.Err(); err != nil {
+    return nil, err
+  }
+  result.Head = previous
+  if head != "" && !foundHead {
+    result.Errors = append(result.Errors,
+      errors.New(fmt.Sprintf("%s: no record has hash %s", self.Path, head)))
+  }
+  return result, nil
+}
+
+
+// Return the hash of a line of the log, in hexadecimal.
+func Hash(line []byte) string {
+  digest := sha256.Sum256(line)
+  return hex.EncodeToString(digest[:])
+}
+
+
+// Return the last non-empty line of f, or nil if it is empty.
+func readLastLine(f *os.File) ([]byte, error) {
+  info, err := f.Stat()
+  if err != nil {
+    return nil, err
+  }
+  offset := info.Size() - TAIL_SIZE
+  if offset < 0 {
+    offset = 0
+  }
+  buf := make([]byte, info.Size() - offset)
+  if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+    return nil, err
+  }
+  lines := bytes.Split(bytes.TrimRight(buf, "\n"), []byte("\n"))
+  last := lines[len(lines) - 1]
+  if len(last) == 0 {
+    return nil, nil
+  }
+  if len(lines) == 1 && offset > 0 {
+    return nil, errors.New("The last record of the audit log is too large.")
+  }
+  return last, nil
+}
@@ -0,0 +1,40 @@
+package audit
+
+import (
+  "flag"
+  "fmt"
+  "log"
+  "os"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+)
+
+
+// Verify the hash chain of the audit logs given as arguments. The command
+// exits with a non-zero status if a record was changed, removed or
+// inserted.
+func HandleVerify(stdin []byte, args []string, backend backends.Backend) {
+  var head string
+
+  parser := flag.NewFlagSet("verify", flag.ExitOnError)
+  parser.StringVar(&head, "head", "",
+    "specifies the hash of a record that the log must contain, e.g. a previously reported head.")
+  parser.Parse(args)
+
+  if parser.NArg() == 0 {
+    log.Fatal("Specify the audit log.")
+  }
+  valid := true
+  for _, fp := range parser.Args() {
+    result, err := Open(fp).Verify(head)
+    if err != nil { log.Fatal(err) }
+    for _, err := range result.Errors {
+      fmt.Fprintln(os.Stderr, err)
+    }
+    valid = valid && len(result.Errors) == 0
+    fmt.Printf("%s: %d records, head %s\n", fp, result.Records, result.Head)
+  }
+  if !valid {
+    os.Exit(1)
+  }
+}
@@ -0,0 +1,81 @@
+package backends
+
+import (
+  "crypto"
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "log"
+
+  "golang.org/x/crypto/ssh"
+)
+
+
+// A backend with unencrypted private keys in PEM files (PKCS#1, PKCS#8 or
+// SEC 1); the key identifier is the path of the file. It is intended for
+// keys that do not need the protection of a KMS, such as the decryption
+// key of a SCEP registration authority.
+type FileBackend struct {}
+
+
+func NewFileBackend() (FileBackend) {
+  return FileBackend{}
+}
+
+
+func (self *FileBackend) GetSigner(keyid string) crypto.Signer {
+  key, err := self.loadKey(keyid)
+  if err != nil {
+    log.Fatal(err)
+  }
+  signer, ok := key.(crypto.Signer)
+  if !ok {
+    log.Fatal(fmt.Sprintf("The key in %s can not sign.", keyid))
+  }
+  return signer
+}
+
+
+func (self *FileBackend) GetSecureShellSigner(keyid string) ssh.Signer {
+  signer, err := ssh.NewSignerFromSigner(self.GetSigner(keyid))
+  if err != nil { log.Fatal(err) }
+
+  return signer
+}
+
+
+func (self *FileBackend) GetDecrypter(keyid string) crypto.Decrypter {
+  key, err := self.loadKey(keyid)
+  if err != nil {
+    log.Fatal(err)
+  }
+  decrypter, ok := key.(crypto.Decrypter)
+  if !ok {
+    log.Fatal(fmt.Sprintf("The key in %s can not decrypt.", keyid))
+  }
+  return decrypter
+}
+
+
+func (self *FileBackend) loadKey(fp string) (interface{}, error) {
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil {
+    return nil, err
+  }
+  block, _ := pem.Decode(buf)
+  if block == nil {
+    return nil, errors.New(fmt.Sprintf("No PEM data found in %s", fp))
+  }
+  switch block.Type {
+    case "RSA PRIVATE KEY":
+      return x509.ParsePKCS1PrivateKey(block.Bytes)
+    case "EC PRIVATE KEY":
+      return x509.ParseECPrivateKey(block.Bytes)
+    case "PRIVATE KEY":
+      return x509.ParsePKCS8PrivateKey(block.Bytes)
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported PEM block in %s: %s", fp, block.Type))
+  }
+}
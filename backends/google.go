@@ -3,12 +3,16 @@ package backends
 import (
   "context"
   "crypto"
+  "crypto/rsa"
   "crypto/x509"
   "encoding/base64"
   "encoding/pem"
+  "errors"
+  "fmt"
   "io"
   "log"
   "net/http"
+  "sync"
 
   "golang.org/x/crypto/ssh"
   "golang.org/x/oauth2/google"
@@ -16,6 +20,10 @@ import (
 )
 
 
+// Guards the lazy initialization of GoogleBackend.client.
+var clientMu sync.Mutex
+
+
 type GoogleBackend struct {
   client *http.Client;
 }
@@ -28,20 +36,39 @@ type GoogleSigner struct {
 }
 
 
+// Decrypts with an asymmetric decryption key. Cloud KMS supports RSA-OAEP
+// only; the hash function is determined by the algorithm of the key.
+type GoogleDecrypter struct {
+  service       *cloudkms.Service
+  keyid         string
+  publicKey     crypto.PublicKey
+}
+
+
 func NewGoogleBackend() (GoogleBackend) {
-  self := GoogleBackend{}
-  client, err := google.DefaultClient(context.Background(),
-    cloudkms.CloudPlatformScope)
-  if err != nil {
-    log.Fatal(err)
+  return GoogleBackend{}
+}
+
+
+// The HTTP client is created on first use, so that operations that do not
+// need the KMS (such as verification) work without credentials.
+func (self *GoogleBackend) getClient() *http.Client {
+  clientMu.Lock()
+  defer clientMu.Unlock()
+  if self.client == nil {
+    client, err := google.DefaultClient(context.Background(),
+      cloudkms.CloudPlatformScope)
+    if err != nil {
+      log.Fatal(err)
+    }
+    self.client = client
   }
-  self.client = client
-  return self
+  return self.client
 }
 
 
 func (self *GoogleBackend) configureService(keyid string) (*cloudkms.Service, crypto.PublicKey, error) {
-  service, err := cloudkms.New(self.client)
+  service, err := cloudkms.New(self.getClient())
   if err != nil { return nil, nil, err }
 
   response, err := service.
@@ -85,7 +112,7 @@ func (self *GoogleBackend) GetSecureShellSigner(keyid string) ssh.Signer {
 
 
 func (self *GoogleBackend) GetSigner(keyid string) crypto.Signer {
-  service, err := cloudkms.New(self.client)
+  service, err := cloudkms.New(self.getClient())
   if err != nil {
     log.Fatal(err)
   }
@@ -122,16 +149,60 @@ func (self *GoogleSigner) Public() crypto.PublicKey {
 func (self *GoogleSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) (signature []byte, err error) {
   digest64 := base64.StdEncoding.EncodeToString(digest)
   req := &cloudkms.AsymmetricSignRequest{
-    Digest: &cloudkms.Digest{
-      Sha256: digest64, // TODO: sha256 needs to follow sign algo
-    },
+    Digest: &cloudkms.Digest{},
+  }
+  switch opts.HashFunc() {
+    case crypto.SHA256:
+      req.Digest.Sha256 = digest64
+    case crypto.SHA384:
+      req.Digest.Sha384 = digest64
+    case crypto.SHA512:
+      req.Digest.Sha512 = digest64
+    case 0:
+      return nil, errors.New("Signing unhashed messages is not supported by Cloud KMS.")
+    default:
+      return nil, fmt.Errorf("Unsupported digest algorithm: %v", opts.HashFunc())
   }
   response, err := self.service.
     Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
     AsymmetricSign(self.keyid, req).Context(context.Background()).Do()
   if err != nil {
-    log.Fatal(err) // Error here was ignored by the caller.
     return nil, err
   }
   return base64.StdEncoding.DecodeString(response.Signature)
 }
+
+
+func (self *GoogleBackend) GetDecrypter(keyid string) crypto.Decrypter {
+  service, publicKey, err := self.configureService(keyid)
+  if err != nil {
+    log.Fatal(err)
+  }
+  return &GoogleDecrypter{
+    service   : service,
+    keyid     : keyid,
+    publicKey : publicKey,
+  }
+}
+
+
+func (self *GoogleDecrypter) Public() crypto.PublicKey {
+  return self.publicKey
+}
+
+
+func (self *GoogleDecrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+  if _, ok := opts.(*rsa.OAEPOptions); !ok {
+    return nil, errors.New("Cloud KMS supports RSA-OAEP decryption only.")
+  }
+  req := &cloudkms.AsymmetricDecryptRequest{
+    Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+  }
+  response, err := self.service.
+    Projects.Locations.KeyRings.CryptoKeys.CryptoKeyVersions.
+    AsymmetricDecrypt(self.keyid, req).Context(context.Background()).Do()
+  if err != nil {
+    return nil, err
+  }
+  return base64.StdEncoding.DecodeString(response.Plaintext)
+}
@@ -10,4 +10,5 @@ import (
 type Backend interface {
   GetSigner(string) crypto.Signer
   GetSecureShellSigner(string) ssh.Signer
+  GetDecrypter(string) crypto.Decrypter
 }
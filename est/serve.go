@@ -0,0 +1,58 @@
+package est
+
+import (
+  "crypto/tls"
+  "flag"
+  "log"
+  "net/http"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Serve the EST operations at /.well-known/est/. EST requires TLS; clients
+// authenticate with HTTP basic authentication or a TLS client certificate.
+func HandleServe(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var listen string
+  var tlsCert string
+  var tlsKey string
+
+  parser := flag.NewFlagSet("serve", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&listen, "listen", ":8443",
+    "specifies the address to listen on.")
+  parser.StringVar(&tlsCert, "tls-cert", "",
+    "specifies the TLS certificate of the server.")
+  parser.StringVar(&tlsKey, "tls-key", "",
+    "specifies the TLS private key of the server.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  if tlsCert == "" || tlsKey == "" {
+    log.Fatal("The -tls-cert and -tls-key parameters are mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  server, err := NewServer(backend, &opts)
+  if err != nil { log.Fatal(err) }
+
+  mux := http.NewServeMux()
+  mux.Handle("/.well-known/est/", server)
+  httpServer := &http.Server{
+    Addr: listen,
+    Handler: mux,
+    TLSConfig: &tls.Config{
+      ClientAuth: tls.VerifyClientCertIfGiven,
+      ClientCAs: server.ClientCAs(),
+    },
+  }
+  log.Printf("Listening on %s", listen)
+  log.Fatal(httpServer.ListenAndServeTLS(tlsCert, tlsKey))
+}
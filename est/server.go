@@ -0,0 +1,253 @@
+package est
+
+import (
+  "crypto/x509"
+  "encoding/asn1"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "strings"
+
+  "golang.org/x/crypto/bcrypt"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  pki "github.com/cochiseruhulessin/cloud-pki/x509"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/oid"
+  "github.com/cochiseruhulessin/cloud-pki/x509/pkcs7"
+)
+
+
+const MAX_REQUEST_SIZE = 64 * 1024
+
+
+// An EST (RFC 7030) server that issues certificates with the CA and
+// profile that are specified in its configuration.
+type Server struct {
+  backend backends.Backend
+  opts *dto.X509ConfigurationDTO
+  profile *dto.X509Profile
+  issuer *x509.Certificate
+  clientCAs *x509.CertPool
+  enrollmentCAs *x509.CertPool
+}
+
+
+type attribute struct {
+  Type asn1.ObjectIdentifier
+  Values []asn1.ObjectIdentifier `asn1:"set"`
+}
+
+
+func NewServer(backend backends.Backend, opts *dto.X509ConfigurationDTO) (*Server, error) {
+  profile, err := opts.GetProfile(opts.Est.Profile)
+  if err != nil {
+    return nil, err
+  }
+  issuer, err := opts.GetSignerCertificate()
+  if err != nil {
+    return nil, err
+  }
+  clientCAs := x509.NewCertPool()
+  clientCAs.AddCert(issuer)
+  var enrollmentCAs *x509.CertPool
+  if opts.Est.ClientCAs != "" {
+    buf, err := ioutil.ReadFile(opts.Est.ClientCAs)
+    if err != nil {
+      return nil, err
+    }
+    enrollmentCAs = x509.NewCertPool()
+    if !clientCAs.AppendCertsFromPEM(buf) || !enrollmentCAs.AppendCertsFromPEM(buf) {
+      return nil, errors.New(fmt.Sprintf("No certificates found in %s", opts.Est.ClientCAs))
+    }
+  }
+  return &Server{
+    backend: backend,
+    opts: opts,
+    profile: profile,
+    issuer: issuer,
+    clientCAs: clientCAs,
+    enrollmentCAs: enrollmentCAs,
+  }, nil
+}
+
+
+// Return the CAs that issue the TLS client certificates of the clients.
+func (self *Server) ClientCAs() *x509.CertPool {
+  return self.clientCAs
+}
+
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  switch op := strings.TrimPrefix(r.URL.Path, "/.well-known/est/"); {
+    case op == "cacerts" && r.Method == http.MethodGet:
+      self.getCACertificates(w)
+    case op == "csrattrs" && r.Method == http.MethodGet:
+      self.getCSRAttributes(w)
+    case op == "simpleenroll" && r.Method == http.MethodPost:
+      self.enroll(w, r, false)
+    case op == "simplereenroll" && r.Method == http.MethodPost:
+      self.enroll(w, r, true)
+    case op == "cacerts" || op == "csrattrs" || op == "simpleenroll" || op == "simplereenroll":
+      http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+    default:
+      http.NotFound(w, r)
+  }
+}
+
+
+func (self *Server) getCACertificates(w http.ResponseWriter) {
+  buf, err := pkcs7.CertificatesOnly(self.issuer.Raw)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  writeBase64(w, "application/pkcs7-mime", buf)
+}
+
+
+// Return the attributes that clients include in their CSRs: the subject
+// attributes that the policies require and, if the profile requires a
+// Subject Alternative Name, the SAN extension.
+func (self *Server) getCSRAttributes(w http.ResponseWriter) {
+  attrs := []interface{}{}
+  required := append([]string{}, self.opts.Policy.Subject.Require...)
+  required = append(required, self.profile.Policy.Subject.Require...)
+  seen := map[string]bool{}
+  for _, name := range required {
+    t, err := oid.LookupAttribute(name)
+    if err != nil {
+      http.Error(w, err.Error(), http.StatusInternalServerError)
+      return
+    }
+    if !seen[t.String()] {
+      seen[t.String()] = true
+      attrs = append(attrs, t)
+    }
+  }
+  if self.profile.SubjectAltNames.Required {
+    attrs = append(attrs, attribute{oid.OID_EXTENSION_REQUEST, []asn1.ObjectIdentifier{oid.OID_SAN}})
+  }
+  if len(attrs) == 0 {
+    w.WriteHeader(http.StatusNoContent)
+    return
+  }
+  buf, err := asn1.Marshal(attrs)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  writeBase64(w, "application/csrattrs", buf)
+}
+
+
+// Issue a certificate for the CSR in the request. Initial enrollment
+// requires a password or a client certificate from est.client-cas; a
+// certificate issued by the CA only authorizes re-enrollment with the same
+// subject and Subject Alternative Names as the CSR.
+func (self *Server) enroll(w http.ResponseWriter, r *http.Request, renew bool) {
+  client, ok := self.authenticate(r, renew)
+  if !ok || (renew && client == nil) {
+    w.Header().Set("WWW-Authenticate", "Basic realm=\"est\"")
+    http.Error(w, "Authentication required.", http.StatusUnauthorized)
+    return
+  }
+  body, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE + 1))
+  if err != nil || len(body) > MAX_REQUEST_SIZE {
+    http.Error(w, "The request is too large.", http.StatusRequestEntityTooLarge)
+    return
+  }
+  der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+  if err != nil {
+    http.Error(w, "The CSR must be base64-encoded.", http.StatusBadRequest)
+    return
+  }
+  csr, err := x509.ParseCertificateRequest(der)
+  if err == nil {
+    err = csr.CheckSignature()
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if renew {
+    if client.CheckSignatureFrom(self.issuer) != nil {
+      http.Error(w, "The client certificate is not issued by this CA.", http.StatusForbidden)
+      return
+    }
+    if err := pki.CheckReenrollment(client, csr); err != nil {
+      http.Error(w, err.Error(), http.StatusBadRequest)
+      return
+    }
+  }
+
+  crt, err := pki.IssueCertificate(self.backend, self.opts, csr, self.profile, false, self.opts)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  log.Printf("Issued a certificate for %s", csr.Subject)
+  buf, err := pkcs7.CertificatesOnly(crt)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  writeBase64(w, "application/pkcs7-mime; smime-type=certs-only", buf)
+}
+
+
+// Return the client certificate if the client authenticated with one, and
+// whether the client is authenticated at all. For initial enrollment, the
+// client certificate must be issued by one of est.client-cas.
+func (self *Server) authenticate(r *http.Request, renew bool) (*x509.Certificate, bool) {
+  if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+    client := r.TLS.VerifiedChains[0][0]
+    if renew || self.isEnrollmentClient(client, r.TLS.PeerCertificates) {
+      return client, true
+    }
+  }
+  username, password, ok := r.BasicAuth()
+  if !ok {
+    return nil, false
+  }
+  hash, ok := self.opts.Est.Users[username]
+  if !ok {
+    return nil, false
+  }
+  return nil, bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+
+// Return true if the client certificate is issued by one of est.client-cas.
+func (self *Server) isEnrollmentClient(client *x509.Certificate, peers []*x509.Certificate) bool {
+  if self.enrollmentCAs == nil {
+    return false
+  }
+  intermediates := x509.NewCertPool()
+  for _, crt := range peers[1:] {
+    intermediates.AddCert(crt)
+  }
+  _, err := client.Verify(x509.VerifyOptions{
+    Roots: self.enrollmentCAs,
+    Intermediates: intermediates,
+    KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+  })
+  return err == nil
+}
+
+
+func writeBase64(w http.ResponseWriter, contentType string, buf []byte) {
+  encoded := base64.StdEncoding.EncodeToString(buf)
+  w.Header().Set("Content-Type", contentType)
+  w.Header().Set("Content-Transfer-Encoding", "base64")
+  w.WriteHeader(http.StatusOK)
+  for len(encoded) > 64 {
+    io.WriteString(w, encoded[:64] + "\r\n")
+    encoded = encoded[64:]
+  }
+  io.WriteString(w, encoded + "\r\n")
+}
@@ -6,7 +6,12 @@ import (
   "log"
   "os"
 
+  "github.com/cochiseruhulessin/cloud-pki/acme"
+  "github.com/cochiseruhulessin/cloud-pki/api"
+  "github.com/cochiseruhulessin/cloud-pki/audit"
   "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/est"
+  "github.com/cochiseruhulessin/cloud-pki/scep"
   "github.com/cochiseruhulessin/cloud-pki/ssh"
   "github.com/cochiseruhulessin/cloud-pki/x509"
 )
@@ -32,6 +37,18 @@ func main() {
 
   backend := backends.NewGoogleBackend()
   switch op := os.Args[1]; op {
+    case "acme":
+      acme.Handle(buf, os.Args[2:], &backend)
+    case "audit":
+      audit.Handle(buf, os.Args[2:], &backend)
+    case "est":
+      est.Handle(buf, os.Args[2:], &backend)
+    case "serve":
+      api.HandleServe(buf, os.Args[2:], &backend)
+    case "scep":
+      scep.Handle(buf, os.Args[2:], &backend)
+    case "sign-digest":
+      x509.HandleSignDigest(buf, os.Args[2:], &backend)
     case "ssh":
       ssh.Handle(buf, os.Args[2:], &backend)
     case "x509":
@@ -0,0 +1,289 @@
+package offline
+
+import (
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/rsa"
+  "crypto/x509"
+  "encoding/asn1"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "math/big"
+
+  "golang.org/x/crypto/ssh"
+)
+
+
+const (
+  TYPE_X509_CERTIFICATE = "x509-certificate"
+  TYPE_SSH_CERTIFICATE = "ssh-certificate"
+)
+
+
+// The hash functions that a bundle may specify, by name.
+var HASHES = map[string]crypto.Hash{
+  "SHA-256": crypto.SHA256,
+  "SHA-384": crypto.SHA384,
+  "SHA-512": crypto.SHA512,
+}
+
+
+// The data to be signed by the key of a CA on another host, and its
+// signature once it is signed. A bundle is prepared where the certificate
+// is built, signed where the key can be used, and assembled into the
+// certificate where it was prepared.
+type Bundle struct {
+  Type string `json:"type"`
+  Issuer string `json:"issuer"`
+  Subject string `json:"subject"`
+  Serial string `json:"serial"`
+
+  // The TBSCertificate of an X.509 certificate, or an SSH certificate
+  // without its signature.
+  TBS []byte `json:"tbs"`
+
+  // The signature algorithm, e.g. SHA256-RSA or rsa-sha2-256.
+  Algorithm string `json:"algorithm"`
+
+  // The hash function that produced the digest of the TBS, and whether
+  // RSA signatures use PSS. Ed25519 signs the TBS itself, so there is no
+  // digest.
+  Hash string `json:"hash,omitempty"`
+  PSS bool `json:"pss,omitempty"`
+  Digest []byte `json:"digest,omitempty"`
+
+  // The signature as returned by the key: PKCS #1 or PSS for RSA, ASN.1
+  // (r, s) for ECDSA.
+  Signature []byte `json:"signature,omitempty"`
+}
+
+
+// The fields of a TBSCertificate up to the signature algorithm, which is
+// repeated outside of the TBSCertificate.
+type tbsCertificate struct {
+  Version int `asn1:"optional,explicit,default:0,tag:0"`
+  SerialNumber *big.Int
+  SignatureAlgorithm asn1.RawValue
+}
+
+
+type certificate struct {
+  TBSCertificate asn1.RawValue
+  SignatureAlgorithm asn1.RawValue
+  SignatureValue asn1.BitString
+}
+
+
+type ecdsaSignature struct {
+  R, S *big.Int
+}
+
+
+// Return a bundle for the TBSCertificate of a certificate that was signed
+// with a key of the same type as the key of the CA.
+func NewX509Bundle(preview *x509.Certificate) (*Bundle, error) {
+  bundle := &Bundle{
+    Type: TYPE_X509_CERTIFICATE,
+    Issuer: preview.Issuer.String(),
+    Subject: preview.Subject.String(),
+    Serial: fmt.Sprintf("%x", preview.SerialNumber),
+    TBS: preview.RawTBSCertificate,
+    Algorithm: preview.SignatureAlgorithm.String(),
+  }
+  switch preview.SignatureAlgorithm {
+    case x509.SHA256WithRSA, x509.ECDSAWithSHA256:
+      bundle.Hash = "SHA-256"
+    case x509.SHA384WithRSA, x509.ECDSAWithSHA384:
+      bundle.Hash = "SHA-384"
+    case x509.SHA512WithRSA, x509.ECDSAWithSHA512:
+      bundle.Hash = "SHA-512"
+    case x509.SHA256WithRSAPSS:
+      bundle.Hash, bundle.PSS = "SHA-256", true
+    case x509.SHA384WithRSAPSS:
+      bundle.Hash, bundle.PSS = "SHA-384", true
+    case x509.SHA512WithRSAPSS:
+      bundle.Hash, bundle.PSS = "SHA-512", true
+    case x509.PureEd25519:
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported signature algorithm: %s",
+        preview.SignatureAlgorithm))
+  }
+  return bundle, bundle.setDigest()
+}
+
+
+// Return a bundle for an SSH certificate, given the data that its signature
+// covers and the signature algorithm of the CA.
+func NewSecureShellBundle(crt *ssh.Certificate, tbs []byte, algorithm string) (*Bundle, error) {
+  bundle := &Bundle{
+    Type: TYPE_SSH_CERTIFICATE,
+    Issuer: ssh.FingerprintSHA256(crt.SignatureKey),
+    Subject: crt.KeyId,
+    Serial: fmt.Sprintf("%d", crt.Serial),
+    TBS: tbs,
+    Algorithm: algorithm,
+  }
+  switch algorithm {
+    case ssh.SigAlgoRSASHA2256, ssh.KeyAlgoECDSA256:
+      bundle.Hash = "SHA-256"
+    case ssh.KeyAlgoECDSA384:
+      bundle.Hash = "SHA-384"
+    case ssh.SigAlgoRSASHA2512:
+      bundle.Hash = "SHA-512"
+    case ssh.KeyAlgoED25519:
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported signature algorithm: %s", algorithm))
+  }
+  return bundle, bundle.setDigest()
+}
+
+
+func Load(fp string, buf []byte) (*Bundle, error) {
+  var err error
+  if len(buf) == 0 {
+    buf, err = ioutil.ReadFile(fp)
+    if err != nil {
+      return nil, err
+    }
+  }
+  bundle := &Bundle{}
+  if err := json.Unmarshal(buf, bundle); err != nil {
+    return nil, err
+  }
+  return bundle, nil
+}
+
+
+func (self *Bundle) Marshal() []byte {
+  buf, _ := json.MarshalIndent(self, "", "  ")
+  return append(buf, '\n')
+}
+
+
+func (self *Bundle) setDigest() error {
+  if self.Hash == "" {
+    self.Digest = nil
+    return nil
+  }
+  hash, ok := HASHES[self.Hash]
+  if !ok {
+    return errors.New(fmt.Sprintf("Unsupported hash function: %s", self.Hash))
+  }
+  h := hash.New()
+  h.Write(self.TBS)
+  self.Digest = h.Sum(nil)
+  return nil
+}
+
+
+// Return the data to be signed and the options of the signature. The digest
+// must be the hash of the TBS, so that the signer knows what it signs.
+func (self *Bundle) GetSigningInput() ([]byte, crypto.SignerOpts, error) {
+  if self.Hash == "" {
+    return self.TBS, crypto.Hash(0), nil
+  }
+  digest := self.Digest
+  if err := self.setDigest(); err != nil {
+    return nil, nil, err
+  }
+  if string(digest) != string(self.Digest) {
+    return nil, nil, errors.New("The digest does not match the TBS.")
+  }
+  hash := HASHES[self.Hash]
+  if self.PSS {
+    return self.Digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}, nil
+  }
+  return self.Digest, hash, nil
+}
+
+
+// Sign the bundle with signer, which must be able to produce signatures
+// with the algorithm of the bundle.
+func (self *Bundle) Sign(rand io.Reader, signer crypto.Signer) error {
+  if err := self.checkKey(signer.Public()); err != nil {
+    return err
+  }
+  input, opts, err := self.GetSigningInput()
+  if err != nil {
+    return err
+  }
+  self.Signature, err = signer.Sign(rand, input, opts)
+  return err
+}
+
+
+// Return an error if the key can not produce signatures with the algorithm
+// of the bundle.
+func (self *Bundle) checkKey(pub crypto.PublicKey) error {
+  var ok bool
+  switch pub.(type) {
+    case *rsa.PublicKey:
+      switch self.Algorithm {
+        case "SHA256-RSA", "SHA384-RSA", "SHA512-RSA", "SHA256-RSAPSS", "SHA384-RSAPSS",
+          "SHA512-RSAPSS", ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSASHA2512:
+          ok = true
+      }
+    case *ecdsa.PublicKey:
+      switch self.Algorithm {
+        case "ECDSA-SHA256", "ECDSA-SHA384", "ECDSA-SHA512", ssh.KeyAlgoECDSA256,
+          ssh.KeyAlgoECDSA384:
+          ok = true
+      }
+    case ed25519.PublicKey:
+      ok = self.Algorithm == "Ed25519" || self.Algorithm == ssh.KeyAlgoED25519
+  }
+  if !ok {
+    return errors.New(fmt.Sprintf("A %T can not sign with %s.", pub, self.Algorithm))
+  }
+  return nil
+}
+
+
+// Return the DER-encoded X.509 certificate. The signature is not verified.
+func (self *Bundle) GetCertificate() ([]byte, error) {
+  if self.Type != TYPE_X509_CERTIFICATE {
+    return nil, errors.New(fmt.Sprintf("The bundle contains a %s.", self.Type))
+  }
+  tbs := tbsCertificate{}
+  if _, err := asn1.Unmarshal(self.TBS, &tbs); err != nil {
+    return nil, err
+  }
+  return asn1.Marshal(certificate{
+    TBSCertificate: asn1.RawValue{FullBytes: self.TBS},
+    SignatureAlgorithm: tbs.SignatureAlgorithm,
+    SignatureValue: asn1.BitString{Bytes: self.Signature, BitLength: 8 * len(self.Signature)},
+  })
+}
+
+
+// Return the SSH certificate with the signature of the bundle. The
+// signature is not verified.
+func (self *Bundle) GetSecureShellCertificate() (*ssh.Certificate, error) {
+  if self.Type != TYPE_SSH_CERTIFICATE {
+    return nil, errors.New(fmt.Sprintf("The bundle contains a %s.", self.Type))
+  }
+  blob := self.Signature
+  if len(blob) > 0 && (self.Algorithm == ssh.KeyAlgoECDSA256 || self.Algorithm == ssh.KeyAlgoECDSA384) {
+    sig := ecdsaSignature{}
+    if _, err := asn1.Unmarshal(self.Signature, &sig); err != nil {
+      return nil, err
+    }
+    blob = ssh.Marshal(sig)
+  }
+  signature := ssh.Marshal(ssh.Signature{Format: self.Algorithm, Blob: blob})
+  buf := append([]byte{}, self.TBS...)
+  buf = append(buf, ssh.Marshal(struct{ Signature []byte }{signature})...)
+  pub, err := ssh.ParsePublicKey(buf)
+  if err != nil {
+    return nil, err
+  }
+  crt, ok := pub.(*ssh.Certificate)
+  if !ok {
+    return nil, errors.New("The bundle does not contain an SSH certificate.")
+  }
+  return crt, nil
+}
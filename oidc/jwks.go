@@ -0,0 +1,171 @@
+package oidc
+
+import (
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "math/big"
+  "net/http"
+  "strings"
+)
+
+
+type JSONWebKey struct {
+  KeyType string `json:"kty"`
+  KeyID string `json:"kid"`
+  Use string `json:"use"`
+  Algorithm string `json:"alg"`
+  N string `json:"n"`
+  E string `json:"e"`
+  Curve string `json:"crv"`
+  X string `json:"x"`
+  Y string `json:"y"`
+}
+
+
+type JSONWebKeySet struct {
+  Keys []JSONWebKey `json:"keys"`
+}
+
+
+// Load a JSON Web Key Set from a local file or from an http(s) URL.
+func LoadKeySet(location string) (*JSONWebKeySet, error) {
+  var buf []byte
+  var err error
+  if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+    buf, err = fetch(location)
+  } else {
+    buf, err = ioutil.ReadFile(strings.TrimPrefix(location, "file://"))
+  }
+  if err != nil {
+    return nil, err
+  }
+  keys := JSONWebKeySet{}
+  if err := json.Unmarshal(buf, &keys); err != nil {
+    return nil, err
+  }
+  return &keys, nil
+}
+
+
+func fetch(url string) ([]byte, error) {
+  response, err := http.Get(url)
+  if err != nil {
+    return nil, err
+  }
+  defer response.Body.Close()
+  if response.StatusCode != http.StatusOK {
+    return nil, errors.New(fmt.Sprintf("GET %s: %s", url, response.Status))
+  }
+  return ioutil.ReadAll(response.Body)
+}
+
+
+// Return the keys that may have been used to create a signature with the
+// given key identifier and algorithm.
+func (self *JSONWebKeySet) Candidates(kid string, alg string) []JSONWebKey {
+  keys := []JSONWebKey{}
+  for _, k := range self.Keys {
+    if kid != "" && k.KeyID != "" && k.KeyID != kid {
+      continue
+    }
+    if k.Algorithm != "" && k.Algorithm != alg {
+      continue
+    }
+    if k.Use != "" && k.Use != "sig" {
+      continue
+    }
+    keys = append(keys, k)
+  }
+  return keys
+}
+
+
+func (self *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+  switch self.KeyType {
+    case "RSA":
+      n, err := decodeInteger(self.N)
+      if err != nil {
+        return nil, err
+      }
+      e, err := decodeInteger(self.E)
+      if err != nil {
+        return nil, err
+      }
+      if !e.IsInt64() || e.Int64() > 1 << 31 - 1 {
+        return nil, errors.New("Invalid RSA exponent.")
+      }
+      return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+    case "EC":
+      var curve elliptic.Curve
+      switch self.Curve {
+        case "P-256":
+          curve = elliptic.P256()
+        case "P-384":
+          curve = elliptic.P384()
+        case "P-521":
+          curve = elliptic.P521()
+        default:
+          return nil, errors.New(fmt.Sprintf("Unsupported curve: %s", self.Curve))
+      }
+      x, err := decodeInteger(self.X)
+      if err != nil {
+        return nil, err
+      }
+      y, err := decodeInteger(self.Y)
+      if err != nil {
+        return nil, err
+      }
+      if !curve.IsOnCurve(x, y) {
+        return nil, errors.New("Invalid EC public key.")
+      }
+      return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+    case "OKP":
+      if self.Curve != "Ed25519" {
+        return nil, errors.New(fmt.Sprintf("Unsupported curve: %s", self.Curve))
+      }
+      x, err := base64.RawURLEncoding.DecodeString(self.X)
+      if err != nil || len(x) != ed25519.PublicKeySize {
+        return nil, errors.New("Invalid Ed25519 public key.")
+      }
+      return ed25519.PublicKey(x), nil
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported key type: %s", self.KeyType))
+  }
+}
+
+
+// Return the RFC 7638 thumbprint of the key: the base64url-encoded SHA-256
+// hash of its required members.
+func (self *JSONWebKey) Thumbprint() (string, error) {
+  var members string
+  switch self.KeyType {
+    case "RSA":
+      members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, self.E, self.N)
+    case "EC":
+      members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, self.Curve, self.X, self.Y)
+    case "OKP":
+      members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, self.Curve, self.X)
+    default:
+      return "", errors.New(fmt.Sprintf("Unsupported key type: %s", self.KeyType))
+  }
+  h := sha256.Sum256([]byte(members))
+  return base64.RawURLEncoding.EncodeToString(h[:]), nil
+}
+
+
+func decodeInteger(s string) (*big.Int, error) {
+  buf, err := base64.RawURLEncoding.DecodeString(s)
+  if err != nil {
+    return nil, err
+  }
+  return new(big.Int).SetBytes(buf), nil
+}
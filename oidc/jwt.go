@@ -0,0 +1,168 @@
+package oidc
+
+import (
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/rsa"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "math/big"
+  "strings"
+  "time"
+)
+
+
+// Tolerated clock skew when validating the time-based claims.
+var Leeway = 60 * time.Second
+
+
+type Claims map[string]interface{}
+
+
+type header struct {
+  Algorithm string `json:"alg"`
+  KeyID string `json:"kid"`
+}
+
+
+// Verify the signature of a compact-serialized JWT against keys and return
+// its claims. Only the signature is checked; see Verifier for the
+// validation of the claims.
+func ParseSigned(token string, keys *JSONWebKeySet) (Claims, error) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return nil, errors.New("Malformed token.")
+  }
+  h, err := parseHeader(parts[0])
+  if err != nil {
+    return nil, err
+  }
+  signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil {
+    return nil, errors.New("Malformed token signature.")
+  }
+
+  verified := false
+  signed := []byte(parts[0] + "." + parts[1])
+  for _, k := range keys.Candidates(h.KeyID, h.Algorithm) {
+    pub, err := k.PublicKey()
+    if err != nil {
+      continue
+    }
+    if VerifySignature(h.Algorithm, pub, signed, signature) == nil {
+      verified = true
+      break
+    }
+  }
+  if !verified {
+    return nil, errors.New("Invalid token signature.")
+  }
+
+  buf, err := base64.RawURLEncoding.DecodeString(parts[1])
+  if err != nil {
+    return nil, errors.New("Malformed token payload.")
+  }
+  claims := Claims{}
+  if err := json.Unmarshal(buf, &claims); err != nil {
+    return nil, errors.New("Malformed token payload.")
+  }
+  return claims, nil
+}
+
+
+// Verify a JWS signature with the given algorithm, as specified in
+// RFC 7518 and RFC 8037.
+func VerifySignature(alg string, pub crypto.PublicKey, signed []byte, signature []byte) error {
+  var hash crypto.Hash
+  switch alg {
+    case "RS256", "PS256", "ES256":
+      hash = crypto.SHA256
+    case "RS384", "PS384", "ES384":
+      hash = crypto.SHA384
+    case "RS512", "PS512", "ES512":
+      hash = crypto.SHA512
+    case "EdDSA":
+      key, ok := pub.(ed25519.PublicKey)
+      if !ok || !ed25519.Verify(key, signed, signature) {
+        return errors.New("Invalid signature.")
+      }
+      return nil
+    default:
+      return errors.New(fmt.Sprintf("Unsupported algorithm: %s", alg))
+  }
+  h := hash.New()
+  h.Write(signed)
+  digest := h.Sum(nil)
+
+  switch key := pub.(type) {
+    case *rsa.PublicKey:
+      switch alg[0] {
+        case 'R':
+          return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+        case 'P':
+          return rsa.VerifyPSS(key, hash, digest, signature, nil)
+      }
+    case *ecdsa.PublicKey:
+      size := (key.Curve.Params().BitSize + 7) / 8
+      if alg[0] != 'E' || len(signature) != 2 * size {
+        break
+      }
+      r := new(big.Int).SetBytes(signature[:size])
+      s := new(big.Int).SetBytes(signature[size:])
+      if ecdsa.Verify(key, digest, r, s) {
+        return nil
+      }
+  }
+  return errors.New("Invalid signature.")
+}
+
+
+func parseHeader(encoded string) (*header, error) {
+  buf, err := base64.RawURLEncoding.DecodeString(encoded)
+  if err != nil {
+    return nil, errors.New("Malformed token header.")
+  }
+  h := header{}
+  if err := json.Unmarshal(buf, &h); err != nil {
+    return nil, errors.New("Malformed token header.")
+  }
+  return &h, nil
+}
+
+
+func (self Claims) String(name string) string {
+  s, _ := self[name].(string)
+  return s
+}
+
+
+// Return the values of a claim as a list of strings. Scalar values are
+// returned as a list with a single element.
+func (self Claims) Strings(name string) []string {
+  switch v := self[name].(type) {
+    case nil:
+      return []string{}
+    case string:
+      return []string{v}
+    case []interface{}:
+      values := []string{}
+      for _, e := range v {
+        values = append(values, fmt.Sprint(e))
+      }
+      return values
+    default:
+      return []string{fmt.Sprint(v)}
+  }
+}
+
+
+func (self Claims) Time(name string) (time.Time, bool) {
+  v, ok := self[name].(float64)
+  if !ok {
+    return time.Time{}, false
+  }
+  return time.Unix(int64(v), 0), true
+}
@@ -0,0 +1,130 @@
+package oidc
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+
+// The minimum interval between two fetches of the key set, so that tokens
+// with unknown keys do not cause a fetch each.
+const MIN_KEY_SET_REFRESH = 5 * time.Minute
+
+
+// Verifies ID tokens issued by an OpenID Connect provider. If no key set
+// is specified, it is discovered from the configuration of the issuer. The
+// key set is fetched again when a token is signed with a key that it does
+// not contain, e.g. after the provider rotated its keys.
+type Verifier struct {
+  Issuer string
+  Audience string
+  KeySet string
+
+  mu sync.Mutex
+  keys *JSONWebKeySet
+  fetched time.Time
+}
+
+
+type providerConfiguration struct {
+  Issuer string `json:"issuer"`
+  JWKS string `json:"jwks_uri"`
+}
+
+
+func NewVerifier(issuer string, audience string, keySet string) *Verifier {
+  return &Verifier{
+    Issuer: issuer,
+    Audience: audience,
+    KeySet: keySet,
+  }
+}
+
+
+// Return the key set, fetching it if it has no key for the header of a
+// token and was not fetched within MIN_KEY_SET_REFRESH. If the fetch
+// fails, the previous key set is returned.
+func (self *Verifier) getKeySet(h *header) (*JSONWebKeySet, error) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  if self.keys != nil && (len(self.keys.Candidates(h.KeyID, h.Algorithm)) > 0 ||
+    time.Since(self.fetched) < MIN_KEY_SET_REFRESH) {
+    return self.keys, nil
+  }
+  self.fetched = time.Now()
+  keys, err := self.fetchKeySet()
+  if err != nil {
+    if self.keys != nil {
+      return self.keys, nil
+    }
+    return nil, err
+  }
+  self.keys = keys
+  return keys, nil
+}
+
+
+func (self *Verifier) fetchKeySet() (*JSONWebKeySet, error) {
+  location := self.KeySet
+  if location == "" {
+    buf, err := fetch(strings.TrimSuffix(self.Issuer, "/") +
+      "/.well-known/openid-configuration")
+    if err != nil {
+      return nil, err
+    }
+    conf := providerConfiguration{}
+    if err := json.Unmarshal(buf, &conf); err != nil {
+      return nil, err
+    }
+    location = conf.JWKS
+  }
+  return LoadKeySet(location)
+}
+
+
+// Verify the signature of token and validate its issuer, audience and
+// lifetime. The issuer and the audience of the verifier are mandatory, so
+// that tokens issued to other clients, or by other issuers that share the
+// key set, are rejected.
+func (self *Verifier) Verify(token string, now time.Time) (Claims, error) {
+  if self.Issuer == "" || self.Audience == "" {
+    return nil, errors.New("The verifier requires an issuer and an audience.")
+  }
+  h, err := parseHeader(strings.SplitN(token, ".", 2)[0])
+  if err != nil {
+    return nil, err
+  }
+  keys, err := self.getKeySet(h)
+  if err != nil {
+    return nil, err
+  }
+  claims, err := ParseSigned(token, keys)
+  if err != nil {
+    return nil, err
+  }
+  if claims.String("iss") != self.Issuer {
+    return nil, errors.New(fmt.Sprintf("Unexpected issuer: %s", claims.String("iss")))
+  }
+  found := false
+  for _, aud := range claims.Strings("aud") {
+    found = found || aud == self.Audience
+  }
+  if !found {
+    return nil, errors.New("The token was not issued for this audience.")
+  }
+  exp, ok := claims.Time("exp")
+  if !ok {
+    return nil, errors.New("The token does not expire.")
+  }
+  if now.After(exp.Add(Leeway)) {
+    return nil, errors.New("The token has expired.")
+  }
+  if nbf, ok := claims.Time("nbf"); ok && now.Add(Leeway).Before(nbf) {
+    return nil, errors.New("The token is not yet valid.")
+  }
+  return claims, nil
+}
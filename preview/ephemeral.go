@@ -0,0 +1,56 @@
+package preview
+
+import (
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/rand"
+  "crypto/rsa"
+  "errors"
+  "fmt"
+  "sync"
+)
+
+
+var (
+  ephemeralMu sync.Mutex
+  ephemeralKeys = map[string]crypto.Signer{}
+)
+
+
+// Return a local key of the same type as pub. Certificates signed with it
+// have the same structure and signature algorithm as those signed by the
+// key of the CA, which allows them to be inspected before the key of the CA
+// is used. Keys are generated once per type.
+func NewEphemeralSigner(pub crypto.PublicKey) (crypto.Signer, error) {
+  var kind string
+  var generate func() (crypto.Signer, error)
+  switch key := pub.(type) {
+    case *rsa.PublicKey:
+      kind = "rsa"
+      generate = func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }
+    case *ecdsa.PublicKey:
+      kind = "ecdsa-" + key.Curve.Params().Name
+      generate = func() (crypto.Signer, error) { return ecdsa.GenerateKey(key.Curve, rand.Reader) }
+    case ed25519.PublicKey:
+      kind = "ed25519"
+      generate = func() (crypto.Signer, error) {
+        _, priv, err := ed25519.GenerateKey(rand.Reader)
+        return priv, err
+      }
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported key type: %T", pub))
+  }
+
+  ephemeralMu.Lock()
+  defer ephemeralMu.Unlock()
+  if signer, ok := ephemeralKeys[kind]; ok {
+    return signer, nil
+  }
+  signer, err := generate()
+  if err != nil {
+    return nil, err
+  }
+  ephemeralKeys[kind] = signer
+  return signer, nil
+}
@@ -0,0 +1,84 @@
+package preview
+
+import (
+  "encoding/json"
+  "fmt"
+  "io"
+  "strings"
+)
+
+
+// The description of a certificate that is previewed, as lines of text.
+type Description interface {
+  Lines() []string
+}
+
+
+// The description of a certificate that would be issued and, optionally,
+// its differences with a previous certificate.
+type Report struct {
+  Certificate Description `json:"certificate"`
+  Diff []string `json:"diff,omitempty"`
+}
+
+
+// Return a report of crt. If previous is not nil, the report contains the
+// differences with it.
+func NewReport(crt Description, previous Description) *Report {
+  report := &Report{Certificate: crt}
+  if previous != nil {
+    report.Diff = Diff(previous.Lines(), crt.Lines())
+  }
+  return report
+}
+
+
+// Write the report as JSON, or as text in which the differences follow the
+// description under a header that names previousName.
+func (self *Report) Write(w io.Writer, asJSON bool, previousName string) error {
+  if asJSON {
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(self)
+  }
+  if _, err := io.WriteString(w, strings.Join(self.Certificate.Lines(), "\n") + "\n"); err != nil {
+    return err
+  }
+  if self.Diff == nil {
+    return nil
+  }
+  _, err := fmt.Fprintf(w, "\nDifferences with %s:\n", previousName)
+  for _, line := range self.Diff {
+    if err != nil {
+      return err
+    }
+    _, err = io.WriteString(w, line + "\n")
+  }
+  return err
+}
+
+
+// Return the lines of a that are not in b, prefixed with -, followed by
+// the lines of b that are not in a, prefixed with +.
+func Diff(a []string, b []string) []string {
+  inA := map[string]bool{}
+  inB := map[string]bool{}
+  for _, line := range a {
+    inA[line] = true
+  }
+  for _, line := range b {
+    inB[line] = true
+  }
+  diff := []string{}
+  for _, line := range a {
+    if !inB[line] {
+      diff = append(diff, "- " + line)
+    }
+  }
+  for _, line := range b {
+    if !inA[line] {
+      diff = append(diff, "+ " + line)
+    }
+  }
+  return diff
+}
@@ -0,0 +1,99 @@
+package scep
+
+import (
+  "crypto/x509"
+  "encoding/asn1"
+  "errors"
+  "fmt"
+
+  "github.com/cochiseruhulessin/cloud-pki/x509/pkcs7"
+)
+
+
+const (
+  MESSAGE_CERT_REP = "3"
+  MESSAGE_RENEWAL_REQ = "17"
+  MESSAGE_PKCS_REQ = "19"
+  MESSAGE_GET_CERT_INITIAL = "20"
+
+  STATUS_SUCCESS = "0"
+  STATUS_FAILURE = "2"
+
+  FAIL_BAD_ALG = "0"
+  FAIL_BAD_MESSAGE_CHECK = "1"
+  FAIL_BAD_REQUEST = "2"
+  FAIL_BAD_TIME = "3"
+  FAIL_BAD_CERT_ID = "4"
+)
+
+
+var (
+  OID_MESSAGE_TYPE = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+  OID_PKI_STATUS = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+  OID_FAIL_INFO = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+  OID_SENDER_NONCE = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+  OID_RECIPIENT_NONCE = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+  OID_TRANSACTION_ID = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+
+  OID_CHALLENGE_PASSWORD = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+
+type certificationRequestInfo struct {
+  Version int
+  Subject asn1.RawValue
+  PublicKey asn1.RawValue
+  Attributes []pkcs7.Attribute `asn1:"tag:0"`
+}
+
+
+// Parse a pkiMessage and verify its signature. The signer is the
+// requester, usually with a self-signed certificate.
+func parseRequest(der []byte) (*request, error) {
+  sd, err := pkcs7.ParseSignedData(der)
+  if err != nil {
+    return nil, err
+  }
+  signer, err := sd.Verify()
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("Invalid signature: %s", err))
+  }
+  req := &request{
+    signer: signer,
+    digestAlgorithm: sd.DigestAlgorithm,
+    envelope: sd.Content,
+    transactionID: sd.GetAttribute(OID_TRANSACTION_ID),
+  }
+  if req.transactionID == nil {
+    return nil, errors.New("The transactionID attribute is missing.")
+  }
+  if value := sd.GetAttribute(OID_MESSAGE_TYPE); value != nil {
+    req.messageType = string(value.Bytes)
+  }
+  if value := sd.GetAttribute(OID_SENDER_NONCE); value != nil {
+    req.senderNonce = value.Bytes
+  }
+  if req.messageType == "" || req.senderNonce == nil {
+    return nil, errors.New("The messageType and senderNonce attributes are required.")
+  }
+  return req, nil
+}
+
+
+// Return the challenge password from the attributes of the CSR.
+func getChallengePassword(csr *x509.CertificateRequest) (string, error) {
+  info := certificationRequestInfo{}
+  if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &info); err != nil {
+    return "", err
+  }
+  for _, attr := range info.Attributes {
+    if attr.Type.Equal(OID_CHALLENGE_PASSWORD) && len(attr.Values) > 0 {
+      var password string
+      if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &password); err != nil {
+        return "", errors.New("Malformed challenge password.")
+      }
+      return password, nil
+    }
+  }
+  return "", errors.New("The CSR does not contain a challenge password.")
+}
@@ -0,0 +1,52 @@
+package scep
+
+import (
+  "flag"
+  "log"
+  "net/http"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Serve SCEP at /scep and at /cgi-bin/pkiclient.exe, which some clients
+// expect.
+func HandleServe(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var listen string
+  var tlsCert string
+  var tlsKey string
+
+  parser := flag.NewFlagSet("serve", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&listen, "listen", ":8080",
+    "specifies the address to listen on.")
+  parser.StringVar(&tlsCert, "tls-cert", "",
+    "specifies the TLS certificate of the server.")
+  parser.StringVar(&tlsKey, "tls-key", "",
+    "specifies the TLS private key of the server.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  server, err := NewServer(backend, &opts)
+  if err != nil { log.Fatal(err) }
+
+  mux := http.NewServeMux()
+  mux.Handle("/scep", server)
+  mux.Handle("/cgi-bin/pkiclient.exe", server)
+  log.Printf("Listening on %s", listen)
+  if tlsCert != "" {
+    err = http.ListenAndServeTLS(listen, tlsCert, tlsKey, mux)
+  } else {
+    err = http.ListenAndServe(listen, mux)
+  }
+  log.Fatal(err)
+}
@@ -0,0 +1,311 @@
+package scep
+
+import (
+  "crypto"
+  "crypto/rand"
+  "crypto/subtle"
+  "crypto/x509"
+  "encoding/asn1"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "net/http"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  pki "github.com/cochiseruhulessin/cloud-pki/x509"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/pkcs7"
+)
+
+
+const MAX_REQUEST_SIZE = 64 * 1024
+
+
+// The capabilities that are returned by GetCACaps. The weak capabilities
+// are only returned if scep.allow-weak-algorithms is set.
+const (
+  CAPABILITIES = "POSTPKIOperation\nRenewal\nSHA-256\nSHA-512\nAES\nSCEPStandard\n"
+  WEAK_CAPABILITIES = "SHA-1\nDES3\n"
+)
+
+
+// A SCEP (RFC 8894) server that issues certificates with the CA and
+// profile that are specified in its configuration. Requests are decrypted
+// and responses are signed by the registration authority (RA).
+type Server struct {
+  backend backends.Backend
+  opts *dto.X509ConfigurationDTO
+  profile *dto.X509Profile
+  issuer *x509.Certificate
+  raCertificate *x509.Certificate
+  raDecrypter crypto.Decrypter
+  raSigningCertificate *x509.Certificate
+  raSigner crypto.Signer
+}
+
+
+// A PKIOperation request after its signature is verified.
+type request struct {
+  messageType string
+  transactionID *asn1.RawValue
+  senderNonce []byte
+  signer *x509.Certificate
+  digestAlgorithm crypto.Hash
+  envelope []byte
+
+  // The content encryption algorithm of the request, which is also used
+  // for the response.
+  encryption asn1.ObjectIdentifier
+}
+
+
+func NewServer(backend backends.Backend, opts *dto.X509ConfigurationDTO) (*Server, error) {
+  conf := opts.Scep
+  if conf.Challenge == "" {
+    return nil, errors.New("Configure scep.challenge.")
+  }
+  if conf.RA.KeyID == "" || conf.RA.Certificate == "" {
+    return nil, errors.New("Configure scep.ra.keyid and scep.ra.certificate.")
+  }
+  profile, err := opts.GetProfile(conf.Profile)
+  if err != nil {
+    return nil, err
+  }
+  issuer, err := opts.GetSignerCertificate()
+  if err != nil {
+    return nil, err
+  }
+
+  raBackend := backend
+  switch conf.RA.Backend {
+    case "", "kms":
+    case "file":
+      fb := backends.NewFileBackend()
+      raBackend = &fb
+    default:
+      return nil, errors.New(fmt.Sprintf("Unknown backend: %s", conf.RA.Backend))
+  }
+  certificates, err := pki.ReadCertificates(conf.RA.Certificate)
+  if err != nil {
+    return nil, err
+  }
+  server := &Server{
+    backend: backend,
+    opts: opts,
+    profile: profile,
+    issuer: issuer,
+    raCertificate: certificates[0],
+    raDecrypter: raBackend.GetDecrypter(conf.RA.KeyID),
+  }
+  if conf.RA.SigningKeyID == "" {
+    signer, ok := server.raDecrypter.(crypto.Signer)
+    if !ok {
+      return nil, errors.New("The RA key can not sign; configure scep.ra.signing-keyid.")
+    }
+    server.raSigner = signer
+    server.raSigningCertificate = server.raCertificate
+  } else {
+    if conf.RA.SigningCertificate == "" {
+      return nil, errors.New("Configure scep.ra.signing-certificate.")
+    }
+    certificates, err := pki.ReadCertificates(conf.RA.SigningCertificate)
+    if err != nil {
+      return nil, err
+    }
+    server.raSigner = raBackend.GetSigner(conf.RA.SigningKeyID)
+    server.raSigningCertificate = certificates[0]
+  }
+  return server, nil
+}
+
+
+func (self *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  switch op := r.URL.Query().Get("operation"); op {
+    case "GetCACaps":
+      w.Header().Set("Content-Type", "text/plain")
+      io.WriteString(w, CAPABILITIES)
+      if self.opts.Scep.AllowWeakAlgorithms {
+        io.WriteString(w, WEAK_CAPABILITIES)
+      }
+    case "GetCACert":
+      self.getCACertificates(w)
+    case "PKIOperation":
+      self.handlePKIOperation(w, r)
+    default:
+      http.Error(w, fmt.Sprintf("Unknown operation: %s", op), http.StatusBadRequest)
+  }
+}
+
+
+func (self *Server) getCACertificates(w http.ResponseWriter) {
+  certificates := [][]byte{self.raCertificate.Raw}
+  if self.raSigningCertificate != self.raCertificate {
+    certificates = append(certificates, self.raSigningCertificate.Raw)
+  }
+  buf, err := pkcs7.CertificatesOnly(append(certificates, self.issuer.Raw)...)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+  w.Write(buf)
+}
+
+
+func (self *Server) handlePKIOperation(w http.ResponseWriter, r *http.Request) {
+  var der []byte
+  var err error
+  switch r.Method {
+    case http.MethodGet:
+      der, err = base64.StdEncoding.DecodeString(r.URL.Query().Get("message"))
+    case http.MethodPost:
+      der, err = ioutil.ReadAll(io.LimitReader(r.Body, MAX_REQUEST_SIZE + 1))
+      if err == nil && len(der) > MAX_REQUEST_SIZE {
+        err = errors.New("The request is too large.")
+      }
+    default:
+      http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
+      return
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  req, err := parseRequest(der)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  var response []byte
+  switch req.messageType {
+    case MESSAGE_PKCS_REQ, MESSAGE_RENEWAL_REQ:
+      crt, failInfo, err := self.enroll(req)
+      if err != nil {
+        log.Printf("Transaction %s failed: %s", string(req.transactionID.Bytes), err)
+        response, err = self.respond(req, STATUS_FAILURE, failInfo, nil)
+      } else {
+        response, err = self.respond(req, STATUS_SUCCESS, "", crt)
+      }
+    case MESSAGE_GET_CERT_INITIAL:
+      // Requests are never pending, so there is nothing to poll for.
+      response, err = self.respond(req, STATUS_FAILURE, FAIL_BAD_CERT_ID, nil)
+    default:
+      response, err = self.respond(req, STATUS_FAILURE, FAIL_BAD_REQUEST, nil)
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "application/x-pki-message")
+  w.Write(response)
+}
+
+
+// Decrypt and check the CSR in the request and issue a certificate. New
+// enrollments must include the challenge password; renewals must be signed
+// with a valid certificate issued by the CA with the same subject and
+// Subject Alternative Names.
+func (self *Server) enroll(req *request) ([]byte, string, error) {
+  weak := self.opts.Scep.AllowWeakAlgorithms
+  if req.digestAlgorithm == crypto.SHA1 && !weak {
+    return nil, FAIL_BAD_ALG, errors.New("The request is signed with SHA-1.")
+  }
+  der, encryption, err := pkcs7.Decrypt(req.envelope, self.raCertificate, self.raDecrypter)
+  if err != nil {
+    return nil, FAIL_BAD_MESSAGE_CHECK, err
+  }
+  if (encryption.Equal(pkcs7.OID_DES_CBC) || encryption.Equal(pkcs7.OID_DES_EDE3_CBC)) && !weak {
+    return nil, FAIL_BAD_ALG, errors.New("The request is encrypted with DES.")
+  }
+  req.encryption = encryption
+  csr, err := x509.ParseCertificateRequest(der)
+  if err == nil {
+    err = csr.CheckSignature()
+  }
+  if err != nil {
+    return nil, FAIL_BAD_REQUEST, err
+  }
+
+  if req.messageType == MESSAGE_RENEWAL_REQ {
+    now := time.Now()
+    switch {
+      case req.signer.CheckSignatureFrom(self.issuer) != nil:
+        return nil, FAIL_BAD_REQUEST, errors.New("The renewal is not signed with a certificate issued by the CA.")
+      case now.Before(req.signer.NotBefore) || now.After(req.signer.NotAfter):
+        return nil, FAIL_BAD_TIME, errors.New("The certificate of the renewal has expired.")
+    }
+    if err := pki.CheckReenrollment(req.signer, csr); err != nil {
+      return nil, FAIL_BAD_REQUEST, err
+    }
+  } else {
+    password, err := getChallengePassword(csr)
+    if err != nil {
+      return nil, FAIL_BAD_REQUEST, err
+    }
+    if subtle.ConstantTimeCompare([]byte(password), []byte(self.opts.Scep.Challenge)) != 1 {
+      return nil, FAIL_BAD_REQUEST, errors.New("Invalid challenge password.")
+    }
+  }
+
+  crt, err := pki.IssueCertificate(self.backend, self.opts, csr, self.profile, false, self.opts)
+  if err != nil {
+    return nil, FAIL_BAD_REQUEST, err
+  }
+  log.Printf("Issued a certificate for %s (transaction %s)", csr.Subject, string(req.transactionID.Bytes))
+  return crt, "", nil
+}
+
+
+// Return a CertRep message. On success, the certificate is encrypted for
+// the signer of the request.
+func (self *Server) respond(req *request, status string, failInfo string, crt []byte) ([]byte, error) {
+  var content []byte
+  if crt != nil {
+    degenerate, err := pkcs7.CertificatesOnly(crt)
+    if err != nil {
+      return nil, err
+    }
+    content, err = pkcs7.Encrypt(degenerate, req.signer, req.encryption)
+    if err != nil {
+      return nil, err
+    }
+  }
+  nonce := make([]byte, 16)
+  if _, err := rand.Read(nonce); err != nil {
+    return nil, err
+  }
+  // The values are known to be encodable.
+  messageType, _ := pkcs7.NewAttribute(OID_MESSAGE_TYPE, printable(MESSAGE_CERT_REP))
+  pkiStatus, _ := pkcs7.NewAttribute(OID_PKI_STATUS, printable(status))
+  senderNonce, _ := pkcs7.NewAttribute(OID_SENDER_NONCE, nonce)
+  recipientNonce, _ := pkcs7.NewAttribute(OID_RECIPIENT_NONCE, req.senderNonce)
+  attrs := []pkcs7.Attribute{
+    {Type: OID_TRANSACTION_ID, Values: []asn1.RawValue{*req.transactionID}},
+    messageType,
+    pkiStatus,
+    senderNonce,
+    recipientNonce,
+  }
+  if failInfo != "" {
+    attr, _ := pkcs7.NewAttribute(OID_FAIL_INFO, printable(failInfo))
+    attrs = append(attrs, attr)
+  }
+  hash := req.digestAlgorithm
+  if hash == crypto.SHA1 && !self.opts.Scep.AllowWeakAlgorithms {
+    hash = crypto.SHA256
+  }
+  if hash != crypto.SHA1 && hash != crypto.SHA256 && hash != crypto.SHA512 {
+    hash = crypto.SHA256
+  }
+  return pkcs7.Sign(content, self.raSigningCertificate, self.raSigner, hash, attrs)
+}
+
+
+func printable(s string) asn1.RawValue {
+  return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagPrintableString, Bytes: []byte(s)}
+}
@@ -1 +1,356 @@
 package ssh
+
+import (
+  "bytes"
+  "crypto/rand"
+  "crypto/rsa"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "strconv"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/audit"
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+const MIN_RSA_BITS = 2048
+
+
+// The parameters requested by the subject of a certificate. Empty fields
+// are taken from the profile.
+type CertificateRequest struct {
+  KeyID string
+  Principals []string
+  Validity time.Duration
+}
+
+
+type CertificateBuilder struct {
+  backend backends.Backend
+  opts *dto.X509ConfigurationDTO
+  profile *dto.SecureShellProfile
+  signer ssh.Signer
+
+  // Do not consume a sequential serial, because the certificate will
+  // not be issued.
+  dryRun bool
+}
+
+
+// Return a builder that signs with the key of the CA. If backend is nil,
+// certificates can only be prepared, to be signed on another host.
+func NewCertificateBuilder(backend backends.Backend, opts *dto.X509ConfigurationDTO, profile string) (*CertificateBuilder, error) {
+  p, err := opts.SecureShell.GetProfile(profile)
+  if err != nil {
+    return nil, err
+  }
+  switch p.Type {
+    case "", "user", "host":
+    default:
+      return nil, errors.New(fmt.Sprintf("Invalid certificate type: %s", p.Type))
+  }
+  if _, _, _, err = p.GetValidity(); err != nil {
+    return nil, err
+  }
+  var signer ssh.Signer
+  if backend != nil {
+    signer, err = NewSecureShellSigner(backend, opts)
+  } else {
+    signer, err = newOfflineSigner(opts)
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &CertificateBuilder{
+    backend: backend,
+    opts: opts,
+    profile: p,
+    signer: signer,
+  }, nil
+}
+
+
+// Assemble an unsigned certificate for key.
+func (self *CertificateBuilder) Build(key ssh.PublicKey, req *CertificateRequest) (*ssh.Certificate, error) {
+  if err := self.checkSubjectKey(key); err != nil {
+    return nil, err
+  }
+
+  principals := req.Principals
+  if len(principals) == 0 {
+    principals = self.profile.Principals
+  }
+  if len(principals) == 0 {
+    return nil, errors.New("Specify at least one principal.")
+  }
+  if err := self.checkPrincipals(principals); err != nil {
+    return nil, err
+  }
+
+  validity, max, backdate, err := self.profile.GetValidity()
+  if err != nil {
+    return nil, err
+  }
+  if req.Validity > 0 {
+    validity = req.Validity
+  }
+  if validity > max {
+    return nil, errors.New(fmt.Sprintf(
+      "The requested validity %s exceeds the maximum of %s.", validity, max))
+  }
+  now := time.Now()
+
+  serial, err := self.allocateSerial()
+  if err != nil {
+    return nil, err
+  }
+
+  keyId := req.KeyID
+  if keyId == "" {
+    keyId = principals[0]
+  }
+
+  crt := ssh.Certificate{
+    Key: key,
+    Serial: serial,
+    CertType: ssh.UserCert,
+    KeyId: keyId,
+    ValidPrincipals: principals,
+    ValidAfter: uint64(now.Add(-backdate).Unix()),
+    ValidBefore: uint64(now.Add(validity).Unix()),
+    Permissions: ssh.Permissions{
+      CriticalOptions: copyOptions(self.profile.CriticalOptions),
+      Extensions: copyOptions(self.profile.Extensions),
+    },
+    Reserved: []byte{},
+  }
+  if self.profile.Type == "host" {
+    crt.CertType = ssh.HostCert
+    crt.Permissions.Extensions = map[string]string{}
+  }
+  return &crt, nil
+}
+
+
+// Check that crt is a certificate that Build could have returned with the
+// profile: its key, principals, type, validity, critical options and
+// extensions. Certificates that were prepared on another host are checked
+// before they are signed.
+func (self *CertificateBuilder) Check(crt *ssh.Certificate) error {
+  if err := self.checkSubjectKey(crt.Key); err != nil {
+    return err
+  }
+  if len(crt.ValidPrincipals) == 0 {
+    return errors.New("The certificate has no principals.")
+  }
+  if err := self.checkPrincipals(crt.ValidPrincipals); err != nil {
+    return err
+  }
+
+  certType := uint32(ssh.UserCert)
+  extensions := self.profile.Extensions
+  if self.profile.Type == "host" {
+    certType = ssh.HostCert
+    extensions = nil
+  }
+  if crt.CertType != certType {
+    return errors.New(fmt.Sprintf("The certificate type %d does not match the profile.", crt.CertType))
+  }
+  if !sameOptions(crt.CriticalOptions, self.profile.CriticalOptions) {
+    return errors.New("The critical options of the certificate differ from the profile.")
+  }
+  if !sameOptions(crt.Extensions, extensions) {
+    return errors.New("The extensions of the certificate differ from the profile.")
+  }
+
+  _, max, backdate, err := self.profile.GetValidity()
+  if err != nil {
+    return err
+  }
+  if crt.ValidAfter == 0 || crt.ValidBefore == ssh.CertTimeInfinity || crt.ValidBefore <= crt.ValidAfter {
+    return errors.New("The certificate must have a bounded validity period.")
+  }
+  validAfter := time.Unix(int64(crt.ValidAfter), 0)
+  validBefore := time.Unix(int64(crt.ValidBefore), 0)
+  if validBefore.Sub(validAfter) > max + backdate || validBefore.After(time.Now().Add(max)) {
+    return errors.New(fmt.Sprintf("The validity of the certificate exceeds the maximum of %s.", max))
+  }
+  return nil
+}
+
+
+func (self *CertificateBuilder) Sign(crt *ssh.Certificate) error {
+  if err := crt.SignCert(rand.Reader, self.signer); err != nil {
+    return err
+  }
+  if self.opts.Audit == "" {
+    return nil
+  }
+  record := audit.NewRecord(audit.OPERATION_SSH_CERTIFICATE, self.opts.Signer.KeyID,
+    bytesForSigning(crt))
+  record.Issuer = ssh.FingerprintSHA256(crt.SignatureKey)
+  record.Subject = crt.KeyId
+  record.Serial = strconv.FormatUint(crt.Serial, 10)
+  return audit.Open(self.opts.Audit).Append(record)
+}
+
+
+// Return the data that the signature of crt covers: the certificate
+// without its signature.
+func bytesForSigning(crt *ssh.Certificate) []byte {
+  unsigned := *crt
+  unsigned.Signature = nil
+  buf := unsigned.Marshal()
+  // Drop the length of the empty signature.
+  return buf[:len(buf) - 4]
+}
+
+
+// Reject keys that are too weak to be certified, certificates, and the
+// key of the CA itself.
+func (self *CertificateBuilder) checkSubjectKey(key ssh.PublicKey) error {
+  switch key.Type() {
+    case ssh.KeyAlgoDSA:
+      return errors.New("DSA keys are not accepted.")
+    case ssh.KeyAlgoRSA:
+      pub, ok := key.(ssh.CryptoPublicKey)
+      if !ok {
+        return errors.New("Unable to inspect the RSA public key.")
+      }
+      rsaKey, ok := pub.CryptoPublicKey().(*rsa.PublicKey)
+      if !ok || rsaKey.N.BitLen() < MIN_RSA_BITS {
+        return errors.New(fmt.Sprintf(
+          "RSA keys must be at least %d bits.", MIN_RSA_BITS))
+      }
+    case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+      ssh.KeyAlgoED25519, ssh.KeyAlgoSKECDSA256, ssh.KeyAlgoSKED25519:
+    default:
+      return errors.New(fmt.Sprintf("Unsupported key type: %s", key.Type()))
+  }
+  if bytes.Equal(key.Marshal(), self.signer.PublicKey().Marshal()) {
+    return errors.New("Refusing to certify the key of the CA.")
+  }
+  return nil
+}
+
+
+func (self *CertificateBuilder) checkPrincipals(principals []string) error {
+  if len(self.profile.AllowedPrincipals) == 0 {
+    return nil
+  }
+  patterns := strings.Join(self.profile.AllowedPrincipals, ",")
+  for _, principal := range principals {
+    if !matchPatternList(principal, patterns) {
+      return errors.New(fmt.Sprintf("Principal %s is not allowed.", principal))
+    }
+  }
+  return nil
+}
+
+
+func (self *CertificateBuilder) allocateSerial() (uint64, error) {
+  switch self.opts.SecureShell.Serial {
+    case "", "random":
+      return randomSerial()
+    case "sequential":
+      if self.opts.SecureShell.SerialFile == "" {
+        return 0, errors.New("Sequential serials require ssh.serial-file.")
+      }
+      if self.dryRun {
+        return peekSerial(self.opts.SecureShell.SerialFile)
+      }
+      return nextSerial(self.opts.SecureShell.SerialFile)
+    default:
+      return 0, errors.New(fmt.Sprintf("Invalid serial allocation: %s",
+        self.opts.SecureShell.Serial))
+  }
+}
+
+
+func randomSerial() (uint64, error) {
+  buf := make([]byte, 8)
+  for {
+    if _, err := rand.Read(buf); err != nil {
+      return 0, err
+    }
+    if serial := binary.BigEndian.Uint64(buf); serial != 0 {
+      return serial, nil
+    }
+  }
+}
+
+
+// Increment the counter in fp and return the new value. A lock on the
+// file fp.lock prevents concurrent invocations from allocating the same
+// serial; it is kept, because fp itself is replaced. The kernel releases
+// the lock when the process exits.
+func nextSerial(fp string) (uint64, error) {
+  lock, err := os.OpenFile(fp + ".lock", os.O_CREATE|os.O_WRONLY, 0600)
+  if err != nil {
+    return 0, err
+  }
+  defer lock.Close()
+  if err := audit.Lock(lock); err != nil {
+    return 0, err
+  }
+
+  serial, err := peekSerial(fp)
+  if err != nil {
+    return 0, err
+  }
+
+  tmp := fp + ".tmp"
+  err = ioutil.WriteFile(tmp, []byte(strconv.FormatUint(serial, 10) + "\n"), 0600)
+  if err != nil {
+    return 0, err
+  }
+  return serial, os.Rename(tmp, fp)
+}
+
+
+// Return the serial that nextSerial would allocate, without incrementing
+// the counter in fp.
+func peekSerial(fp string) (uint64, error) {
+  var serial uint64
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil && !os.IsNotExist(err) {
+    return 0, err
+  }
+  if len(bytes.TrimSpace(buf)) > 0 {
+    serial, err = strconv.ParseUint(string(bytes.TrimSpace(buf)), 10, 64)
+    if err != nil {
+      return 0, errors.New(fmt.Sprintf("Invalid serial in %s.", fp))
+    }
+  }
+  return serial + 1, nil
+}
+
+
+func sameOptions(a map[string]string, b map[string]string) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for k, v := range a {
+    if w, ok := b[k]; !ok || v != w {
+      return false
+    }
+  }
+  return true
+}
+
+
+func copyOptions(options map[string]string) map[string]string {
+  result := map[string]string{}
+  for k, v := range options {
+    result[k] = v
+  }
+  return result
+}
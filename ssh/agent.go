@@ -0,0 +1,114 @@
+package ssh
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "net"
+  "os"
+  "os/signal"
+  "syscall"
+
+  "golang.org/x/crypto/ssh"
+  "golang.org/x/crypto/ssh/agent"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Serve the ssh-agent protocol on a UNIX socket, exposing the keys listed
+// in the agent configuration file.
+func HandleAgent(buf []byte, args []string, backend backends.Backend) {
+  var agentConf string
+  var socket string
+  var confirm bool
+
+  parser := flag.NewFlagSet("agent", flag.ExitOnError)
+  parser.StringVar(&agentConf, "config", "",
+    "specifies the agent configuration file.")
+  parser.StringVar(&socket, "a", "",
+    "bind the agent to the UNIX-domain socket at this path.")
+  parser.BoolVar(&confirm, "c", false,
+    "require confirmation before each signing operation.")
+  parser.Parse(args)
+
+  if agentConf == "" {
+    log.Fatal("The -config parameter is mandatory.")
+  }
+  opts := dto.SecureShellAgentConfiguration{}
+  err := opts.Load(agentConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  if socket == "" {
+    socket = opts.Socket
+  }
+  if socket == "" {
+    log.Fatal("Specify the socket path with -a or in the configuration file.")
+  }
+  if len(opts.Keys) == 0 {
+    log.Fatal("No keys are configured.")
+  }
+
+  keyring := NewKeyringAgent(confirm || opts.Confirm)
+  for _, k := range opts.Keys {
+    var crt *ssh.Certificate
+    if k.Certificate != "" {
+      crt, err = readCertificate(k.Certificate)
+      if err != nil { log.Fatal(err) }
+    }
+    comment := k.Comment
+    if comment == "" {
+      comment = k.KeyID
+    }
+    err = keyring.AddSigner(backend.GetSecureShellSigner(k.KeyID), crt,
+      comment, k.Algorithm, k.Confirm)
+    if err != nil { log.Fatal(err) }
+  }
+
+  listener, err := net.Listen("unix", socket)
+  if err != nil { log.Fatal(err) }
+  if err = os.Chmod(socket, 0600); err != nil {
+    listener.Close()
+    log.Fatal(err)
+  }
+
+  // Remove the socket when the agent is terminated.
+  signals := make(chan os.Signal, 1)
+  signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+  go func() {
+    <-signals
+    listener.Close()
+    os.Exit(0)
+  }()
+
+  fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+  for {
+    conn, err := listener.Accept()
+    if err != nil {
+      log.Fatal(err)
+    }
+    go func(conn net.Conn) {
+      defer conn.Close()
+      agent.ServeAgent(keyring, conn)
+    }(conn)
+  }
+}
+
+
+func readCertificate(fp string) (*ssh.Certificate, error) {
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil {
+    return nil, err
+  }
+  key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+  if err != nil {
+    return nil, err
+  }
+  crt, ok := key.(*ssh.Certificate)
+  if !ok {
+    return nil, fmt.Errorf("%s does not contain an OpenSSH certificate", fp)
+  }
+  return crt, nil
+}
@@ -0,0 +1,213 @@
+package ssh
+
+import (
+  "bufio"
+  "bytes"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+)
+
+
+// An entry in an allowed_signers file, as described in the ALLOWED SIGNERS
+// section of ssh-keygen(1).
+type AllowedSigner struct {
+  Principals string
+  CertificateAuthority bool
+  Namespaces string
+  ValidAfter time.Time
+  ValidBefore time.Time
+  PublicKey ssh.PublicKey
+}
+
+
+type AllowedSigners []AllowedSigner
+
+
+func LoadAllowedSigners(fp string) (AllowedSigners, error) {
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil {
+    return nil, err
+  }
+  return ParseAllowedSigners(buf)
+}
+
+
+func ParseAllowedSigners(buf []byte) (AllowedSigners, error) {
+  signers := AllowedSigners{}
+  scanner := bufio.NewScanner(bytes.NewReader(buf))
+  lineno := 0
+  for scanner.Scan() {
+    lineno++
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") {
+      continue
+    }
+    signer, err := parseAllowedSigner(line)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("line %d: %s", lineno, err))
+    }
+    signers = append(signers, *signer)
+  }
+  return signers, scanner.Err()
+}
+
+
+func parseAllowedSigner(line string) (*AllowedSigner, error) {
+  i := strings.IndexAny(line, " \t")
+  if i < 0 {
+    return nil, errors.New("missing public key")
+  }
+  signer := AllowedSigner{Principals: line[:i]}
+
+  // The remainder has the same format as an authorized_keys entry.
+  pub, _, options, _, err := ssh.ParseAuthorizedKey([]byte(line[i+1:]))
+  if err != nil {
+    return nil, err
+  }
+  signer.PublicKey = pub
+  for _, option := range options {
+    name := option
+    value := ""
+    if i := strings.Index(option, "="); i > -1 {
+      name = option[:i]
+      value = strings.Trim(option[i+1:], "\"")
+    }
+    switch strings.ToLower(name) {
+      case "cert-authority":
+        signer.CertificateAuthority = true
+      case "namespaces":
+        signer.Namespaces = value
+      case "valid-after":
+        signer.ValidAfter, err = parseAllowedSignerTime(value)
+      case "valid-before":
+        signer.ValidBefore, err = parseAllowedSignerTime(value)
+      default:
+        err = errors.New(fmt.Sprintf("unknown option %s", name))
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+  return &signer, nil
+}
+
+
+// Times are specified as YYYYMMDD[HHMM[SS]] in the local time zone, or in
+// UTC if suffixed with a Z.
+func parseAllowedSignerTime(value string) (time.Time, error) {
+  loc := time.Local
+  if strings.HasSuffix(value, "Z") {
+    loc = time.UTC
+    value = strings.TrimSuffix(value, "Z")
+  }
+  switch len(value) {
+    case 8:
+      return time.ParseInLocation("20060102", value, loc)
+    case 12:
+      return time.ParseInLocation("200601021504", value, loc)
+    case 14:
+      return time.ParseInLocation("20060102150405", value, loc)
+    default:
+      return time.Time{}, errors.New(fmt.Sprintf("invalid time %s", value))
+  }
+}
+
+
+// Find the entry that authorizes the public key embedded in sig to sign
+// on behalf of principal in namespace.
+func (self AllowedSigners) Authorize(sig *FileSignature, principal string, now time.Time) (*AllowedSigner, error) {
+  for i := range self {
+    signer := &self[i]
+    if !matchPatternList(principal, signer.Principals) {
+      continue
+    }
+    if signer.Namespaces != "" && !matchPatternList(sig.Namespace, signer.Namespaces) {
+      continue
+    }
+    if !signer.ValidAfter.IsZero() && now.Before(signer.ValidAfter) {
+      continue
+    }
+    if !signer.ValidBefore.IsZero() && now.After(signer.ValidBefore) {
+      continue
+    }
+
+    crt, isCertificate := sig.PublicKey.(*ssh.Certificate)
+    if signer.CertificateAuthority != isCertificate {
+      continue
+    }
+    if !isCertificate {
+      if bytes.Equal(signer.PublicKey.Marshal(), sig.PublicKey.Marshal()) {
+        return signer, nil
+      }
+      continue
+    }
+
+    checker := ssh.CertChecker{
+      Clock: func() time.Time { return now },
+      IsUserAuthority: func(auth ssh.PublicKey) bool {
+        return bytes.Equal(auth.Marshal(), signer.PublicKey.Marshal())
+      },
+    }
+    if crt.CertType != ssh.UserCert || !checker.IsUserAuthority(crt.SignatureKey) {
+      continue
+    }
+    if err := checker.CheckCert(principal, crt); err != nil {
+      return nil, err
+    }
+    return signer, nil
+  }
+  return nil, errors.New(fmt.Sprintf("No principal matched %s.", principal))
+}
+
+
+// Match s against a comma-separated list of patterns, where patterns that
+// are prefixed with an exclamation mark negate the match.
+func matchPatternList(s string, patterns string) bool {
+  matched := false
+  for _, pattern := range strings.Split(patterns, ",") {
+    negated := strings.HasPrefix(pattern, "!")
+    if negated {
+      pattern = pattern[1:]
+    }
+    if matchPattern(s, pattern) {
+      if negated {
+        return false
+      }
+      matched = true
+    }
+  }
+  return matched
+}
+
+
+// Match s against a pattern where * matches any sequence of characters and
+// ? matches exactly one character.
+func matchPattern(s string, pattern string) bool {
+  for len(pattern) > 0 {
+    switch pattern[0] {
+      case '*':
+        for i := len(s); i >= 0; i-- {
+          if matchPattern(s[i:], pattern[1:]) {
+            return true
+          }
+        }
+        return false
+      case '?':
+        if len(s) == 0 {
+          return false
+        }
+      default:
+        if len(s) == 0 || s[0] != pattern[0] {
+          return false
+        }
+    }
+    s = s[1:]
+    pattern = pattern[1:]
+  }
+  return len(s) == 0
+}
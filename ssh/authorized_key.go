@@ -33,7 +33,7 @@ func HandleAuthorizedKey(buf []byte, args []string, backend backends.Backend) {
     log.Fatal(err)
   }
 
-  signer :=  backend.GetSecureShellSigner(opts.Signer.KeyID)
+  signer, err := NewSecureShellSigner(backend, &opts)
   if err != nil {
     log.Fatal(err)
   }
@@ -0,0 +1,212 @@
+package ssh
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "os"
+  "sort"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+type CertificateDescription struct {
+  Type string `json:"type"`
+  Algorithm string `json:"algorithm"`
+  PublicKey string `json:"public_key"`
+  KeyID string `json:"key_id"`
+  Serial uint64 `json:"serial"`
+  ValidAfter *time.Time `json:"valid_after"`
+  ValidBefore *time.Time `json:"valid_before"`
+  Principals []string `json:"principals"`
+  CriticalOptions map[string]string `json:"critical_options"`
+  Extensions map[string]string `json:"extensions"`
+  SigningCA string `json:"signing_ca"`
+  SignatureAlgorithm string `json:"signature_algorithm"`
+  SignatureValid *bool `json:"signature_valid,omitempty"`
+}
+
+
+// Print the contents of an OpenSSH certificate, read from stdin or from the
+// file given as the first positional argument.
+func HandleInspect(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var asJSON bool
+  var err error
+
+  parser := flag.NewFlagSet("inspect", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "verify that the certificate was signed by this CA.")
+  parser.BoolVar(&asJSON, "json", false,
+    "print the certificate as JSON.")
+  parser.Parse(args)
+
+  buf := stdin
+  if parser.NArg() > 0 {
+    buf, err = ioutil.ReadFile(parser.Arg(0))
+    if err != nil { log.Fatal(err) }
+  }
+  if len(buf) == 0 {
+    log.Fatal("Provide the certificate through stdin or as an argument.")
+  }
+  key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+  if err != nil { log.Fatal(err) }
+  crt, ok := key.(*ssh.Certificate)
+  if !ok {
+    log.Fatal("Not an OpenSSH certificate.")
+  }
+
+  desc := DescribeCertificate(crt)
+  if caConf != "" {
+    opts := dto.X509ConfigurationDTO{}
+    err = opts.Load(caConf, nil)
+    if err != nil { log.Fatal(err) }
+    valid := VerifyCertificateSignature(crt,
+      backend.GetSecureShellSigner(opts.Signer.KeyID).PublicKey()) == nil
+    desc.SignatureValid = &valid
+  }
+
+  if asJSON {
+    enc := json.NewEncoder(os.Stdout)
+    enc.SetIndent("", "  ")
+    err = enc.Encode(desc)
+  } else {
+    err = desc.Write(os.Stdout)
+  }
+  if err != nil { log.Fatal(err) }
+  if desc.SignatureValid != nil && !*desc.SignatureValid {
+    os.Exit(1)
+  }
+}
+
+
+func DescribeCertificate(crt *ssh.Certificate) *CertificateDescription {
+  desc := CertificateDescription{
+    Type: "user",
+    Algorithm: crt.Type(),
+    PublicKey: ssh.FingerprintSHA256(crt.Key),
+    KeyID: crt.KeyId,
+    Serial: crt.Serial,
+    Principals: crt.ValidPrincipals,
+    CriticalOptions: crt.CriticalOptions,
+    Extensions: crt.Extensions,
+    SigningCA: ssh.FingerprintSHA256(crt.SignatureKey),
+  }
+  if crt.CertType == ssh.HostCert {
+    desc.Type = "host"
+  }
+  if crt.Signature != nil {
+    desc.SignatureAlgorithm = crt.Signature.Format
+  }
+  if crt.ValidAfter != 0 {
+    t := time.Unix(int64(crt.ValidAfter), 0).UTC()
+    desc.ValidAfter = &t
+  }
+  if crt.ValidBefore != ssh.CertTimeInfinity {
+    t := time.Unix(int64(crt.ValidBefore), 0).UTC()
+    desc.ValidBefore = &t
+  }
+  if desc.Principals == nil {
+    desc.Principals = []string{}
+  }
+  return &desc
+}
+
+
+func (self *CertificateDescription) Write(w io.Writer) error {
+  validity := "forever"
+  switch {
+    case self.ValidAfter != nil && self.ValidBefore != nil:
+      validity = fmt.Sprintf("from %s to %s",
+        self.ValidAfter.Format(time.RFC3339), self.ValidBefore.Format(time.RFC3339))
+    case self.ValidAfter != nil:
+      validity = fmt.Sprintf("after %s", self.ValidAfter.Format(time.RFC3339))
+    case self.ValidBefore != nil:
+      validity = fmt.Sprintf("before %s", self.ValidBefore.Format(time.RFC3339))
+  }
+
+  b := &bytes.Buffer{}
+  fmt.Fprintf(b, "Type: %s %s certificate\n", self.Algorithm, self.Type)
+  fmt.Fprintf(b, "Public key: %s\n", self.PublicKey)
+  fmt.Fprintf(b, "Signing CA: %s (using %s)\n", self.SigningCA, self.SignatureAlgorithm)
+  fmt.Fprintf(b, "Key ID: %q\n", self.KeyID)
+  fmt.Fprintf(b, "Serial: %d\n", self.Serial)
+  fmt.Fprintf(b, "Valid: %s\n", validity)
+  fmt.Fprintf(b, "Principals:")
+  writeList(b, self.Principals)
+  fmt.Fprintf(b, "Critical Options:")
+  writeOptions(b, self.CriticalOptions)
+  fmt.Fprintf(b, "Extensions:")
+  writeOptions(b, self.Extensions)
+  if self.SignatureValid != nil {
+    status := "valid"
+    if !*self.SignatureValid {
+      status = "INVALID"
+    }
+    fmt.Fprintf(b, "Signature: %s\n", status)
+  }
+  _, err := w.Write(b.Bytes())
+  return err
+}
+
+
+// Return the lines that Write writes.
+func (self *CertificateDescription) Lines() []string {
+  b := &bytes.Buffer{}
+  self.Write(b)
+  return strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+}
+
+
+func writeList(b *bytes.Buffer, values []string) {
+  if len(values) == 0 {
+    b.WriteString(" (none)\n")
+    return
+  }
+  b.WriteString("\n")
+  for _, v := range values {
+    fmt.Fprintf(b, "        %s\n", v)
+  }
+}
+
+
+func writeOptions(b *bytes.Buffer, options map[string]string) {
+  names := []string{}
+  for k, v := range options {
+    if v != "" {
+      k = fmt.Sprintf("%s %s", k, v)
+    }
+    names = append(names, k)
+  }
+  sort.Strings(names)
+  writeList(b, names)
+}
+
+
+// Verify that crt was signed by the given CA key.
+func VerifyCertificateSignature(crt *ssh.Certificate, ca ssh.PublicKey) error {
+  if !bytes.Equal(crt.SignatureKey.Marshal(), ca.Marshal()) {
+    return errors.New("The certificate was signed by a different CA.")
+  }
+  if crt.Signature == nil {
+    return errors.New("The certificate is not signed.")
+  }
+
+  // The signature covers the certificate up to, but excluding, the
+  // signature field; remove the encoded empty signature.
+  unsigned := *crt
+  unsigned.Signature = nil
+  data := unsigned.Marshal()
+  return crt.SignatureKey.Verify(data[:len(data)-4], crt.Signature)
+}
@@ -0,0 +1,247 @@
+package ssh
+
+import (
+  "bytes"
+  "crypto/rand"
+  "crypto/subtle"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "os/exec"
+  "sync"
+
+  "golang.org/x/crypto/ssh"
+  "golang.org/x/crypto/ssh/agent"
+)
+
+
+var errReadOnlyAgent = errors.New("agent: keys are managed by the configuration file")
+
+
+type agentKey struct {
+  signer ssh.AlgorithmSigner
+  algorithm string
+  certificate *ssh.Certificate
+  comment string
+  confirm bool
+}
+
+
+// Implements agent.ExtendedAgent for keys that are held by a backend. The
+// private key material never leaves the backend; sign requests are routed
+// through the ssh.Signer returned by Backend.GetSecureShellSigner().
+type KeyringAgent struct {
+  mu sync.Mutex
+  keys []*agentKey
+  locked bool
+  passphrase []byte
+  confirm bool
+}
+
+
+func NewKeyringAgent(confirm bool) *KeyringAgent {
+  return &KeyringAgent{confirm: confirm}
+}
+
+
+// Adds a backend signer to the agent. If certificate is not nil, the
+// certificate is offered to clients in addition to the plain public key.
+// The key only signs with the given algorithm, or with the default of
+// GetSignatureAlgorithm if it is empty, because a KMS key is bound to one
+// digest.
+func (self *KeyringAgent) AddSigner(signer ssh.Signer, certificate *ssh.Certificate, comment string, algorithm string, confirm bool) error {
+  algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+  if !ok {
+    return errors.New("unable to cast to ssh.AlgorithmSigner")
+  }
+  pub, ok := signer.PublicKey().(ssh.CryptoPublicKey)
+  if !ok {
+    return errors.New(fmt.Sprintf("Unsupported key type: %s", signer.PublicKey().Type()))
+  }
+  algorithm, err := GetSignatureAlgorithm(pub.CryptoPublicKey(), algorithm)
+  if err != nil {
+    return err
+  }
+  if certificate != nil {
+    if !bytes.Equal(certificate.Key.Marshal(), signer.PublicKey().Marshal()) {
+      return errors.New(fmt.Sprintf(
+        "Certificate %s does not match the public key of the signer.",
+        certificate.KeyId))
+    }
+  }
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  self.keys = append(self.keys, &agentKey{
+    signer: algorithmSigner,
+    algorithm: algorithm,
+    certificate: certificate,
+    comment: comment,
+    confirm: confirm,
+  })
+  return nil
+}
+
+
+func (self *KeyringAgent) List() ([]*agent.Key, error) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  keys := []*agent.Key{}
+  if self.locked {
+    return keys, nil
+  }
+  for _, k := range self.keys {
+    pub := k.signer.PublicKey()
+    keys = append(keys, &agent.Key{
+      Format: pub.Type(),
+      Blob: pub.Marshal(),
+      Comment: k.comment,
+    })
+    if k.certificate != nil {
+      keys = append(keys, &agent.Key{
+        Format: k.certificate.Type(),
+        Blob: k.certificate.Marshal(),
+        Comment: k.comment,
+      })
+    }
+  }
+  return keys, nil
+}
+
+
+func (self *KeyringAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+  return self.SignWithFlags(key, data, 0)
+}
+
+
+func (self *KeyringAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+  self.mu.Lock()
+  if self.locked {
+    self.mu.Unlock()
+    return nil, errors.New("agent: locked")
+  }
+  k := self.find(key.Marshal())
+  self.mu.Unlock()
+  if k == nil {
+    return nil, errors.New("agent: key not found")
+  }
+
+  // RSA clients request the hash algorithm using the flags; the key only
+  // signs with the algorithm of its digest. All other key types have
+  // exactly one signature algorithm.
+  if k.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+    requested := ssh.SigAlgoRSA
+    switch {
+      case flags & agent.SignatureFlagRsaSha512 != 0:
+        requested = ssh.SigAlgoRSASHA2512
+      case flags & agent.SignatureFlagRsaSha256 != 0:
+        requested = ssh.SigAlgoRSASHA2256
+    }
+    if requested != k.algorithm {
+      return nil, errors.New(fmt.Sprintf(
+        "agent: unsupported signature algorithm %s; the key signs with %s",
+        requested, k.algorithm))
+    }
+  }
+
+  if self.confirm || k.confirm {
+    if err := confirmSign(k); err != nil {
+      return nil, err
+    }
+  }
+  return k.signer.SignWithAlgorithm(rand.Reader, data, k.algorithm)
+}
+
+
+func (self *KeyringAgent) find(blob []byte) *agentKey {
+  for _, k := range self.keys {
+    if bytes.Equal(k.signer.PublicKey().Marshal(), blob) {
+      return k
+    }
+    if k.certificate != nil && bytes.Equal(k.certificate.Marshal(), blob) {
+      return k
+    }
+  }
+  return nil
+}
+
+
+func (self *KeyringAgent) Signers() ([]ssh.Signer, error) {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  if self.locked {
+    return nil, errors.New("agent: locked")
+  }
+  signers := []ssh.Signer{}
+  for _, k := range self.keys {
+    signers = append(signers, k.signer)
+  }
+  return signers, nil
+}
+
+
+func (self *KeyringAgent) Lock(passphrase []byte) error {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  if self.locked {
+    return errors.New("agent: already locked")
+  }
+  self.locked = true
+  self.passphrase = passphrase
+  return nil
+}
+
+
+func (self *KeyringAgent) Unlock(passphrase []byte) error {
+  self.mu.Lock()
+  defer self.mu.Unlock()
+  if !self.locked {
+    return errors.New("agent: not locked")
+  }
+  if subtle.ConstantTimeCompare(passphrase, self.passphrase) != 1 {
+    return errors.New("agent: incorrect passphrase")
+  }
+  self.locked = false
+  self.passphrase = nil
+  return nil
+}
+
+
+func (self *KeyringAgent) Add(key agent.AddedKey) error {
+  return errReadOnlyAgent
+}
+
+
+func (self *KeyringAgent) Remove(key ssh.PublicKey) error {
+  return errReadOnlyAgent
+}
+
+
+func (self *KeyringAgent) RemoveAll() error {
+  return errReadOnlyAgent
+}
+
+
+func (self *KeyringAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+  return nil, agent.ErrExtensionUnsupported
+}
+
+
+// Ask the user to confirm the use of a key, using the same convention as
+// ssh-agent(1): the program specified by SSH_ASKPASS (or ssh-askpass) is
+// invoked with SSH_ASKPASS_PROMPT=confirm and must exit with status zero.
+func confirmSign(k *agentKey) error {
+  program := os.Getenv("SSH_ASKPASS")
+  if program == "" {
+    program = "ssh-askpass"
+  }
+  prompt := fmt.Sprintf("Allow use of key %s?\nKey fingerprint %s.",
+    k.comment, ssh.FingerprintSHA256(k.signer.PublicKey()))
+  cmd := exec.Command(program, prompt)
+  cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+  cmd.Stdout = ioutil.Discard
+  if err := cmd.Run(); err != nil {
+    return errors.New("agent: confirmation refused")
+  }
+  return nil
+}
@@ -15,8 +15,20 @@ func Handle(buf []byte, args []string, backend backends.Backend) {
   switch op := args[0]; op {
     case "sign":
       HandleSign(buf, args[1:], backend)
+    case "assemble":
+      HandleAssemble(buf, args[1:], backend)
     case "authorized-key":
       HandleAuthorizedKey(buf, args[1:], backend)
+    case "inspect":
+      HandleInspect(buf, args[1:], backend)
+    case "sign-file":
+      HandleSignFile(buf, args[1:], backend)
+    case "verify-file":
+      HandleVerifyFile(buf, args[1:], backend)
+    case "serve":
+      HandleServe(buf, args[1:], backend)
+    case "agent":
+      HandleAgent(buf, args[1:], backend)
     default:
       log.Fatal("Unknown operation: ", op)
       os.Exit(1)
@@ -0,0 +1,203 @@
+package ssh
+
+import (
+  "bytes"
+  "crypto/rand"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "os"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/offline"
+  "github.com/cochiseruhulessin/cloud-pki/preview"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Stands in for the key of the CA on a host without access to it. The data
+// that would be signed is kept, and the signature is left empty.
+type offlineSigner struct {
+  pub ssh.PublicKey
+  algorithm string
+  data []byte
+}
+
+
+func (self *offlineSigner) PublicKey() ssh.PublicKey {
+  return self.pub
+}
+
+
+func (self *offlineSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+  self.data = append([]byte{}, data...)
+  return &ssh.Signature{Format: self.algorithm, Blob: []byte{}}, nil
+}
+
+
+// Signs with an ephemeral key of the same type as the key of the CA, but
+// names the key of the CA as the signer of the certificate.
+type previewSigner struct {
+  pub ssh.PublicKey
+  signer ssh.Signer
+}
+
+
+func (self *previewSigner) PublicKey() ssh.PublicKey {
+  return self.pub
+}
+
+
+func (self *previewSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+  return self.signer.Sign(rand, data)
+}
+
+
+// Return a signer with the public key of the CA, which is taken from the
+// public-key of the ssh section or else from the X.509 certificate of the
+// CA.
+func newOfflineSigner(opts *dto.X509ConfigurationDTO) (*offlineSigner, error) {
+  var pub ssh.PublicKey
+  if opts.SecureShell.PublicKey != "" {
+    buf, err := ioutil.ReadFile(opts.SecureShell.PublicKey)
+    if err != nil {
+      return nil, err
+    }
+    pub, _, _, _, err = ssh.ParseAuthorizedKey(buf)
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("%s: %s", opts.SecureShell.PublicKey, err))
+    }
+  } else {
+    issuer, err := opts.GetSignerCertificate()
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf(
+        "Can not load the certificate of the CA (%s); set ssh.public-key instead.", err))
+    }
+    pub, err = ssh.NewPublicKey(issuer.PublicKey)
+    if err != nil {
+      return nil, err
+    }
+  }
+  key, ok := pub.(ssh.CryptoPublicKey)
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("Unsupported key type: %s", pub.Type()))
+  }
+  algorithm, err := GetSignatureAlgorithm(key.CryptoPublicKey(), opts.SecureShell.Algorithm)
+  if err != nil {
+    return nil, err
+  }
+  return &offlineSigner{pub: pub, algorithm: algorithm}, nil
+}
+
+
+// Return a signer for previews that uses an ephemeral key of the same type
+// and signature algorithm as the key of the CA.
+func (self *offlineSigner) ephemeral() (*previewSigner, error) {
+  ephemeral, err := preview.NewEphemeralSigner(self.pub.(ssh.CryptoPublicKey).CryptoPublicKey())
+  if err != nil {
+    return nil, err
+  }
+  signer, err := NewAlgorithmSignerFromSigner(ephemeral, self.algorithm)
+  if err != nil {
+    return nil, err
+  }
+  return &previewSigner{pub: self.pub, signer: signer}, nil
+}
+
+
+// Return a bundle with the certificate that the CA would issue for key, so
+// that it can be signed on a host with access to the key of the CA.
+func PrepareSshPublicKey(key ssh.PublicKey, opts *dto.X509ConfigurationDTO, profile string, req *CertificateRequest) (*offline.Bundle, error) {
+  crt, signer, err := buildOffline(key, opts, profile, req, false)
+  if err != nil {
+    return nil, err
+  }
+  return offline.NewSecureShellBundle(crt, signer.data, signer.algorithm)
+}
+
+
+// Return the certificate that the CA would issue for key, signed with an
+// ephemeral key instead of the key of the CA. A sequential serial is not
+// consumed.
+func PreviewSshPublicKey(key ssh.PublicKey, opts *dto.X509ConfigurationDTO, profile string, req *CertificateRequest) (*ssh.Certificate, error) {
+  crt, _, err := buildOffline(key, opts, profile, req, true)
+  return crt, err
+}
+
+
+func buildOffline(key ssh.PublicKey, opts *dto.X509ConfigurationDTO, profile string, req *CertificateRequest, dryRun bool) (*ssh.Certificate, *offlineSigner, error) {
+  builder, err := NewCertificateBuilder(nil, opts, profile)
+  if err != nil {
+    return nil, nil, err
+  }
+  builder.dryRun = dryRun
+  crt, err := builder.Build(key, req)
+  if err != nil {
+    return nil, nil, err
+  }
+  signer := builder.signer.(*offlineSigner)
+  var authority ssh.Signer = signer
+  if dryRun {
+    if authority, err = signer.ephemeral(); err != nil {
+      return nil, nil, err
+    }
+  }
+  if err = crt.SignCert(rand.Reader, authority); err != nil {
+    return nil, nil, err
+  }
+  return crt, signer, nil
+}
+
+
+// Combine a signed bundle into an SSH certificate and verify its signature
+// with the public key of the CA.
+func AssembleSshCertificate(opts *dto.X509ConfigurationDTO, bundle *offline.Bundle) (*ssh.Certificate, error) {
+  signer, err := newOfflineSigner(opts)
+  if err != nil {
+    return nil, err
+  }
+  crt, err := bundle.GetSecureShellCertificate()
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(crt.SignatureKey.Marshal(), signer.pub.Marshal()) {
+    return nil, errors.New(fmt.Sprintf("The certificate is signed by %s, not by the CA.",
+      ssh.FingerprintSHA256(crt.SignatureKey)))
+  }
+  if err := crt.SignatureKey.Verify(bytesForSigning(crt), crt.Signature); err != nil {
+    return nil, errors.New(fmt.Sprintf("Invalid signature: %s", err))
+  }
+  return crt, nil
+}
+
+
+// Combine a bundle that was signed with sign-digest into an SSH
+// certificate and write it to stdout.
+func HandleAssemble(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+
+  parser := flag.NewFlagSet("assemble", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+  if len(stdin) == 0 && parser.NArg() == 0 {
+    log.Fatal("Provide the signed bundle through stdin.")
+  }
+  bundle, err := offline.Load(parser.Arg(0), stdin)
+  if err != nil { log.Fatal(err) }
+  crt, err := AssembleSshCertificate(&opts, bundle)
+  if err != nil { log.Fatal(err) }
+  os.Stdout.Write(ssh.MarshalAuthorizedKey(crt))
+}
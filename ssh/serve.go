@@ -0,0 +1,267 @@
+package ssh
+
+import (
+  "bytes"
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "log"
+  "net/http"
+  "strings"
+  "text/template"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/oidc"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+const MAX_REQUEST_SIZE = 64 * 1024
+
+
+type signingServiceRequest struct {
+  PublicKey string `json:"public_key"`
+  IDToken string `json:"id_token"`
+  Principals []string `json:"principals"`
+  Validity string `json:"validity"`
+}
+
+
+type signingServiceResponse struct {
+  Certificate string `json:"certificate"`
+  Serial uint64 `json:"serial"`
+  Principals []string `json:"principals"`
+  ValidBefore time.Time `json:"valid_before"`
+}
+
+
+type SigningService struct {
+  backend backends.Backend
+  opts *dto.X509ConfigurationDTO
+  verifier *oidc.Verifier
+}
+
+
+// Serve an HTTP endpoint that issues short-lived certificates to callers
+// that authenticate with an ID token.
+func HandleServe(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var listen string
+  var tlsCert string
+  var tlsKey string
+
+  parser := flag.NewFlagSet("serve", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&listen, "listen", ":8080",
+    "specifies the address to listen on.")
+  parser.StringVar(&tlsCert, "tls-cert", "",
+    "specifies the TLS certificate of the server.")
+  parser.StringVar(&tlsKey, "tls-key", "",
+    "specifies the TLS private key of the server.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  service, err := NewSigningService(backend, &opts)
+  if err != nil { log.Fatal(err) }
+
+  mux := http.NewServeMux()
+  mux.Handle("/v1/ssh/sign", service)
+  log.Printf("Listening on %s", listen)
+  if tlsCert != "" {
+    err = http.ListenAndServeTLS(listen, tlsCert, tlsKey, mux)
+  } else {
+    err = http.ListenAndServe(listen, mux)
+  }
+  log.Fatal(err)
+}
+
+
+func NewSigningService(backend backends.Backend, opts *dto.X509ConfigurationDTO) (*SigningService, error) {
+  conf := opts.SecureShell.OIDC
+  if conf.Issuer == "" || conf.Audience == "" {
+    return nil, errors.New("Configure ssh.oidc.issuer and ssh.oidc.audience.")
+  }
+  if len(conf.Rules) == 0 {
+    return nil, errors.New("Configure at least one rule in ssh.oidc.rules.")
+  }
+  return &SigningService{
+    backend: backend,
+    opts: opts,
+    verifier: oidc.NewVerifier(conf.Issuer, conf.Audience, conf.JWKS),
+  }, nil
+}
+
+
+func (self *SigningService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    writeError(w, http.StatusMethodNotAllowed, errors.New("Use POST."))
+    return
+  }
+  req := signingServiceRequest{}
+  decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE))
+  if err := decoder.Decode(&req); err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+    req.IDToken = strings.TrimPrefix(auth, "Bearer ")
+  }
+
+  claims, err := self.verifier.Verify(req.IDToken, time.Now())
+  if err != nil {
+    writeError(w, http.StatusUnauthorized, err)
+    return
+  }
+  // The provider may let users set an address that it did not verify, so
+  // such an address is neither matched by the rules nor used as key id.
+  if !emailVerified(claims) {
+    delete(claims, "email")
+  }
+  profile, principals, err := MapPrincipals(self.opts.SecureShell.OIDC.Rules, claims)
+  if err != nil {
+    writeError(w, http.StatusForbidden, err)
+    return
+  }
+  if len(req.Principals) > 0 {
+    if err = checkSubset(req.Principals, principals); err != nil {
+      writeError(w, http.StatusForbidden, err)
+      return
+    }
+    principals = req.Principals
+  }
+
+  key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  cr := CertificateRequest{
+    KeyID: claims.String("sub"),
+    Principals: principals,
+  }
+  if email := claims.String("email"); email != "" {
+    cr.KeyID = email
+  }
+  if req.Validity != "" {
+    if cr.Validity, err = dto.ParseDuration(req.Validity); err != nil {
+      writeError(w, http.StatusBadRequest, err)
+      return
+    }
+  }
+
+  crt, err := SignSshPublicKey(self.backend, key, self.opts, profile, &cr)
+  if err != nil {
+    writeError(w, http.StatusBadRequest, err)
+    return
+  }
+  log.Printf("Issued certificate %d for %s (principals: %s)", crt.Serial,
+    crt.KeyId, strings.Join(crt.ValidPrincipals, ","))
+  writeJSON(w, http.StatusOK, signingServiceResponse{
+    Certificate: string(ssh.MarshalAuthorizedKey(crt)),
+    Serial: crt.Serial,
+    Principals: crt.ValidPrincipals,
+    ValidBefore: time.Unix(int64(crt.ValidBefore), 0).UTC(),
+  })
+}
+
+
+// Return the profile and principals of the first rule that matches the
+// claims.
+func MapPrincipals(rules []dto.PrincipalRule, claims oidc.Claims) (string, []string, error) {
+  funcs := template.FuncMap{
+    "localpart": func(s string) string { return strings.SplitN(s, "@", 2)[0] },
+    "domain": func(s string) string {
+      parts := strings.SplitN(s, "@", 2)
+      return parts[len(parts) - 1]
+    },
+    "lower": strings.ToLower,
+  }
+  for _, rule := range rules {
+    if !matchClaims(rule.Claims, claims) {
+      continue
+    }
+    principals := []string{}
+    for _, text := range rule.Principals {
+      t, err := template.New("principal").Funcs(funcs).Option("missingkey=error").Parse(text)
+      if err != nil {
+        return "", nil, err
+      }
+      b := &bytes.Buffer{}
+      if err = t.Execute(b, map[string]interface{}(claims)); err != nil {
+        return "", nil, err
+      }
+      if principal := strings.TrimSpace(b.String()); principal != "" {
+        principals = append(principals, principal)
+      }
+    }
+    if len(principals) == 0 {
+      return "", nil, errors.New("The matching rule yields no principals.")
+    }
+    return rule.Profile, principals, nil
+  }
+  return "", nil, errors.New("No rule matches the claims of the token.")
+}
+
+
+// A claim matches if any of its values matches the pattern.
+func matchClaims(patterns map[string]string, claims oidc.Claims) bool {
+  for name, pattern := range patterns {
+    matched := false
+    for _, value := range claims.Strings(name) {
+      matched = matched || matchPatternList(value, pattern)
+    }
+    if !matched {
+      return false
+    }
+  }
+  return true
+}
+
+
+func checkSubset(requested []string, allowed []string) error {
+  for _, r := range requested {
+    found := false
+    for _, a := range allowed {
+      found = found || r == a
+    }
+    if !found {
+      return errors.New(fmt.Sprintf("Principal %s is not allowed.", r))
+    }
+  }
+  return nil
+}
+
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+
+func writeError(w http.ResponseWriter, status int, err error) {
+  writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+
+// Some providers encode the email_verified claim as a string.
+func emailVerified(claims oidc.Claims) bool {
+  switch v := claims["email_verified"].(type) {
+    case bool:
+      return v
+    case string:
+      return v == "true"
+  }
+  return false
+}
@@ -1,27 +1,55 @@
 package ssh
 
 import (
-  "crypto/rand"
+  "errors"
   "flag"
+  "fmt"
+  "io/ioutil"
   "log"
   "os"
+  "strings"
 
   "golang.org/x/crypto/ssh"
 
   "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/preview"
   "github.com/cochiseruhulessin/cloud-pki/x509/dto"
 )
 
 
 func HandleSign(stdin []byte, args []string, backend backends.Backend) {
+  var asJSON bool
   var constraints string
   var caConf string
+  var diffFile string
+  var dryRun bool
+  var keyId string
+  var prepare bool
+  var principals string
+  var profile string
+  var validity string
 
   parser := flag.NewFlagSet("ssh", flag.ExitOnError)
   parser.StringVar(&caConf, "ca", "",
     "specifies the Certificate Authority (CA) configuration file.")
   parser.StringVar(&constraints, "-C", "",
     "specifies a configuration file with constraints.")
+  parser.StringVar(&profile, "profile", "",
+    "specifies the SSH profile of the CA that is used to sign the key.")
+  parser.StringVar(&keyId, "I", "",
+    "specifies the key identity of the certificate.")
+  parser.StringVar(&principals, "n", "",
+    "specifies a comma-separated list of principals.")
+  parser.StringVar(&validity, "V", "",
+    "specifies the validity of the certificate as a duration, e.g. 8h.")
+  parser.BoolVar(&prepare, "prepare", false,
+    "writes a bundle to be signed with sign-digest instead of signing the key.")
+  parser.BoolVar(&dryRun, "dry-run", false,
+    "describes the certificate, signed with an ephemeral key, instead of signing the key.")
+  parser.BoolVar(&asJSON, "json", false,
+    "writes the description of -dry-run as JSON.")
+  parser.StringVar(&diffFile, "diff", "",
+    "compares the certificate of -dry-run with a previous certificate.")
   parser.Parse(args)
 
   if len(stdin) == 0 {
@@ -37,43 +65,70 @@ func HandleSign(stdin []byte, args []string, backend backends.Backend) {
   err = opts.Load(caConf, nil)
   if err != nil { log.Fatal(err) }
 
-  SignSshPublicKey(backend, key, &opts.Signer)
+  req := CertificateRequest{KeyID: keyId}
+  if principals != "" {
+    req.Principals = strings.Split(principals, ",")
+  }
+  if validity != "" {
+    req.Validity, err = dto.ParseDuration(validity)
+    if err != nil { log.Fatal(err) }
+  }
+
+  if dryRun {
+    crt, err := PreviewSshPublicKey(key, &opts, profile, &req)
+    if err != nil { log.Fatal(err) }
+    err = writeDryRun(crt, diffFile, asJSON)
+    if err != nil { log.Fatal(err) }
+    return
+  }
+  if prepare {
+    bundle, err := PrepareSshPublicKey(key, &opts, profile, &req)
+    if err != nil { log.Fatal(err) }
+    os.Stdout.Write(bundle.Marshal())
+    return
+  }
+  crt, err := SignSshPublicKey(backend, key, &opts, profile, &req)
+  if err != nil { log.Fatal(err) }
+  os.Stdout.Write(ssh.MarshalAuthorizedKey(crt))
   return
 }
 
 
-func SignSshPublicKey(backend backends.Backend, key ssh.PublicKey, ca *dto.Signer) {
-  signer, err := NewAlgorithmSignerFromSigner(
-    backend.GetSigner(ca.KeyID),
-    "rsa-sha2-256")
+// Issue a certificate for key with the given profile of the CA.
+func SignSshPublicKey(backend backends.Backend, key ssh.PublicKey, opts *dto.X509ConfigurationDTO, profile string, req *CertificateRequest) (*ssh.Certificate, error) {
+  builder, err := NewCertificateBuilder(backend, opts, profile)
   if err != nil {
-    log.Fatal(err)
+    return nil, err
   }
-
-  crt := ssh.Certificate{
-    Key: key,
-    CertType: ssh.UserCert,
-    ValidAfter: 0,
-    ValidBefore: ssh.CertTimeInfinity,
-    ValidPrincipals: []string{"cochise"},
-    Serial: 1,
-    Permissions: ssh.Permissions{
-      CriticalOptions: map[string]string{
-        "force-command": "vim",
-      },
-      Extensions:      map[string]string{
-        "permit-pty": "",
-        "no-agent-forwarding": "",
-        "no-port-forwarding": "",
-        "no-user-rc": "",
-        "no-x11-forwarding": "",
-      },
-    },
-    Reserved: []byte{},
+  crt, err := builder.Build(key, req)
+  if err != nil {
+    return nil, err
   }
-
-  crt.SignCert(rand.Reader, signer)
-  os.Stdout.Write(ssh.MarshalAuthorizedKey(&crt))
-  return
+  if err = builder.Sign(crt); err != nil {
+    return nil, err
+  }
+  return crt, nil
 }
 
+
+// Write the description of a certificate that would be issued, and the
+// differences with a previous certificate if diffFile is not empty.
+func writeDryRun(crt *ssh.Certificate, diffFile string, asJSON bool) error {
+  var previous preview.Description
+  if diffFile != "" {
+    buf, err := ioutil.ReadFile(diffFile)
+    if err != nil {
+      return err
+    }
+    key, _, _, _, err := ssh.ParseAuthorizedKey(buf)
+    if err != nil {
+      return err
+    }
+    old, ok := key.(*ssh.Certificate)
+    if !ok {
+      return errors.New(fmt.Sprintf("%s is not an OpenSSH certificate.", diffFile))
+    }
+    previous = DescribeCertificate(old)
+  }
+  return preview.NewReport(DescribeCertificate(crt), previous).Write(os.Stdout, asJSON, diffFile)
+}
@@ -0,0 +1,73 @@
+package ssh
+
+import (
+  "flag"
+  "io/ioutil"
+  "log"
+  "os"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/audit"
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Sign the data on stdin (or in the file given as the first positional
+// argument) and write an armored SSH signature to stdout. The output
+// is compatible with ssh-keygen -Y sign.
+func HandleSignFile(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var certificate string
+  var hashAlgorithm string
+  var namespace string
+  var err error
+
+  parser := flag.NewFlagSet("sign-file", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&namespace, "n", "",
+    "specifies the signature namespace, e.g. \"file\" or \"git\".")
+  parser.StringVar(&certificate, "cert", "",
+    "embed this OpenSSH certificate for the signing key in the signature.")
+  parser.StringVar(&hashAlgorithm, "hash", "sha512",
+    "specifies the hash algorithm applied to the message (sha256 or sha512).")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  if namespace == "" {
+    log.Fatal("The -n parameter is mandatory.")
+  }
+  message := stdin
+  if parser.NArg() > 0 {
+    message, err = ioutil.ReadFile(parser.Arg(0))
+    if err != nil { log.Fatal(err) }
+  }
+
+  opts := dto.X509ConfigurationDTO{}
+  err = opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  signer, err := NewSecureShellSigner(backend, &opts)
+  if err != nil { log.Fatal(err) }
+  var pub ssh.PublicKey = signer.PublicKey()
+  if certificate != "" {
+    crt, err := readCertificate(certificate)
+    if err != nil { log.Fatal(err) }
+    pub = crt
+  }
+
+  sig, err := SignMessage(signer, pub, namespace, hashAlgorithm, message)
+  if err != nil { log.Fatal(err) }
+  if opts.Audit != "" {
+    // The message is known to be hashable, because it was signed.
+    data, _ := sshsigMessage(namespace, hashAlgorithm, message)
+    record := audit.NewRecord(audit.OPERATION_SSH_SIGNATURE, opts.Signer.KeyID, data)
+    record.Subject = namespace
+    if err := audit.Open(opts.Audit).Append(record); err != nil { log.Fatal(err) }
+  }
+  os.Stdout.Write(sig.Marshal())
+}
@@ -2,10 +2,17 @@ package ssh
 
 import (
   "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/rsa"
   "errors"
+  "fmt"
   "io"
 
   "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
 )
 
 
@@ -41,3 +48,54 @@ func NewAlgorithmSignerFromSigner(signer crypto.Signer, algorithm string) (ssh.S
 	}
 	return &s, nil
 }
+
+
+// Return the SSH signature algorithm that is used with the public key of a
+// CA. The algorithm is derived from the key type; RSA keys may override the
+// default of rsa-sha2-256 with rsa-sha2-512. SHA-1 signatures (ssh-rsa)
+// are never used.
+func GetSignatureAlgorithm(pub crypto.PublicKey, override string) (string, error) {
+  var algorithm string
+  switch key := pub.(type) {
+    case *rsa.PublicKey:
+      switch override {
+        case "", ssh.SigAlgoRSASHA2256:
+          return ssh.SigAlgoRSASHA2256, nil
+        case ssh.SigAlgoRSASHA2512:
+          return ssh.SigAlgoRSASHA2512, nil
+      }
+      return "", errors.New(fmt.Sprintf(
+        "Signature algorithm %s is not allowed for RSA keys.", override))
+    case *ecdsa.PublicKey:
+      switch key.Curve.Params().BitSize {
+        case 256:
+          algorithm = ssh.KeyAlgoECDSA256
+        case 384:
+          algorithm = ssh.KeyAlgoECDSA384
+        default:
+          return "", errors.New(fmt.Sprintf("Unsupported curve: %s",
+            key.Curve.Params().Name))
+      }
+    case ed25519.PublicKey:
+      algorithm = ssh.KeyAlgoED25519
+    default:
+      return "", errors.New(fmt.Sprintf("Unsupported key type: %T", pub))
+  }
+  if override != "" && override != algorithm {
+    return "", errors.New(fmt.Sprintf(
+      "Signature algorithm %s does not match the key type %s.", override, algorithm))
+  }
+  return algorithm, nil
+}
+
+
+// Return a signer for the key of the CA that signs with the algorithm
+// configured in the ssh section of the CA configuration.
+func NewSecureShellSigner(backend backends.Backend, opts *dto.X509ConfigurationDTO) (ssh.Signer, error) {
+  signer := backend.GetSigner(opts.Signer.KeyID)
+  algorithm, err := GetSignatureAlgorithm(signer.Public(), opts.SecureShell.Algorithm)
+  if err != nil {
+    return nil, err
+  }
+  return NewAlgorithmSignerFromSigner(signer, algorithm)
+}
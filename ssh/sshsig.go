@@ -0,0 +1,198 @@
+package ssh
+
+import (
+  "bytes"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/sha512"
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "strings"
+
+  "golang.org/x/crypto/ssh"
+)
+
+
+// The SSHSIG format is specified in PROTOCOL.sshsig of the OpenSSH
+// distribution.
+const (
+  sshsigMagic = "SSHSIG"
+  sshsigVersion = 1
+  sshsigArmorBegin = "-----BEGIN SSH SIGNATURE-----"
+  sshsigArmorEnd = "-----END SSH SIGNATURE-----"
+  sshsigLineLength = 70
+)
+
+
+type sshsigBlob struct {
+  Version uint32
+  PublicKey []byte
+  Namespace string
+  Reserved string
+  HashAlgorithm string
+  Signature []byte
+}
+
+
+type sshsigSignedData struct {
+  Namespace string
+  Reserved string
+  HashAlgorithm string
+  Hash []byte
+}
+
+
+type FileSignature struct {
+  PublicKey ssh.PublicKey
+  Namespace string
+  HashAlgorithm string
+  Signature *ssh.Signature
+}
+
+
+func hashMessage(algorithm string, message []byte) ([]byte, error) {
+  switch algorithm {
+    case "sha256":
+      h := sha256.Sum256(message)
+      return h[:], nil
+    case "sha512":
+      h := sha512.Sum512(message)
+      return h[:], nil
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported hash algorithm: %s", algorithm))
+  }
+}
+
+
+func sshsigMessage(namespace string, hashAlgorithm string, message []byte) ([]byte, error) {
+  h, err := hashMessage(hashAlgorithm, message)
+  if err != nil {
+    return nil, err
+  }
+  return append([]byte(sshsigMagic), ssh.Marshal(sshsigSignedData{
+    Namespace: namespace,
+    HashAlgorithm: hashAlgorithm,
+    Hash: h,
+  })...), nil
+}
+
+
+// Sign message in the given namespace. The public key embedded in the
+// signature is pub, which is either the public key of signer or a
+// certificate issued for it.
+func SignMessage(signer ssh.Signer, pub ssh.PublicKey, namespace string, hashAlgorithm string, message []byte) (*FileSignature, error) {
+  if namespace == "" {
+    return nil, errors.New("A namespace is mandatory.")
+  }
+  data, err := sshsigMessage(namespace, hashAlgorithm, message)
+  if err != nil {
+    return nil, err
+  }
+  sig, err := signer.Sign(rand.Reader, data)
+  if err != nil {
+    return nil, err
+  }
+  return &FileSignature{
+    PublicKey: pub,
+    Namespace: namespace,
+    HashAlgorithm: hashAlgorithm,
+    Signature: sig,
+  }, nil
+}
+
+
+func ParseFileSignature(armored []byte) (*FileSignature, error) {
+  text := strings.TrimSpace(string(armored))
+  if !strings.HasPrefix(text, sshsigArmorBegin) || !strings.HasSuffix(text, sshsigArmorEnd) {
+    return nil, errors.New("Not an armored SSH signature.")
+  }
+  text = strings.TrimSuffix(strings.TrimPrefix(text, sshsigArmorBegin), sshsigArmorEnd)
+  buf, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.HasPrefix(buf, []byte(sshsigMagic)) {
+    return nil, errors.New("Invalid SSH signature preamble.")
+  }
+
+  blob := sshsigBlob{}
+  if err := ssh.Unmarshal(buf[len(sshsigMagic):], &blob); err != nil {
+    return nil, err
+  }
+  if blob.Version != sshsigVersion {
+    return nil, errors.New(fmt.Sprintf("Unsupported SSH signature version: %d", blob.Version))
+  }
+  pub, err := ssh.ParsePublicKey(blob.PublicKey)
+  if err != nil {
+    return nil, err
+  }
+  sig := ssh.Signature{}
+  if err := ssh.Unmarshal(blob.Signature, &sig); err != nil {
+    return nil, err
+  }
+  return &FileSignature{
+    PublicKey: pub,
+    Namespace: blob.Namespace,
+    HashAlgorithm: blob.HashAlgorithm,
+    Signature: &sig,
+  }, nil
+}
+
+
+// Verify the cryptographic signature over message. This does not establish
+// that the signer is trusted; see AllowedSigners.
+func (self *FileSignature) Verify(namespace string, message []byte) error {
+  if self.Namespace != namespace {
+    return errors.New(fmt.Sprintf("Signature namespace %q does not match %q.",
+      self.Namespace, namespace))
+  }
+  if keyType(self.PublicKey) == ssh.KeyAlgoRSA {
+    switch self.Signature.Format {
+      case ssh.SigAlgoRSASHA2256, ssh.SigAlgoRSASHA2512:
+      default:
+        return errors.New(fmt.Sprintf("RSA signature algorithm %s is not allowed.",
+          self.Signature.Format))
+    }
+  }
+  data, err := sshsigMessage(self.Namespace, self.HashAlgorithm, message)
+  if err != nil {
+    return err
+  }
+  return self.PublicKey.Verify(data, self.Signature)
+}
+
+
+func (self *FileSignature) Marshal() []byte {
+  blob := append([]byte(sshsigMagic), ssh.Marshal(sshsigBlob{
+    Version: sshsigVersion,
+    PublicKey: self.PublicKey.Marshal(),
+    Namespace: self.Namespace,
+    HashAlgorithm: self.HashAlgorithm,
+    Signature: ssh.Marshal(self.Signature),
+  })...)
+  encoded := base64.StdEncoding.EncodeToString(blob)
+
+  b := &bytes.Buffer{}
+  b.WriteString(sshsigArmorBegin)
+  b.WriteByte('\n')
+  for len(encoded) > sshsigLineLength {
+    b.WriteString(encoded[:sshsigLineLength])
+    b.WriteByte('\n')
+    encoded = encoded[sshsigLineLength:]
+  }
+  b.WriteString(encoded)
+  b.WriteByte('\n')
+  b.WriteString(sshsigArmorEnd)
+  b.WriteByte('\n')
+  return b.Bytes()
+}
+
+
+// Return the type of the key, or of the key certified by a certificate.
+func keyType(pub ssh.PublicKey) string {
+  if crt, ok := pub.(*ssh.Certificate); ok {
+    return crt.Key.Type()
+  }
+  return pub.Type()
+}
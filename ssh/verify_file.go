@@ -0,0 +1,63 @@
+package ssh
+
+import (
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+)
+
+
+// Verify an armored SSH signature over the data on stdin against an
+// allowed_signers file, like ssh-keygen -Y verify.
+func HandleVerifyFile(stdin []byte, args []string, backend backends.Backend) {
+  var allowedSigners string
+  var identity string
+  var namespace string
+  var signature string
+
+  parser := flag.NewFlagSet("verify-file", flag.ExitOnError)
+  parser.StringVar(&allowedSigners, "f", "",
+    "specifies the allowed_signers file.")
+  parser.StringVar(&identity, "I", "",
+    "specifies the identity of the signer.")
+  parser.StringVar(&namespace, "n", "",
+    "specifies the signature namespace.")
+  parser.StringVar(&signature, "s", "",
+    "specifies the file holding the armored signature.")
+  parser.Parse(args)
+
+  if allowedSigners == "" || identity == "" || namespace == "" || signature == "" {
+    log.Fatal("The -f, -I, -n and -s parameters are mandatory.")
+  }
+
+  buf, err := ioutil.ReadFile(signature)
+  if err != nil { log.Fatal(err) }
+  sig, err := ParseFileSignature(buf)
+  if err != nil { log.Fatal(err) }
+
+  signers, err := LoadAllowedSigners(allowedSigners)
+  if err != nil { log.Fatal(err) }
+
+  if err = sig.Verify(namespace, stdin); err != nil {
+    log.Fatal("Signature verification failed: ", err)
+  }
+  if _, err = signers.Authorize(sig, identity, time.Now()); err != nil {
+    log.Fatal("Signature verification failed: ", err)
+  }
+
+  pub := sig.PublicKey
+  detail := ""
+  if crt, ok := pub.(*ssh.Certificate); ok {
+    pub = crt.Key
+    detail = fmt.Sprintf(" (certificate ID %q serial %d signed by CA %s)",
+      crt.KeyId, crt.Serial, ssh.FingerprintSHA256(crt.SignatureKey))
+  }
+  fmt.Printf("Good %q signature for %s with %s key %s%s\n", namespace,
+    identity, pub.Type(), ssh.FingerprintSHA256(pub), detail)
+}
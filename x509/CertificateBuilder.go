@@ -1,21 +1,39 @@
 package x509
 
 import (
-  "crypto/rsa"
+  "crypto"
+  "crypto/rand"
   "crypto/x509"
+  "crypto/x509/pkix"
   "fmt"
   "errors"
+  "log"
+  "time"
 
+  "github.com/cochiseruhulessin/cloud-pki/audit"
   "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/preview"
   "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/lint"
 )
 
 
+var EXTENDED_KEY_USAGES = map[string]x509.ExtKeyUsage{
+  "any": x509.ExtKeyUsageAny,
+  "serverAuth": x509.ExtKeyUsageServerAuth,
+  "clientAuth": x509.ExtKeyUsageClientAuth,
+  "codeSigning": x509.ExtKeyUsageCodeSigning,
+  "emailProtection": x509.ExtKeyUsageEmailProtection,
+  "timeStamping": x509.ExtKeyUsageTimeStamping,
+  "OCSPSigning": x509.ExtKeyUsageOCSPSigning,
+}
+
+
 type CertificateBuilder struct {
   backend backends.Backend
   opts dto.X509ConfigurationDTO
   issuer *x509.Certificate
-  constraints dto.CertificateConstraints
+  profile dto.X509Profile
   selfSigned bool
 }
 
@@ -25,13 +43,26 @@ func (self *CertificateBuilder) FromCSR(csr *x509.CertificateRequest) (*x509.Cer
   crt := x509.Certificate{
     PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
     PublicKey: csr.PublicKey,
-    RawSubject: csr.RawSubject,
-    DNSNames: csr.DNSNames,
-    EmailAddresses: csr.EmailAddresses,
-    IPAddresses: csr.IPAddresses,
-    URIs: csr.URIs,
   }
-  self.constraints.GetTimeBounds(&crt, &self.opts.Defaults)
+  crt.RawSubject, err = RenderSubject(self.profile.Subject, csr)
+  if err != nil {
+    return nil, err
+  }
+  err = self.setSubjectAltNames(&crt, csr)
+  if err != nil {
+    return nil, err
+  }
+  constraints := self.profile.CertificateConstraints
+  err = constraints.GetTimeBounds(&crt, &self.opts.Defaults, time.Now())
+  if err != nil {
+    return nil, err
+  }
+  if !self.selfSigned {
+    err = self.clampTimeBounds(&crt, constraints.IssuerExpiry)
+    if err != nil {
+      return nil, err
+    }
+  }
 
   serial, err := GenerateX509Serial()
   if err != nil {
@@ -39,17 +70,22 @@ func (self *CertificateBuilder) FromCSR(csr *x509.CertificateRequest) (*x509.Cer
   }
   crt.SerialNumber = serial
 
-  err = self.setKeyUsage(&crt, self.constraints.Usage)
+  err = self.setKeyUsage(&crt, constraints.Usage)
   if err != nil {
     return nil, err
   }
 
-  err = self.setExtendedKeyUsage(&crt, self.constraints.ExtendedUsage)
+  err = self.setExtendedKeyUsage(&crt, constraints.ExtendedUsage)
   if err != nil {
     return nil, err
   }
 
-  ski, err := GetPublicKeyIdentifier(csr.PublicKey.(*rsa.PublicKey))
+  err = self.setExtensions(&crt, self.profile.Extensions)
+  if err != nil {
+    return nil, err
+  }
+
+  ski, err := GetPublicKeyIdentifier(csr.PublicKey)
   if err != nil {
     return nil, err
   }
@@ -58,11 +94,11 @@ func (self *CertificateBuilder) FromCSR(csr *x509.CertificateRequest) (*x509.Cer
     crt.AuthorityKeyId = ski
   }
 
-  if self.constraints.CA.Issuer {
+  if constraints.CA.Issuer {
     crt.IsCA = true
     crt.BasicConstraintsValid = true
-    if self.constraints.CA.PathLength > -1 {
-      crt.MaxPathLen = self.constraints.CA.PathLength
+    if constraints.CA.PathLength > -1 {
+      crt.MaxPathLen = constraints.CA.PathLength
       if crt.MaxPathLen == 0 {
         crt.MaxPathLenZero = true
       }
@@ -70,22 +106,276 @@ func (self *CertificateBuilder) FromCSR(csr *x509.CertificateRequest) (*x509.Cer
   }
 
   if !self.selfSigned {
+    err = CheckIssuer(self.issuer, &crt, time.Now())
+    if err != nil {
+      return nil, err
+    }
     crt.RawIssuer = self.issuer.RawSubject
   } else {
-    crt.RawIssuer = csr.RawSubject
+    crt.RawIssuer = crt.RawSubject
+  }
+
+  return &crt, nil
+}
+
+// Return a certificate with the subject, public key and extensions of an
+// existing CA certificate, to be issued by the CA, e.g. to cross-sign it. The
+// Authority Key Identifier, Authority Information Access and CRL
+// Distribution Points extensions are not copied, because they refer to the
+// issuer of the existing certificate. The validity is that of the profile.
+func (self *CertificateBuilder) FromCertificate(existing *x509.Certificate) (*x509.Certificate, error) {
+  if !existing.BasicConstraintsValid || !existing.IsCA {
+    return nil, errors.New("Only CA certificates can be cross-signed.")
+  }
+  crt := x509.Certificate{
+    RawSubject: existing.RawSubject,
+    PublicKeyAlgorithm: existing.PublicKeyAlgorithm,
+    PublicKey: existing.PublicKey,
+    SubjectKeyId: existing.SubjectKeyId,
+    KeyUsage: existing.KeyUsage,
+    ExtKeyUsage: existing.ExtKeyUsage,
+    UnknownExtKeyUsage: existing.UnknownExtKeyUsage,
+    BasicConstraintsValid: existing.BasicConstraintsValid,
+    IsCA: existing.IsCA,
+    MaxPathLen: existing.MaxPathLen,
+    MaxPathLenZero: existing.MaxPathLenZero,
+    DNSNames: existing.DNSNames,
+    EmailAddresses: existing.EmailAddresses,
+    IPAddresses: existing.IPAddresses,
+    URIs: existing.URIs,
+  }
+  for _, ext := range existing.Extensions {
+    switch {
+      case ext.Id.Equal(oidAuthorityKeyId), ext.Id.Equal(oidAuthorityInfoAccess),
+        ext.Id.Equal(oidCrlDistribution):
+      default:
+        crt.ExtraExtensions = append(crt.ExtraExtensions, ext)
+    }
   }
 
+  constraints := self.profile.CertificateConstraints
+  err := constraints.GetTimeBounds(&crt, &self.opts.Defaults, time.Now())
+  if err != nil {
+    return nil, err
+  }
+  if err = self.clampTimeBounds(&crt, constraints.IssuerExpiry); err != nil {
+    return nil, err
+  }
+  crt.SerialNumber, err = GenerateX509Serial()
+  if err != nil {
+    return nil, err
+  }
+
+  // The issuer may constrain the path length of a certificate that does
+  // not specify one; the Basic Constraints are then encoded from crt.
+  if err = CheckIssuer(self.issuer, &crt, time.Now()); err != nil {
+    return nil, err
+  }
+  if crt.MaxPathLen != existing.MaxPathLen {
+    extensions := []pkix.Extension{}
+    for _, ext := range crt.ExtraExtensions {
+      if !ext.Id.Equal(oidBasicConstraints) {
+        extensions = append(extensions, ext)
+      }
+    }
+    crt.ExtraExtensions = extensions
+  }
+  crt.RawIssuer = self.issuer.RawSubject
   return &crt, nil
 }
 
+
+func (self *CertificateBuilder) SetAuthorityInformation(crt *x509.Certificate, aia *dto.X509ConfigurationDTO) {
+  if len(aia.CRLDistribution.URLS) > 0 {
+    crt.CRLDistributionPoints = aia.CRLDistribution.URLS
+  }
+  if len(aia.AuthorityInfoAccess.URLS) > 0 {
+    crt.IssuingCertificateURL = aia.AuthorityInfoAccess.URLS
+  }
+  if len(aia.AuthorityInfoAccess.OCSP) > 0 {
+    crt.OCSPServer = aia.AuthorityInfoAccess.OCSP
+  }
+}
+
+
+// Sign crt with the key of the CA and return the DER-encoded certificate.
+// The certificate is linted before the key of the CA is used.
+func (self *CertificateBuilder) Sign(crt *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+  signer := self.backend.GetSigner(self.opts.Signer.KeyID)
+  if err := self.checkLint(crt, pub, signer.Public()); err != nil {
+    return nil, err
+  }
+
+  // If we are self-signing, then the issuer is also the certificate
+  // to be signed.
+  issuer := self.issuer
+  if self.selfSigned {
+    issuer = crt
+  }
+  der, err := x509.CreateCertificate(rand.Reader, crt, issuer, pub, signer)
+  if err != nil || self.opts.Audit == "" {
+    return der, err
+  }
+  if err = self.audit(der); err != nil {
+    return nil, err
+  }
+  return der, nil
+}
+
+
+// Append the signature of a certificate to the audit log of the CA. The
+// certificate is not returned if it can not be audited.
+func (self *CertificateBuilder) audit(der []byte) error {
+  crt, err := x509.ParseCertificate(der)
+  if err != nil {
+    return err
+  }
+  record := audit.NewRecord(audit.OPERATION_X509_CERTIFICATE, self.opts.Signer.KeyID,
+    crt.RawTBSCertificate)
+  record.Issuer = crt.Issuer.String()
+  record.Subject = crt.Subject.String()
+  record.Serial = fmt.Sprintf("%x", crt.SerialNumber)
+  return audit.Open(self.opts.Audit).Append(record)
+}
+
+
+// Log the warnings of the lint rules and return an error if a rule with
+// severity error fails.
+func (self *CertificateBuilder) checkLint(crt *x509.Certificate, pub crypto.PublicKey, caKey crypto.PublicKey) error {
+  results, err := self.Lint(crt, pub, caKey)
+  if err != nil {
+    return err
+  }
+  return reportLint(results)
+}
+
+
+// Log the warnings of the lint rules and return their errors.
+func reportLint(results lint.Results) error {
+  for _, r := range results {
+    if r.Severity == lint.SEVERITY_WARNING {
+      log.Printf("%s", r)
+    }
+  }
+  return results.Err()
+}
+
+
+// Sign crt with an ephemeral key of the same type as caKey and return the
+// DER-encoded certificate. It is identical to the certificate that the CA
+// would issue, except for the signature.
+func (self *CertificateBuilder) Preview(crt *x509.Certificate, pub crypto.PublicKey, caKey crypto.PublicKey) ([]byte, error) {
+  signer, err := preview.NewEphemeralSigner(caKey)
+  if err != nil {
+    return nil, err
+  }
+  parent := *self.issuer
+  if self.selfSigned {
+    parent = *crt
+  }
+  parent.PublicKey = nil
+  return x509.CreateCertificate(rand.Reader, crt, &parent, pub, signer)
+}
+
+
+// Run the lint rules against the certificate that would be issued.
+func (self *CertificateBuilder) Lint(crt *x509.Certificate, pub crypto.PublicKey, caKey crypto.PublicKey) (lint.Results, error) {
+  der, err := self.Preview(crt, pub, caKey)
+  if err != nil {
+    return nil, err
+  }
+  previewed, err := x509.ParseCertificate(der)
+  if err != nil {
+    return nil, err
+  }
+  return lint.Run(previewed, self.opts.Lint.GetSeverities(&self.profile.Lint))
+}
+
+
+// Restrict the validity period of crt to that of the issuer. If policy is
+// "refuse", an error is returned instead.
+func (self *CertificateBuilder) clampTimeBounds(crt *x509.Certificate, policy string) error {
+  if policy != "" && policy != "clamp" && policy != "refuse" {
+    return errors.New(fmt.Sprintf("Invalid issuer-expiry: %s", policy))
+  }
+  if crt.NotAfter.After(self.issuer.NotAfter) {
+    if policy == "refuse" {
+      return errors.New(fmt.Sprintf(
+        "The certificate would expire at %s, after its issuer (%s).",
+        crt.NotAfter.Format(time.RFC3339), self.issuer.NotAfter.Format(time.RFC3339)))
+    }
+    log.Printf("Warning: the expiry date was adjusted from %s to %s, the expiry date of the issuer.",
+      crt.NotAfter.Format(time.RFC3339), self.issuer.NotAfter.Format(time.RFC3339))
+    crt.NotAfter = self.issuer.NotAfter
+  }
+  if crt.NotBefore.Before(self.issuer.NotBefore) {
+    log.Printf("Warning: the start date was adjusted from %s to %s, the start date of the issuer.",
+      crt.NotBefore.Format(time.RFC3339), self.issuer.NotBefore.Format(time.RFC3339))
+    crt.NotBefore = self.issuer.NotBefore
+  }
+  if !crt.NotAfter.After(crt.NotBefore) {
+    return errors.New("The validity period of the certificate is outside that of its issuer.")
+  }
+  return nil
+}
+
+
+// Copy the Subject Alternative Names that are allowed by the profile from
+// the CSR.
+func (self *CertificateBuilder) setSubjectAltNames(crt *x509.Certificate, csr *x509.CertificateRequest) error {
+  rules := self.profile.SubjectAltNames
+  kinds := map[string]int{
+    "dns": len(csr.DNSNames),
+    "email": len(csr.EmailAddresses),
+    "ip": len(csr.IPAddresses),
+    "uri": len(csr.URIs),
+  }
+  total := 0
+  for kind, n := range kinds {
+    if n > 0 && !rules.Allows(kind) {
+      return errors.New(fmt.Sprintf(
+        "The profile does not allow Subject Alternative Names of type %s.", kind))
+    }
+    total += n
+  }
+  if rules.Required && total == 0 {
+    return errors.New("The profile requires a Subject Alternative Name.")
+  }
+  crt.DNSNames = csr.DNSNames
+  crt.EmailAddresses = csr.EmailAddresses
+  crt.IPAddresses = csr.IPAddresses
+  crt.URIs = csr.URIs
+  return nil
+}
+
+
+func (self *CertificateBuilder) setExtensions(crt *x509.Certificate, extensions []dto.X509Extension) error {
+  for _, e := range extensions {
+    oid, err := e.GetObjectIdentifier()
+    if err != nil {
+      return err
+    }
+    value, err := e.GetValue()
+    if err != nil {
+      return errors.New(fmt.Sprintf("Invalid value for extension %s: %s", e.OID, err))
+    }
+    crt.ExtraExtensions = append(crt.ExtraExtensions, pkix.Extension{
+      Id: oid,
+      Critical: e.Critical,
+      Value: value,
+    })
+  }
+  return nil
+}
+
+
 func (self *CertificateBuilder) setExtendedKeyUsage(crt *x509.Certificate, usage []string) error {
   for _, u := range usage {
-    switch u {
-      case "serverAuth":
-        crt.ExtKeyUsage = append(crt.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
-      default:
-        return errors.New(fmt.Sprintf("Invalid extKeyUsage: %s", u))
+    value, ok := EXTENDED_KEY_USAGES[u]
+    if !ok {
+      return errors.New(fmt.Sprintf("Invalid extKeyUsage: %s", u))
     }
+    crt.ExtKeyUsage = append(crt.ExtKeyUsage, value)
   }
   return nil
 }
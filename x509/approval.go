@@ -0,0 +1,439 @@
+package x509
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/hex"
+  "encoding/json"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "net"
+  "os"
+  "os/user"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+  "golang.org/x/crypto/ssh/agent"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  sshca "github.com/cochiseruhulessin/cloud-pki/ssh"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// The SSHSIG namespace of approvals, so that signatures made for other
+// purposes can not be presented as an approval.
+const APPROVAL_NAMESPACE = "cloud-pki-approval"
+
+
+// The signers of ssh-agent keys, which select the RSA signature algorithm
+// with the hash function.
+type agentSigner interface {
+  SignWithOpts(rand io.Reader, data []byte, opts crypto.SignerOpts) (*ssh.Signature, error)
+}
+
+
+// Signs with rsa-sha2-512, because SSH signatures made with ssh-rsa (SHA-1)
+// are rejected.
+type rsaOperatorSigner struct {
+  ssh.Signer
+}
+
+
+func (self *rsaOperatorSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+  switch signer := self.Signer.(type) {
+    case ssh.AlgorithmSigner:
+      return signer.SignWithAlgorithm(rand, data, ssh.SigAlgoRSASHA2512)
+    case agentSigner:
+      return signer.SignWithOpts(rand, data, crypto.SHA512)
+    default:
+      return nil, errors.New("The RSA key can not sign with rsa-sha2-512.")
+  }
+}
+
+
+var requestIdPattern = regexp.MustCompile("^[0-9a-f]{16}$")
+
+
+// A CSR that waits for the approval of the operators of a CA. After the
+// certificate is issued, the request is kept in the queue with the
+// certificate as the record of who approved it.
+type ApprovalRequest struct {
+  ID string `json:"id"`
+  Submitted time.Time `json:"submitted"`
+  Submitter string `json:"submitter"`
+  Profile string `json:"profile"`
+  SelfSigned bool `json:"selfsigned,omitempty"`
+  Intermediate string `json:"intermediate,omitempty"`
+  CSR []byte `json:"csr"`
+  Approvals []Approval `json:"approvals"`
+  Issued *time.Time `json:"issued,omitempty"`
+  Certificate []byte `json:"certificate,omitempty"`
+}
+
+
+// An armored SSH signature of an operator over the message of a request.
+type Approval struct {
+  Principal string `json:"principal"`
+  Time time.Time `json:"time"`
+  Signature string `json:"signature"`
+}
+
+
+// Return the message that operators sign to approve the request. It binds
+// the approval to the request, the CA that issues it (by the key of its
+// signer), the profile, the exact CSR and the contents of the intermediate
+// configuration, so that approvals can not be moved to the queue of another
+// CA and the request can not be changed after it is approved.
+func (self *ApprovalRequest) Message(keyId string, intermediate []byte) []byte {
+  digest := sha256.Sum256(self.CSR)
+  msg := fmt.Sprintf("request: %s\nca: %s\nprofile: %s\nselfsigned: %t\ncsr-sha256: %x\n",
+    self.ID, keyId, self.Profile, self.SelfSigned, digest)
+  if self.Intermediate != "" {
+    msg += fmt.Sprintf("intermediate: %s\nintermediate-sha256: %x\n", self.Intermediate,
+      sha256.Sum256(intermediate))
+  }
+  return []byte(msg)
+}
+
+
+// Return the contents of the intermediate configuration of the request, or
+// nil if it has none.
+func (self *ApprovalRequest) ReadIntermediate() ([]byte, error) {
+  if self.Intermediate == "" {
+    return nil, nil
+  }
+  return ioutil.ReadFile(self.Intermediate)
+}
+
+
+// Return the distinct principals whose approvals are valid signatures of
+// message by a key that the allowed signers authorize. A key counts only
+// once, even if it is listed for several principals.
+func (self *ApprovalRequest) GetApprovers(signers sshca.AllowedSigners, message []byte, now time.Time) ([]string, []error) {
+  approvers := []string{}
+  failures := []error{}
+  principals := map[string]bool{}
+  keys := map[string]bool{}
+  for _, approval := range self.Approvals {
+    sig, err := sshca.ParseFileSignature([]byte(approval.Signature))
+    if err == nil {
+      err = sig.Verify(APPROVAL_NAMESPACE, message)
+    }
+    if err == nil {
+      _, err = signers.Authorize(sig, approval.Principal, now)
+    }
+    if err != nil {
+      failures = append(failures, errors.New(fmt.Sprintf("%s: %s", approval.Principal, err)))
+      continue
+    }
+    fingerprint := ssh.FingerprintSHA256(sig.PublicKey)
+    if principals[approval.Principal] || keys[fingerprint] {
+      continue
+    }
+    principals[approval.Principal] = true
+    keys[fingerprint] = true
+    approvers = append(approvers, approval.Principal)
+  }
+  sort.Strings(approvers)
+  return approvers, failures
+}
+
+
+// Take a CSR from stdin and add it to the queue of the CA, where it waits
+// for the approval of its operators.
+func SubmitRequest(buf []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var intConf string
+  var profileName string
+  var selfSigned bool
+
+  parser := flag.NewFlagSet("submit", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&intConf, "intermediate", "",
+    "specifies the intermediate (CA) configuration file.")
+  parser.BoolVar(&selfSigned, "selfsigned", false,
+    "indicates that the certificate will be self-signed.")
+  parser.StringVar(&profileName, "profile", "",
+    "specifies the profile of the CA that is used to issue the certificate.")
+  parser.Parse(args)
+
+  opts := loadApprovalConfiguration(caConf)
+  if len(buf) == 0 {
+    log.Fatal("Provide the CSR through stdin.")
+  }
+  block, _ := pem.Decode(buf)
+  if block == nil || block.Type != "CERTIFICATE REQUEST" {
+    log.Fatal("failed to decode PEM block containing the CSR")
+  }
+  csr, err := x509.ParseCertificateRequest(block.Bytes)
+  if err == nil {
+    err = csr.CheckSignature()
+  }
+  if err != nil { log.Fatal(err) }
+
+  // Reject requests that can not be issued before anyone approves them.
+  if profileName == "" {
+    profileName = opts.Defaults.Profile
+  }
+  profile, err := opts.GetProfile(profileName)
+  if err != nil { log.Fatal(err) }
+  if err := CheckPolicy(csr, &opts.Policy, &profile.Policy); err != nil {
+    log.Fatal(err)
+  }
+  if intConf != "" {
+    if intConf, err = filepath.Abs(intConf); err != nil { log.Fatal(err) }
+  }
+
+  id := make([]byte, 8)
+  if _, err := rand.Read(id); err != nil { log.Fatal(err) }
+  submitter := "unknown"
+  if u, err := user.Current(); err == nil {
+    submitter = u.Username
+  }
+  req := &ApprovalRequest{
+    ID: hex.EncodeToString(id),
+    Submitted: time.Now().UTC(),
+    Submitter: submitter,
+    Profile: profileName,
+    SelfSigned: selfSigned,
+    Intermediate: intConf,
+    CSR: csr.Raw,
+    Approvals: []Approval{},
+  }
+  if err := saveApprovalRequest(opts, req); err != nil { log.Fatal(err) }
+  log.Printf("Submitted request %s for %s; it requires the approval of %d operators.",
+    req.ID, csr.Subject, opts.Approval.Quorum)
+  fmt.Println(req.ID)
+}
+
+
+// Sign the request with the SSH key of an operator and record the
+// approval in the queue. Keys that are protected with a passphrase are
+// used through ssh-agent.
+func ApproveRequest(buf []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var keyFile string
+  var principal string
+
+  parser := flag.NewFlagSet("approve", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&keyFile, "key", "",
+    "specifies the SSH private key of the operator.")
+  parser.StringVar(&principal, "principal", "",
+    "specifies the principal of the operator in the allowed signers file.")
+  parser.Parse(args)
+
+  opts := loadApprovalConfiguration(caConf)
+  if keyFile == "" || principal == "" {
+    log.Fatal("The -key and -principal parameters are mandatory.")
+  }
+  if parser.NArg() != 1 {
+    log.Fatal("Specify the identifier of the request.")
+  }
+  req, err := loadApprovalRequest(opts, parser.Arg(0))
+  if err != nil { log.Fatal(err) }
+  if req.Issued != nil {
+    log.Fatalf("Request %s was issued at %s.", req.ID, req.Issued.Format(time.RFC3339))
+  }
+  for _, approval := range req.Approvals {
+    if approval.Principal == principal {
+      log.Fatalf("%s approved request %s at %s.", principal, req.ID, approval.Time.Format(time.RFC3339))
+    }
+  }
+  csr, err := x509.ParseCertificateRequest(req.CSR)
+  if err != nil { log.Fatal(err) }
+  log.Printf("Approving request %s of %s: %s (CA: %s, profile: %s, names: %s)", req.ID,
+    req.Submitter, csr.Subject, opts.Signer.KeyID, req.Profile, strings.Join(csr.DNSNames, ", "))
+  intermediate, err := req.ReadIntermediate()
+  if err != nil { log.Fatal(err) }
+  message := req.Message(opts.Signer.KeyID, intermediate)
+
+  signer, err := loadOperatorKey(keyFile)
+  if err != nil { log.Fatal(err) }
+  if signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+    signer = &rsaOperatorSigner{signer}
+  }
+  sig, err := sshca.SignMessage(signer, signer.PublicKey(), APPROVAL_NAMESPACE, "sha512", message)
+  if err != nil { log.Fatal(err) }
+  signers, err := sshca.LoadAllowedSigners(opts.Approval.AllowedSigners)
+  if err != nil { log.Fatal(err) }
+  if _, err := signers.Authorize(sig, principal, time.Now()); err != nil {
+    log.Fatal(err)
+  }
+
+  req.Approvals = append(req.Approvals, Approval{
+    Principal: principal,
+    Time: time.Now().UTC(),
+    Signature: string(sig.Marshal()),
+  })
+  if err := saveApprovalRequest(opts, req); err != nil { log.Fatal(err) }
+  approvers, _ := req.GetApprovers(signers, message, time.Now())
+  log.Printf("Request %s has %d of %d approvals.", req.ID, len(approvers), opts.Approval.Quorum)
+}
+
+
+// Issue the certificate of a request once a quorum of distinct operators
+// approved it, and keep the certificate with the request.
+func IssueRequest(buf []byte, args []string, backend backends.Backend) {
+  var caConf string
+
+  parser := flag.NewFlagSet("issue", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.Parse(args)
+
+  opts := loadApprovalConfiguration(caConf)
+  if parser.NArg() != 1 {
+    log.Fatal("Specify the identifier of the request.")
+  }
+  req, err := loadApprovalRequest(opts, parser.Arg(0))
+  if err != nil { log.Fatal(err) }
+  if req.Issued != nil {
+    log.Fatalf("Request %s was issued at %s.", req.ID, req.Issued.Format(time.RFC3339))
+  }
+
+  // The intermediate configuration is read once, so that it can not change
+  // between the check of the approvals and the issuance.
+  intermediate, err := req.ReadIntermediate()
+  if err != nil { log.Fatal(err) }
+  signers, err := sshca.LoadAllowedSigners(opts.Approval.AllowedSigners)
+  if err != nil { log.Fatal(err) }
+  approvers, failures := req.GetApprovers(signers, req.Message(opts.Signer.KeyID, intermediate),
+    time.Now())
+  for _, err := range failures {
+    log.Printf("Ignoring approval: %s", err)
+  }
+  if len(approvers) < opts.Approval.Quorum {
+    log.Fatalf("Request %s has %d of %d approvals.", req.ID, len(approvers), opts.Approval.Quorum)
+  }
+
+  csr, err := x509.ParseCertificateRequest(req.CSR)
+  if err != nil { log.Fatal(err) }
+  profile, err := opts.GetProfile(req.Profile)
+  if err != nil { log.Fatal(err) }
+  aia := opts
+  if req.Intermediate != "" {
+    aia = &dto.X509ConfigurationDTO{}
+    if err := aia.Load(req.Intermediate, intermediate); err != nil { log.Fatal(err) }
+  }
+  der, err := issueCertificate(backend, opts, csr, profile, req.SelfSigned, aia, nil)
+  if err != nil { log.Fatal(err) }
+
+  issued := time.Now().UTC()
+  req.Issued = &issued
+  req.Certificate = der
+  if err := saveApprovalRequest(opts, req); err != nil {
+    log.Printf("The certificate was issued, but the request could not be updated: %s", err)
+  }
+  log.Printf("Issued request %s with the approval of %s.", req.ID, strings.Join(approvers, ", "))
+  if err := pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+    log.Fatal(err)
+  }
+}
+
+
+func loadApprovalConfiguration(caConf string) *dto.X509ConfigurationDTO {
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  opts := &dto.X509ConfigurationDTO{}
+  if err := opts.Load(caConf, nil); err != nil { log.Fatal(err) }
+  if !opts.Approval.IsRequired() {
+    log.Fatal("The CA does not require approval; use x509 sign.")
+  }
+  if opts.Approval.Queue == "" || opts.Approval.AllowedSigners == "" {
+    log.Fatal("Configure approval.queue and approval.allowed-signers.")
+  }
+  return opts
+}
+
+
+func loadApprovalRequest(opts *dto.X509ConfigurationDTO, id string) (*ApprovalRequest, error) {
+  if !requestIdPattern.MatchString(id) {
+    return nil, errors.New(fmt.Sprintf("Invalid request identifier: %s", id))
+  }
+  buf, err := ioutil.ReadFile(filepath.Join(opts.Approval.Queue, id + ".json"))
+  if err != nil {
+    return nil, err
+  }
+  req := &ApprovalRequest{}
+  if err := json.Unmarshal(buf, req); err != nil {
+    return nil, err
+  }
+  if req.ID != id {
+    return nil, errors.New(fmt.Sprintf("The request in %s.json has identifier %s.", id, req.ID))
+  }
+  return req, nil
+}
+
+
+// Write the request to a temporary file and rename it, so that an
+// interrupted write does not lose the approvals.
+func saveApprovalRequest(opts *dto.X509ConfigurationDTO, req *ApprovalRequest) error {
+  buf, err := json.MarshalIndent(req, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(opts.Approval.Queue, 0700); err != nil {
+    return err
+  }
+  fp := filepath.Join(opts.Approval.Queue, req.ID + ".json")
+  if err := ioutil.WriteFile(fp + ".tmp", append(buf, '\n'), 0600); err != nil {
+    return err
+  }
+  return os.Rename(fp + ".tmp", fp)
+}
+
+
+// Load an unencrypted SSH private key, or find the key in ssh-agent if it
+// is protected with a passphrase.
+func loadOperatorKey(fp string) (ssh.Signer, error) {
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil {
+    return nil, err
+  }
+  signer, err := ssh.ParsePrivateKey(buf)
+  if err == nil {
+    return signer, nil
+  }
+  missing, ok := err.(*ssh.PassphraseMissingError)
+  if !ok {
+    return nil, err
+  }
+  if missing.PublicKey == nil {
+    return nil, errors.New(fmt.Sprintf("%s is protected with a passphrase.", fp))
+  }
+  socket := os.Getenv("SSH_AUTH_SOCK")
+  if socket == "" {
+    return nil, errors.New(fmt.Sprintf("%s is protected with a passphrase; add it to ssh-agent.", fp))
+  }
+  conn, err := net.Dial("unix", socket)
+  if err != nil {
+    return nil, err
+  }
+  signers, err := agent.NewClient(conn).Signers()
+  if err != nil {
+    return nil, err
+  }
+  for _, signer := range signers {
+    if bytes.Equal(signer.PublicKey().Marshal(), missing.PublicKey.Marshal()) {
+      return signer, nil
+    }
+  }
+  return nil, errors.New(fmt.Sprintf("The key of %s is not in ssh-agent.", fp))
+}
@@ -0,0 +1,91 @@
+package x509
+
+import (
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "log"
+  "os"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+// Issue an existing CA certificate under the CA specified with -ca, e.g. to
+// cross-sign a new root with an old one, or to create link certificates
+// between them.
+func CrossSignCertificate(buf []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var certFile string
+  var profileName string
+
+  parser := flag.NewFlagSet("cross-sign", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file of the issuer.")
+  parser.StringVar(&certFile, "cert", "",
+    "specifies the CA certificate (PEM) that is cross-signed.")
+  parser.StringVar(&profileName, "profile", "",
+    "specifies the profile of the issuer that determines the validity.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  if certFile == "" {
+    log.Fatal("The -cert parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+  profile, err := opts.GetProfile(profileName)
+  if err != nil { log.Fatal(err) }
+
+  certificates, err := ReadCertificates(certFile)
+  if err != nil { log.Fatal(err) }
+
+  der, err := IssueCrossCertificate(backend, &opts, certificates[0], profile)
+  if err != nil { log.Fatal(err) }
+  if err := pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+    log.Fatal(err)
+  }
+}
+
+
+// Issue a certificate with the subject, public key and extensions of an
+// existing CA certificate with the CA configured in opts. The constraints
+// of the issuer are applied, and its Authority Information Access and CRL
+// Distribution Points are added.
+func IssueCrossCertificate(backend backends.Backend, opts *dto.X509ConfigurationDTO, existing *x509.Certificate, profile *dto.X509Profile) ([]byte, error) {
+  if opts.Approval.IsRequired() {
+    return nil, errors.New(fmt.Sprintf("The CA requires the approval of %d operators; use x509 submit.",
+      opts.Approval.Quorum))
+  }
+  issuer, err := opts.GetSignerCertificate()
+  if err != nil {
+    return nil, err
+  }
+  builder := &CertificateBuilder{
+    backend: backend,
+    opts: *opts,
+    issuer: issuer,
+    profile: *profile,
+  }
+  crt, err := builder.FromCertificate(existing)
+  if err != nil {
+    return nil, err
+  }
+  builder.SetAuthorityInformation(crt, opts)
+  der, err := builder.Sign(crt, existing.PublicKey)
+  if err != nil {
+    return nil, err
+  }
+  if opts.Inventory != "" {
+    if err = recordIssued(opts.Inventory, der, profile.Name, nil); err != nil {
+      return nil, err
+    }
+  }
+  return der, nil
+}
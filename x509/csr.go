@@ -8,6 +8,7 @@ import (
   "log"
   "os"
 
+  "github.com/cochiseruhulessin/cloud-pki/audit"
   "github.com/cochiseruhulessin/cloud-pki/backends"
   "github.com/cochiseruhulessin/cloud-pki/x509/dto"
 )
@@ -30,14 +31,34 @@ func CreateCertificateSigningRequest(buf []byte, args []string, backend backends
   err = req.Load(csrConf, buf)
   if err != nil { log.Fatal(err) }
 
+  out, err := NewCertificateSigningRequest(backend, &req)
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: out})
+}
+
+
+// Create a DER-encoded CSR for the key and subject in req.
+func NewCertificateSigningRequest(backend backends.Backend, req *dto.X509ConfigurationDTO) ([]byte, error) {
   template := req.GetSigningRequestTemplate()
   req.Signer.AddExtensions(template)
 
   signer := backend.GetSigner(req.Signer.KeyID)
-	out, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
-	if err != nil {
-    log.Fatal(err)
-	}
-
-	pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: out})
+  der, err := x509.CreateCertificateRequest(rand.Reader, template, signer)
+  if err != nil || req.Audit == "" {
+    return der, err
+  }
+  csr, err := x509.ParseCertificateRequest(der)
+  if err != nil {
+    return nil, err
+  }
+  record := audit.NewRecord(audit.OPERATION_X509_REQUEST, req.Signer.KeyID,
+    csr.RawTBSCertificateRequest)
+  record.Subject = csr.Subject.String()
+  if err = audit.Open(req.Audit).Append(record); err != nil {
+    return nil, err
+  }
+  return der, nil
 }
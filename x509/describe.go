@@ -0,0 +1,235 @@
+package x509
+
+import (
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/rsa"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "strings"
+  "time"
+)
+
+
+// The names of the extensions that are described, by OID.
+var EXTENSION_NAMES = map[string]string{
+  "2.5.29.14": "Subject Key Identifier",
+  "2.5.29.15": "Key Usage",
+  "2.5.29.17": "Subject Alternative Name",
+  "2.5.29.19": "Basic Constraints",
+  "2.5.29.30": "Name Constraints",
+  "2.5.29.31": "CRL Distribution Points",
+  "2.5.29.32": "Certificate Policies",
+  "2.5.29.35": "Authority Key Identifier",
+  "2.5.29.37": "Extended Key Usage",
+  "1.3.6.1.5.5.7.1.1": "Authority Information Access",
+}
+
+
+var KEY_USAGE_NAMES = []string{
+  "digitalSignature",
+  "contentCommitment",
+  "keyEncipherment",
+  "dataEncipherment",
+  "keyAgreement",
+  "keyCertSign",
+  "cRLSign",
+  "encipherOnly",
+  "decipherOnly",
+}
+
+
+type CertificateDescription struct {
+  Version int `json:"version"`
+  Serial string `json:"serial"`
+  SignatureAlgorithm string `json:"signature_algorithm"`
+  Issuer string `json:"issuer"`
+  Subject string `json:"subject"`
+  NotBefore time.Time `json:"not_before"`
+  NotAfter time.Time `json:"not_after"`
+  PublicKey string `json:"public_key"`
+  Extensions []ExtensionDescription `json:"extensions"`
+}
+
+
+type ExtensionDescription struct {
+  OID string `json:"oid"`
+  Name string `json:"name"`
+  Critical bool `json:"critical"`
+  Values []string `json:"values"`
+}
+
+
+func DescribeCertificate(crt *x509.Certificate) *CertificateDescription {
+  desc := CertificateDescription{
+    Version: crt.Version,
+    Serial: fmt.Sprintf("%x", crt.SerialNumber),
+    SignatureAlgorithm: crt.SignatureAlgorithm.String(),
+    Issuer: crt.Issuer.String(),
+    Subject: crt.Subject.String(),
+    NotBefore: crt.NotBefore.UTC(),
+    NotAfter: crt.NotAfter.UTC(),
+    PublicKey: describePublicKey(crt),
+    Extensions: []ExtensionDescription{},
+  }
+  for _, ext := range crt.Extensions {
+    oid := ext.Id.String()
+    name, ok := EXTENSION_NAMES[oid]
+    if !ok {
+      name = "Unknown"
+    }
+    desc.Extensions = append(desc.Extensions, ExtensionDescription{
+      OID: oid,
+      Name: name,
+      Critical: ext.Critical,
+      Values: describeExtension(crt, oid, ext.Value),
+    })
+  }
+  return &desc
+}
+
+
+// Return the lines of the description, in the order in which they are
+// written.
+func (self *CertificateDescription) Lines() []string {
+  lines := []string{
+    fmt.Sprintf("Version: %d", self.Version),
+    fmt.Sprintf("Serial: %s", self.Serial),
+    fmt.Sprintf("Signature algorithm: %s", self.SignatureAlgorithm),
+    fmt.Sprintf("Issuer: %s", self.Issuer),
+    fmt.Sprintf("Subject: %s", self.Subject),
+    fmt.Sprintf("Not before: %s", self.NotBefore.Format(time.RFC3339)),
+    fmt.Sprintf("Not after: %s", self.NotAfter.Format(time.RFC3339)),
+    fmt.Sprintf("Public key: %s", self.PublicKey),
+  }
+  for _, ext := range self.Extensions {
+    critical := ""
+    if ext.Critical {
+      critical = " (critical)"
+    }
+    lines = append(lines, fmt.Sprintf("%s [%s]%s:", ext.Name, ext.OID, critical))
+    for _, v := range ext.Values {
+      lines = append(lines, "    " + v)
+    }
+  }
+  return lines
+}
+
+
+func (self *CertificateDescription) Write(w io.Writer) error {
+  _, err := io.WriteString(w, strings.Join(self.Lines(), "\n") + "\n")
+  return err
+}
+
+
+func describePublicKey(crt *x509.Certificate) string {
+  fingerprint := sha256.Sum256(crt.RawSubjectPublicKeyInfo)
+  var kind string
+  switch key := crt.PublicKey.(type) {
+    case *rsa.PublicKey:
+      kind = fmt.Sprintf("RSA %d", key.N.BitLen())
+    case *ecdsa.PublicKey:
+      kind = fmt.Sprintf("ECDSA %s", key.Curve.Params().Name)
+    case ed25519.PublicKey:
+      kind = "Ed25519"
+    default:
+      kind = crt.PublicKeyAlgorithm.String()
+  }
+  return fmt.Sprintf("%s SHA256:%s", kind, hex.EncodeToString(fingerprint[:]))
+}
+
+
+// Return the values of the extensions that are parsed by crypto/x509 from
+// the certificate, and the hexadecimal value of other extensions.
+func describeExtension(crt *x509.Certificate, oid string, value []byte) []string {
+  values := []string{}
+  switch oid {
+    case "2.5.29.14":
+      values = append(values, hex.EncodeToString(crt.SubjectKeyId))
+    case "2.5.29.35":
+      values = append(values, hex.EncodeToString(crt.AuthorityKeyId))
+    case "2.5.29.15":
+      for i, name := range KEY_USAGE_NAMES {
+        if crt.KeyUsage & (1 << uint(i)) != 0 {
+          values = append(values, name)
+        }
+      }
+    case "2.5.29.37":
+      for _, usage := range crt.ExtKeyUsage {
+        values = append(values, extKeyUsageName(usage))
+      }
+      for _, usage := range crt.UnknownExtKeyUsage {
+        values = append(values, usage.String())
+      }
+    case "2.5.29.19":
+      values = append(values, fmt.Sprintf("CA: %t", crt.IsCA))
+      if crt.IsCA && (crt.MaxPathLen > 0 || crt.MaxPathLenZero) {
+        values = append(values, fmt.Sprintf("Path length: %d", crt.MaxPathLen))
+      }
+    case "2.5.29.17":
+      values = append(values, getCertificateNames(crt)...)
+    case "2.5.29.30":
+      for _, c := range crt.PermittedDNSDomains {
+        values = append(values, "Permitted DNS: " + c)
+      }
+      for _, c := range crt.ExcludedDNSDomains {
+        values = append(values, "Excluded DNS: " + c)
+      }
+      for _, c := range crt.PermittedIPRanges {
+        values = append(values, "Permitted IP: " + c.String())
+      }
+      for _, c := range crt.ExcludedIPRanges {
+        values = append(values, "Excluded IP: " + c.String())
+      }
+      for _, c := range crt.PermittedEmailAddresses {
+        values = append(values, "Permitted email: " + c)
+      }
+      for _, c := range crt.ExcludedEmailAddresses {
+        values = append(values, "Excluded email: " + c)
+      }
+      for _, c := range crt.PermittedURIDomains {
+        values = append(values, "Permitted URI: " + c)
+      }
+      for _, c := range crt.ExcludedURIDomains {
+        values = append(values, "Excluded URI: " + c)
+      }
+    case "2.5.29.31":
+      values = append(values, crt.CRLDistributionPoints...)
+    case "2.5.29.32":
+      for _, policy := range crt.PolicyIdentifiers {
+        values = append(values, policy.String())
+      }
+    case "1.3.6.1.5.5.7.1.1":
+      for _, url := range crt.IssuingCertificateURL {
+        values = append(values, "CA issuers: " + url)
+      }
+      for _, url := range crt.OCSPServer {
+        values = append(values, "OCSP: " + url)
+      }
+    default:
+      values = append(values, hex.EncodeToString(value))
+  }
+  return values
+}
+
+
+func getCertificateNames(crt *x509.Certificate) []string {
+  names := []string{}
+  for _, name := range crt.DNSNames {
+    names = append(names, "DNS: " + name)
+  }
+  for _, name := range crt.EmailAddresses {
+    names = append(names, "Email: " + name)
+  }
+  for _, ip := range crt.IPAddresses {
+    names = append(names, "IP: " + ip.String())
+  }
+  for _, uri := range crt.URIs {
+    names = append(names, "URI: " + uri.String())
+  }
+  return names
+}
+
@@ -0,0 +1,18 @@
+package dto
+
+
+// Configures the ACME server of a CA.
+type AcmeConfiguration struct {
+  // The profile that is used to issue certificates.
+  Profile string `yaml:"profile"`
+
+  // The challenge types that are offered: http-01 and dns-01 (default).
+  Challenges []string `yaml:"challenges"`
+
+  // The address (host:port) of the DNS server that is used to validate
+  // dns-01 challenges. If omitted, the system resolver is used.
+  Resolver string `yaml:"resolver"`
+
+  // The time in which an order must be completed (default 24h).
+  OrderValidity string `yaml:"order-validity"`
+}
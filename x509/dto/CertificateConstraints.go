@@ -2,8 +2,9 @@ package dto
 
 import (
   "crypto/x509"
+  "errors"
+  "fmt"
   "io/ioutil"
-  "log"
   "time"
 
   "gopkg.in/yaml.v2"
@@ -13,6 +14,18 @@ import (
 type CertificateConstraints struct {
   End string `yaml:"expires"`
   Start string `yaml:"nbf"`
+  Validity string `yaml:"validity"`
+  // The maximum lifetime of a certificate, from NotBefore to NotAfter; the
+  // backdate counts towards it.
+  MaxValidity string `yaml:"max-validity"`
+
+  // Start the validity period this long before the time of issuance, to
+  // allow for clock skew.
+  Backdate string `yaml:"backdate"`
+
+  // What to do if the certificate would outlive its issuer: clamp
+  // (default) or refuse.
+  IssuerExpiry string `yaml:"issuer-expiry"`
   Usage []string `yaml:"usage"`
   ExtendedUsage []string `yaml:"extendedUsage"`
   CA CAConstraints `yaml:"ca"`
@@ -32,34 +45,87 @@ type CertificateNames struct {
 }
 
 
-func (self *CertificateConstraints) GetTimeBounds(crt *x509.Certificate, defaults *X509Defaults) {
-  expires := DEFAULT_EXPIRES
-  if defaults.Expires > 0 {
-    expires = defaults.Expires * 86400
-  }
-  utc, err := time.LoadLocation("Etc/UTC")
-  if err != nil {
-    log.Fatal(err)
-    return
+// Set the validity period of crt. NotBefore is the start of the current day
+// (UTC), the nbf timestamp, or the current time minus the backdate. NotAfter
+// is the expires timestamp, or the current time plus the validity (or the
+// default of the CA). The lifetime of crt may not exceed max-validity.
+func (self *CertificateConstraints) GetTimeBounds(crt *x509.Certificate, defaults *X509Defaults, now time.Time) error {
+  now = now.UTC()
+  crt.NotBefore = now.Truncate(24 * time.Hour)
+  if self.Backdate != "" {
+    backdate, err := ParseDuration(self.Backdate)
+    if err != nil {
+      return err
+    }
+    crt.NotBefore = now.Add(-backdate)
   }
-  crt.NotBefore = time.Now().In(utc).Truncate(24 * time.Hour)
   if self.Start != "" {
-    crt.NotBefore = getDate(self.Start)
+    t, err := getDate(self.Start)
+    if err != nil {
+      return err
+    }
+    crt.NotBefore = t
+  }
+
+  expires := time.Duration(DEFAULT_EXPIRES) * time.Second
+  if defaults.Expires > 0 {
+    expires = time.Duration(defaults.Expires) * 24 * time.Hour
+  }
+  crt.NotAfter = now.Add(expires)
+  if self.Validity != "" {
+    validity, err := ParseDuration(self.Validity)
+    if err != nil {
+      return err
+    }
+    crt.NotAfter = now.Add(validity)
   }
-  crt.NotAfter = time.Now().In(utc).
-    Add(time.Second * time.Duration(expires))
   if self.End != "" {
-    crt.NotAfter = getDate(self.End)
+    t, err := getDate(self.End)
+    if err != nil {
+      if validity, e := ParseDuration(self.End); e == nil {
+        t, err = now.Add(validity), nil
+      }
+    }
+    if err != nil {
+      return err
+    }
+    crt.NotAfter = t
+  }
+
+  if !crt.NotAfter.After(crt.NotBefore) {
+    return errors.New("The certificate expires before it becomes valid.")
   }
+  return self.CheckMaxValidity(crt)
+}
+
+
+// Return an error if the lifetime of crt, from NotBefore to NotAfter,
+// exceeds max-validity.
+func (self *CertificateConstraints) CheckMaxValidity(crt *x509.Certificate) error {
+  if self.MaxValidity == "" {
+    return nil
+  }
+  max, err := ParseDuration(self.MaxValidity)
+  if err != nil {
+    return err
+  }
+  if crt.NotAfter.Sub(crt.NotBefore) > max {
+    return errors.New(fmt.Sprintf(
+      "The certificate would be valid from %s until %s, which exceeds the maximum validity of %s.",
+      crt.NotBefore.Format(time.RFC3339), crt.NotAfter.Format(time.RFC3339), self.MaxValidity))
+  }
+  return nil
 }
 
 
-func getDate(date string) (time.Time) {
-  t, err := time.Parse("2006-01-02T15:04:05Z", date)
+// Parse an RFC 3339 timestamp, e.g. 2029-12-31T23:59:59Z or
+// 2029-12-31T23:59:59+01:00.
+func getDate(date string) (time.Time, error) {
+  t, err := time.Parse(time.RFC3339, date)
   if err != nil {
-    log.Fatal(err)
+    return t, errors.New(fmt.Sprintf("Invalid timestamp: %s", date))
   }
-  return t
+  return t.UTC(), nil
 }
 
 
@@ -0,0 +1,40 @@
+package dto
+
+import (
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+)
+
+
+// Parse a duration as accepted by time.ParseDuration, with the additional
+// units "d" (days) and "w" (weeks), e.g. "90d" or "1w12h".
+func ParseDuration(s string) (time.Duration, error) {
+  var total time.Duration
+  rest := strings.TrimSpace(s)
+  for _, unit := range []struct{ suffix string; d time.Duration }{
+    {"w", 7 * 24 * time.Hour},
+    {"d", 24 * time.Hour},
+  } {
+    i := strings.Index(rest, unit.suffix)
+    if i < 0 {
+      continue
+    }
+    n, err := strconv.Atoi(rest[:i])
+    if err != nil {
+      return 0, errors.New(fmt.Sprintf("Invalid duration: %s", s))
+    }
+    total += time.Duration(n) * unit.d
+    rest = rest[i+1:]
+  }
+  if rest != "" {
+    d, err := time.ParseDuration(rest)
+    if err != nil {
+      return 0, errors.New(fmt.Sprintf("Invalid duration: %s", s))
+    }
+    total += d
+  }
+  return total, nil
+}
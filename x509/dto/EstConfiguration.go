@@ -0,0 +1,17 @@
+package dto
+
+
+// Configures the EST (RFC 7030) server of a CA.
+type EstConfiguration struct {
+  // The profile that is used to issue certificates.
+  Profile string `yaml:"profile"`
+
+  // The clients that authenticate with HTTP basic authentication, by
+  // username, with the bcrypt hashes of their passwords.
+  Users map[string]string `yaml:"users"`
+
+  // A PEM file with the CAs that issue TLS client certificates for initial
+  // enrollment. The certificates issued by the CA itself are only accepted
+  // for re-enrollment.
+  ClientCAs string `yaml:"client-cas"`
+}
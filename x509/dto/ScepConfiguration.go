@@ -0,0 +1,36 @@
+package dto
+
+
+// Configures the SCEP (RFC 8894) server of a CA.
+type ScepConfiguration struct {
+  // The profile that is used to issue certificates.
+  Profile string `yaml:"profile"`
+
+  // The challenge password that clients include in their CSRs.
+  Challenge string `yaml:"challenge"`
+
+  // Advertise SHA-1 and DES3 in GetCACaps and accept requests that are
+  // signed with SHA-1 or encrypted with DES or DES3, for clients that
+  // support nothing else. Off by default.
+  AllowWeakAlgorithms bool `yaml:"allow-weak-algorithms"`
+
+  // The registration authority (RA) that decrypts the requests and signs
+  // the responses.
+  RA ScepRegistrationAuthority `yaml:"ra"`
+}
+
+
+type ScepRegistrationAuthority struct {
+  // Either "kms" (the default) or "file", in which case the key
+  // identifiers are paths of PEM-encoded private keys.
+  Backend string `yaml:"backend"`
+
+  // The decryption key and its certificate.
+  KeyID string `yaml:"keyid"`
+  Certificate string `yaml:"certificate"`
+
+  // The signing key and its certificate, if the decryption key can not
+  // sign; Cloud KMS keys are either signing or decryption keys.
+  SigningKeyID string `yaml:"signing-keyid"`
+  SigningCertificate string `yaml:"signing-certificate"`
+}
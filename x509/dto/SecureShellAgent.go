@@ -0,0 +1,56 @@
+package dto
+
+import (
+  "io/ioutil"
+
+  "gopkg.in/yaml.v2"
+)
+
+
+type SecureShellAgentConfiguration struct {
+  Socket string `yaml:"socket"`
+  Confirm bool `yaml:"confirm"`
+  Keys []SecureShellAgentKey `yaml:"keys"`
+}
+
+
+type SecureShellAgentKey struct {
+  KeyID string `yaml:"keyid"`
+  Certificate string `yaml:"certificate"`
+  Comment string `yaml:"comment"`
+
+  // The signature algorithm of the key; for RSA keys it must use the digest
+  // of the KMS key, rsa-sha2-256 (the default) or rsa-sha2-512.
+  Algorithm string `yaml:"algorithm"`
+  Confirm bool `yaml:"confirm"`
+}
+
+
+func (self *SecureShellAgentConfiguration) Load(fp string, buf []byte) error {
+  var err error
+  if len(buf) > 0 && buf != nil {
+    err = self.fromBuf(buf)
+  } else {
+    err = self.fromFile(fp)
+  }
+  return err
+}
+
+
+func (self *SecureShellAgentConfiguration) fromFile(fp string) error {
+  var err error
+  buf, err := ioutil.ReadFile(fp)
+  if err == nil {
+    err = self.fromBuf(buf)
+  }
+  return err
+}
+
+
+func (self *SecureShellAgentConfiguration) fromBuf(buf []byte) error {
+  err := yaml.Unmarshal([]byte(buf), &self)
+  if err != nil {
+    return err;
+  }
+  return nil
+}
@@ -0,0 +1,128 @@
+package dto
+
+import (
+  "errors"
+  "fmt"
+  "time"
+)
+
+
+var (
+  DEFAULT_SSH_VALIDITY = "24h"
+  DEFAULT_SSH_EXTENSIONS = map[string]string{
+    "permit-X11-forwarding": "",
+    "permit-agent-forwarding": "",
+    "permit-port-forwarding": "",
+    "permit-pty": "",
+    "permit-user-rc": "",
+  }
+)
+
+
+type SecureShellConfiguration struct {
+  // The signature algorithm used by the CA. If omitted, the algorithm is
+  // derived from the type of the key.
+  Algorithm string `yaml:"algorithm"`
+
+  // A file with the public key of the CA in authorized_keys format. It is
+  // used to prepare and preview certificates on hosts without access to
+  // the key of the CA; if omitted, the X.509 certificate of the CA is used.
+  PublicKey string `yaml:"public-key"`
+
+  // Either "random" (the default) or "sequential". Sequential serials are
+  // allocated from the counter in SerialFile.
+  Serial string `yaml:"serial"`
+  SerialFile string `yaml:"serial-file"`
+  DefaultProfile string `yaml:"profile"`
+  Profiles map[string]SecureShellProfile `yaml:"profiles"`
+  OIDC OpenIDConnectConfiguration `yaml:"oidc"`
+}
+
+
+// Authenticates requests to the signing service with ID tokens, and maps
+// their claims to principals.
+type OpenIDConnectConfiguration struct {
+  // The iss and aud claims that tokens must have; both are mandatory.
+  Issuer string `yaml:"issuer"`
+  Audience string `yaml:"audience"`
+
+  // A path or URL of the JSON Web Key Set of the issuer. If omitted, it is
+  // discovered from the configuration of the issuer.
+  JWKS string `yaml:"jwks"`
+  Rules []PrincipalRule `yaml:"rules"`
+}
+
+
+// Rules are evaluated in order; the first rule of which all claims match
+// determines the profile and principals of the certificate. The principals
+// are text/template templates that are executed with the claims.
+type PrincipalRule struct {
+  Claims map[string]string `yaml:"claims"`
+  Principals []string `yaml:"principals"`
+  Profile string `yaml:"profile"`
+}
+
+
+type SecureShellProfile struct {
+  // Either "user" (the default) or "host".
+  Type string `yaml:"type"`
+  Principals []string `yaml:"principals"`
+  AllowedPrincipals []string `yaml:"allowed-principals"`
+  Validity string `yaml:"validity"`
+  MaxValidity string `yaml:"max-validity"`
+  Backdate string `yaml:"backdate"`
+  CriticalOptions map[string]string `yaml:"critical-options"`
+  Extensions map[string]string `yaml:"extensions"`
+}
+
+
+// Return the profile with the given name. If no profiles are configured, a
+// profile for user certificates with the default extensions is returned.
+func (self *SecureShellConfiguration) GetProfile(name string) (*SecureShellProfile, error) {
+  if len(self.Profiles) == 0 {
+    if name != "" {
+      return nil, errors.New(fmt.Sprintf("Unknown SSH profile: %s", name))
+    }
+    return &SecureShellProfile{Extensions: DEFAULT_SSH_EXTENSIONS}, nil
+  }
+  if name == "" {
+    name = self.DefaultProfile
+  }
+  if name == "" {
+    return nil, errors.New("Specify a profile; the CA has no default SSH profile.")
+  }
+  profile, ok := self.Profiles[name]
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("Unknown SSH profile: %s", name))
+  }
+  return &profile, nil
+}
+
+
+// Return the validity of issued certificates, the maximum validity that
+// may be requested and the period by which the start of the validity is
+// moved back to account for clock skew.
+func (self *SecureShellProfile) GetValidity() (validity time.Duration, max time.Duration, backdate time.Duration, err error) {
+  s := self.Validity
+  if s == "" {
+    s = DEFAULT_SSH_VALIDITY
+  }
+  if validity, err = ParseDuration(s); err != nil {
+    return
+  }
+  max = validity
+  if self.MaxValidity != "" {
+    if max, err = ParseDuration(self.MaxValidity); err != nil {
+      return
+    }
+  }
+  if validity > max {
+    err = errors.New(fmt.Sprintf("Profile validity %s exceeds its maximum %s.",
+      validity, max))
+    return
+  }
+  if self.Backdate != "" {
+    backdate, err = ParseDuration(self.Backdate)
+  }
+  return
+}
@@ -0,0 +1,96 @@
+package dto
+
+import (
+  "io/ioutil"
+
+  "gopkg.in/yaml.v2"
+)
+
+
+// Configures the signing API (cloud-pki serve).
+type SigningServiceConfiguration struct {
+  Listen string `yaml:"listen"`
+  TLS SigningServiceTLS `yaml:"tls"`
+  JWT SigningServiceTokens `yaml:"jwt"`
+
+  // The CAs that are served, by name, with the paths of their
+  // configuration files.
+  CAs map[string]string `yaml:"cas"`
+  Rules []SigningServiceRule `yaml:"rules"`
+}
+
+
+type SigningServiceTLS struct {
+  Certificate string `yaml:"certificate"`
+  Key string `yaml:"key"`
+
+  // A PEM file with the CAs that issue client certificates. If omitted,
+  // clients can not authenticate with certificates.
+  ClientCAs string `yaml:"client-cas"`
+}
+
+
+// Bearer tokens are JWTs that are verified with a local key set. The issuer
+// and audience are mandatory if a key set is configured.
+type SigningServiceTokens struct {
+  Issuer string `yaml:"issuer"`
+  Audience string `yaml:"audience"`
+  JWKS string `yaml:"jwks"`
+}
+
+
+// Rules are evaluated in order; a request is allowed by the first rule
+// that matches the caller, the operation, the CA and the profile, and that
+// allows all requested names. Patterns may contain * wildcards.
+type SigningServiceRule struct {
+  // The identities of the callers: the common name and Subject Alternative
+  // Names of their client certificate, or the sub claim of their token.
+  Identities []string `yaml:"identities"`
+
+  // Claims of the token that must match, e.g. {groups: "ci"}.
+  Claims map[string]string `yaml:"claims"`
+
+  // The operations: x509, ssh and revoke. If omitted, all operations are
+  // allowed.
+  Operations []string `yaml:"operations"`
+
+  CAs []string `yaml:"cas"`
+
+  // The profiles that may be requested. If omitted, any profile is allowed.
+  Profiles []string `yaml:"profiles"`
+
+  // The names that may be requested: the common name and Subject
+  // Alternative Names of CSRs, and the principals of SSH certificates. If
+  // omitted, any name is allowed.
+  Names []string `yaml:"names"`
+}
+
+
+func (self *SigningServiceConfiguration) Load(fp string, buf []byte) error {
+  var err error
+  if len(buf) > 0 && buf != nil {
+    err = self.fromBuf(buf)
+  } else {
+    err = self.fromFile(fp)
+  }
+  return err
+}
+
+
+func (self *SigningServiceConfiguration) fromFile(fp string) error {
+  var err error
+  buf, err := ioutil.ReadFile(fp)
+  if err == nil {
+    err = self.fromBuf(buf)
+  }
+  return err
+}
+
+
+func (self *SigningServiceConfiguration) fromBuf(buf []byte) error {
+  err := yaml.Unmarshal([]byte(buf), &self)
+  if err != nil {
+    return err;
+  }
+  return nil
+}
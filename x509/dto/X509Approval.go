@@ -0,0 +1,19 @@
+package dto
+
+
+// Requires a quorum of operators to approve a CSR before it is signed with
+// the key of the CA. Operators are identified by the SSH keys in an
+// allowed_signers file (see ssh-keygen(1)); pending requests and their
+// approvals are kept in the queue directory.
+type X509Approval struct {
+  Quorum int `yaml:"quorum"`
+  AllowedSigners string `yaml:"allowed-signers"`
+  Queue string `yaml:"queue"`
+}
+
+
+// Return true if certificates of the CA can only be issued from an approved
+// request.
+func (self *X509Approval) IsRequired() bool {
+  return self.Quorum > 0
+}
@@ -17,9 +17,25 @@ type X509ConfigurationDTO struct {
   Signer Signer `yaml:"signer"`
   Subject X509Subject
   Constraints CertificateConstraints
+  Profiles map[string]X509Profile `yaml:"profiles"`
+  Policy X509Policy `yaml:"policy"`
+  Lint X509LintConfiguration `yaml:"lint"`
   Names CertificateNames `yaml:"names"`
   AuthorityInfoAccess X509AuthorityInformationAccess `yaml:"aia"`
   CRLDistribution X509CRLDistributionPoints `yaml:"crl"`
+  SecureShell SecureShellConfiguration `yaml:"ssh"`
+  Acme AcmeConfiguration `yaml:"acme"`
+  Est EstConfiguration `yaml:"est"`
+  Scep ScepConfiguration `yaml:"scep"`
+  Approval X509Approval `yaml:"approval"`
+
+  // The path of the inventory of issued and revoked certificates. If
+  // omitted, issued certificates are not recorded.
+  Inventory string `yaml:"inventory"`
+
+  // The path of the audit log of the signatures made with the key of the
+  // CA. If omitted, signatures are not audited.
+  Audit string `yaml:"audit"`
 }
 
 
@@ -3,4 +3,5 @@ package dto
 
 type X509Defaults struct {
   Expires int `yaml:"expires"`
+  Profile string `yaml:"profile"`
 }
@@ -0,0 +1,54 @@
+package dto
+
+import (
+  "io/ioutil"
+
+  "gopkg.in/yaml.v2"
+)
+
+
+// Describes a tree of certification authorities. Each node is a CA
+// configuration; signer.certificate is the path where the certificate of
+// the node is written. Unlike in a CA configuration file, the constraints
+// of a node apply to the certificate of the node itself.
+type X509HierarchyDTO struct {
+  Root X509HierarchyNode `yaml:"root"`
+}
+
+
+type X509HierarchyNode struct {
+  Name string `yaml:"name"`
+  X509ConfigurationDTO `yaml:",inline"`
+  CSR string `yaml:"csr"`
+  Children []X509HierarchyNode `yaml:"children"`
+}
+
+
+func (self *X509HierarchyDTO) Load(fp string, buf []byte) error {
+  var err error
+  if len(buf) > 0 && buf != nil {
+    err = self.fromBuf(buf)
+  } else {
+    err = self.fromFile(fp)
+  }
+  return err
+}
+
+
+func (self *X509HierarchyDTO) fromFile(fp string) error {
+  var err error
+  buf, err := ioutil.ReadFile(fp)
+  if err == nil {
+    err = self.fromBuf(buf)
+  }
+  return err
+}
+
+
+func (self *X509HierarchyDTO) fromBuf(buf []byte) error {
+  err := yaml.Unmarshal([]byte(buf), &self)
+  if err != nil {
+    return err;
+  }
+  return nil
+}
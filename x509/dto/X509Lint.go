@@ -0,0 +1,22 @@
+package dto
+
+
+// Overrides the severity of the lint rules that are applied before a
+// certificate is signed. The keys are rule names or sources (rfc5280,
+// cabf-br, internal) and the values are error, warning or ignore.
+type X509LintConfiguration struct {
+  Severity map[string]string `yaml:"severity"`
+}
+
+
+// Return the severities of the CA, overridden by those of the profile.
+func (self *X509LintConfiguration) GetSeverities(profile *X509LintConfiguration) map[string]string {
+  severities := map[string]string{}
+  for k, v := range self.Severity {
+    severities[k] = v
+  }
+  for k, v := range profile.Severity {
+    severities[k] = v
+  }
+  return severities
+}
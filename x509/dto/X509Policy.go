@@ -0,0 +1,47 @@
+package dto
+
+
+// Restricts the names that a CSR may request. Policies are specified per CA
+// and per profile; a CSR must satisfy both.
+type X509Policy struct {
+  // Patterns are exact names, "*.example.com" for names one label below
+  // example.com, or ".example.com" for any name below example.com.
+  DNS X509DNSPolicy `yaml:"dns"`
+
+  // Patterns are addresses, domains ("example.com") or domain suffixes
+  // (".example.com").
+  Email X509NamePolicy `yaml:"email"`
+
+  // Patterns are CIDRs or addresses.
+  IP X509NamePolicy `yaml:"ip"`
+
+  // Patterns are schemes ("spiffe") or prefixes ("spiffe://example.org/").
+  URI X509NamePolicy `yaml:"uri"`
+
+  Subject X509SubjectPolicy `yaml:"subject"`
+
+  // The maximum number of Subject Alternative Names; zero is unlimited.
+  MaxNames int `yaml:"max-sans"`
+}
+
+
+// A name is allowed if it does not match any of the denied patterns and,
+// if allowed patterns are specified, matches at least one of them.
+type X509NamePolicy struct {
+  Allow []string `yaml:"allow"`
+  Deny []string `yaml:"deny"`
+}
+
+
+type X509DNSPolicy struct {
+  X509NamePolicy `yaml:",inline"`
+  ForbidWildcards bool `yaml:"forbid-wildcards"`
+}
+
+
+// Attributes are specified by their short name (CN, O, OU, C, ST, L,
+// emailAddress, ...) or their dotted object identifier.
+type X509SubjectPolicy struct {
+  Require []string `yaml:"require"`
+  Forbid []string `yaml:"forbid"`
+}
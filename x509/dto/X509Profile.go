@@ -0,0 +1,112 @@
+package dto
+
+import (
+  "encoding/asn1"
+  "encoding/base64"
+  "errors"
+  "fmt"
+
+  "gopkg.in/yaml.v2"
+
+  "github.com/cochiseruhulessin/cloud-pki/x509/oid"
+)
+
+
+// A named set of constraints that a CA applies to the certificates it
+// issues, e.g. for servers or clients.
+type X509Profile struct {
+  // The name of the profile in the configuration of the CA; it is set by
+  // GetProfile.
+  Name string `yaml:"-"`
+
+  CertificateConstraints `yaml:",inline"`
+  SubjectAltNames X509SubjectAltNameRules `yaml:"san"`
+  Extensions []X509Extension `yaml:"extensions"`
+  Policy X509Policy `yaml:"policy"`
+  Subject *X509SubjectTemplate `yaml:"subject"`
+  Lint X509LintConfiguration `yaml:"lint"`
+}
+
+
+// Specifies which Subject Alternative Names are copied from the CSR into the
+// certificate.
+type X509SubjectAltNameRules struct {
+  // The types of names that are allowed: dns, email, ip and uri. If
+  // omitted, all types are allowed.
+  Allow []string `yaml:"allow"`
+
+  // Refuse CSRs that do not contain a Subject Alternative Name.
+  Required bool `yaml:"required"`
+}
+
+
+// Specifies the subject of the issued certificate. The attributes are Go
+// templates that are rendered with the CSR, e.g. "{{ .Subject.CommonName }}"
+// or "{{ index .DNSNames 0 }}"; attributes that render to an empty string
+// are omitted.
+type X509SubjectTemplate struct {
+  // Discard the subject of the CSR; the subject consists of the attributes
+  // below only. Otherwise, the attributes replace the attributes of the same
+  // type in the CSR, or are appended if the CSR does not have them.
+  Replace bool `yaml:"replace"`
+
+  // The attribute types (CN, O, OU, C, ...) and their values, in order.
+  Attributes yaml.MapSlice `yaml:"attributes"`
+}
+
+
+// An additional extension with a DER-encoded, base64 value.
+type X509Extension struct {
+  OID string `yaml:"oid"`
+  Critical bool `yaml:"critical"`
+  Value string `yaml:"value"`
+}
+
+
+// Return the profile with the given name, or the default profile if name
+// is empty. A CA without profiles has a single profile, which is specified
+// by its constraints.
+func (self *X509ConfigurationDTO) GetProfile(name string) (*X509Profile, error) {
+  if len(self.Profiles) == 0 {
+    if name != "" {
+      return nil, errors.New(fmt.Sprintf("Unknown profile: %s", name))
+    }
+    return &X509Profile{CertificateConstraints: self.Constraints}, nil
+  }
+  if name == "" {
+    name = self.Defaults.Profile
+  }
+  if name == "" {
+    return nil, errors.New("Specify a profile; the CA has no default profile.")
+  }
+  profile, ok := self.Profiles[name]
+  if !ok {
+    return nil, errors.New(fmt.Sprintf("Unknown profile: %s", name))
+  }
+  profile.Name = name
+  return &profile, nil
+}
+
+
+func (self *X509SubjectAltNameRules) Allows(kind string) bool {
+  if len(self.Allow) == 0 {
+    return true
+  }
+  for _, k := range self.Allow {
+    if k == kind {
+      return true
+    }
+  }
+  return false
+}
+
+
+func (self *X509Extension) GetObjectIdentifier() (asn1.ObjectIdentifier, error) {
+  return oid.Parse(self.OID)
+}
+
+
+func (self *X509Extension) GetValue() ([]byte, error) {
+  return base64.StdEncoding.DecodeString(self.Value)
+}
+
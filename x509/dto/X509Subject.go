@@ -3,44 +3,304 @@ package dto
 import (
   "crypto/x509/pkix"
   "encoding/asn1"
+  "errors"
+  "fmt"
   "log"
+  "sort"
+
+  "gopkg.in/yaml.v2"
 
   "github.com/cochiseruhulessin/cloud-pki/x509/oid"
 )
 
 
+// The order of the attributes in a subject that is specified as a mapping,
+// which is the order used by pkix.Name. Other attributes follow in the order
+// of the configuration.
+var LEGACY_ATTRIBUTE_ORDER = []string{"C", "ST", "L", "O", "OU", "CN"}
+
+
+// A distinguished name. It is specified either as a mapping of attribute
+// types to values:
+//
+//   subject:
+//     O: Example Inc.
+//     CN: www.example.com
+//
+// or as a list of relative distinguished names (RDNs), which are encoded in
+// the order of the configuration:
+//
+//   subject:
+//   - DC: com
+//   - DC: example
+//   - OU: Engineering
+//   - OU: Platform
+//   - {CN: Jane Doe, UID: jdoe}          # multi-valued RDN
+//   - {type: O, value: Example, encoding: utf8}
 type X509Subject struct {
-    C               string `yaml:"C"`
-    ST              string `yaml:"ST"`
-    L               string `yaml:"L"`
-    O               string `yaml:"O"`
-    OU              string `yaml:"OU"`
-    CN              string `yaml:"CN"`
-    Email    string `yaml:"emailAddress"`
+  RDNs []X509RelativeDistinguishedName
 }
 
 
-func (self *X509Subject) GetSubject() pkix.RDNSequence {
-  name := pkix.Name{}
+type X509RelativeDistinguishedName []X509Attribute
+
+
+// An attribute of a distinguished name. Type is a short name (CN, O, DC,
+// UID, serialNumber, ...) or a dotted object identifier. Encoding is one of
+// utf8, printable or ia5; if omitted, values are encoded as PrintableString
+// if possible and as UTF8String otherwise.
+type X509Attribute struct {
+  Type string `yaml:"type"`
+  Value string `yaml:"value"`
+  Encoding string `yaml:"encoding"`
+}
+
 
-  if self.CN == "" {
-    log.Fatal("Specify at least a common name (CN).")
+func (self *X509Subject) UnmarshalYAML(unmarshal func(interface{}) error) error {
+  if isMapping(unmarshal) {
+    mapping := yaml.MapSlice{}
+    if err := unmarshal(&mapping); err != nil {
+      return err
+    }
+    return self.fromMapping(mapping)
   }
-  name.CommonName = self.CN
-  if self.C != "" { name.Country = []string{self.C} }
-  if self.ST != "" { name.Province = []string{self.ST} }
-  if self.L != "" { name.Locality = []string{self.L} }
-  if self.O != "" { name.Organization = []string{self.O} }
-  if self.OU != "" { name.OrganizationalUnit = []string{self.OU} }
+  rdns := []X509RelativeDistinguishedName{}
+  if err := unmarshal(&rdns); err != nil {
+    return err
+  }
+  self.RDNs = rdns
+  return nil
+}
+
+
+// Every value in a mapping is a separate RDN; a list of values results in
+// an RDN for each value.
+func (self *X509Subject) fromMapping(mapping yaml.MapSlice) error {
+  rank := func(name string) int {
+    for i, n := range LEGACY_ATTRIBUTE_ORDER {
+      if n == name {
+        return i
+      }
+    }
+    return len(LEGACY_ATTRIBUTE_ORDER)
+  }
+  sort.SliceStable(mapping, func(i, j int) bool {
+    return rank(fmt.Sprint(mapping[i].Key)) < rank(fmt.Sprint(mapping[j].Key))
+  })
+
+  self.RDNs = []X509RelativeDistinguishedName{}
+  for _, item := range mapping {
+    values := []interface{}{item.Value}
+    if v, ok := item.Value.([]interface{}); ok {
+      values = v
+    }
+    for _, value := range values {
+      s, err := scalar(fmt.Sprint(item.Key), value)
+      if err != nil {
+        return err
+      }
+      if s == "" {
+        continue
+      }
+      attr := X509Attribute{Type: fmt.Sprint(item.Key), Value: s}
+      if err := attr.validate(); err != nil {
+        return err
+      }
+      self.RDNs = append(self.RDNs, X509RelativeDistinguishedName{attr})
+    }
+  }
+  return nil
+}
 
 
-  subject := name.ToRDNSequence()
-  if self.Email != "" {
-    subject = append(subject, []pkix.AttributeTypeAndValue{
-      {Type: oid.OID_EMAIL, Value: self.Email},
-    })
+// An RDN is a mapping of attribute types to values, a single attribute in
+// its long form, or a list of attributes.
+func (self *X509RelativeDistinguishedName) UnmarshalYAML(unmarshal func(interface{}) error) error {
+  if isMapping(unmarshal) {
+    mapping := yaml.MapSlice{}
+    if err := unmarshal(&mapping); err != nil {
+      return err
+    }
+    attrs, err := attributesFromMapping(mapping)
+    if err != nil {
+      return err
+    }
+    *self = attrs
+    return nil
   }
+  attrs := []X509Attribute{}
+  if err := unmarshal(&attrs); err != nil {
+    return err
+  }
+  if len(attrs) == 0 {
+    return errors.New("A relative distinguished name must have an attribute.")
+  }
+  *self = attrs
+  return nil
+}
+
+
+func (self *X509Attribute) UnmarshalYAML(unmarshal func(interface{}) error) error {
+  if !isMapping(unmarshal) {
+    return errors.New("An attribute must be a mapping.")
+  }
+  mapping := yaml.MapSlice{}
+  if err := unmarshal(&mapping); err != nil {
+    return err
+  }
+  attrs, err := attributesFromMapping(mapping)
+  if err != nil {
+    return err
+  }
+  if len(attrs) != 1 {
+    return errors.New("Specify a single attribute in each list item.")
+  }
+  *self = attrs[0]
+  return nil
+}
+
+
+func isMapping(unmarshal func(interface{}) error) bool {
+  var v interface{}
+  if err := unmarshal(&v); err != nil {
+    return false
+  }
+  _, ok := v.(map[interface{}]interface{})
+  return ok
+}
 
+
+func attributesFromMapping(mapping yaml.MapSlice) ([]X509Attribute, error) {
+  attrs := []X509Attribute{}
+  long := X509Attribute{}
+  isLong := false
+  for _, item := range mapping {
+    key := fmt.Sprint(item.Key)
+    value, err := scalar(key, item.Value)
+    if err != nil {
+      return nil, err
+    }
+    switch key {
+      case "type":
+        long.Type, isLong = value, true
+      case "value":
+        long.Value = value
+      case "encoding":
+        long.Encoding = value
+      default:
+        attrs = append(attrs, X509Attribute{Type: key, Value: value})
+    }
+  }
+  if isLong {
+    if len(attrs) > 0 {
+      return nil, errors.New("Do not mix attribute types with the type, value and encoding keys.")
+    }
+    attrs = append(attrs, long)
+  }
+  for i := range attrs {
+    if err := attrs[i].validate(); err != nil {
+      return nil, err
+    }
+  }
+  return attrs, nil
+}
+
+
+func scalar(key string, v interface{}) (string, error) {
+  switch v.(type) {
+    case nil:
+      return "", nil
+    case []interface{}, map[interface{}]interface{}:
+      return "", errors.New(fmt.Sprintf("The value of %s must be a scalar.", key))
+    default:
+      return fmt.Sprint(v), nil
+  }
+}
+
+
+func (self *X509Attribute) validate() error {
+  if _, err := oid.LookupAttribute(self.Type); err != nil {
+    return err
+  }
+  if self.Value == "" {
+    return errors.New(fmt.Sprintf("Specify a value for %s.", self.Type))
+  }
+  _, err := self.GetValue()
+  return err
+}
+
+
+// Return the value with the ASN.1 string type specified by the encoding.
+func (self *X509Attribute) GetValue() (interface{}, error) {
+  var tag int
+  switch self.Encoding {
+    case "":
+      return self.Value, nil
+    case "utf8":
+      tag = asn1.TagUTF8String
+    case "printable":
+      tag = asn1.TagPrintableString
+      for _, c := range self.Value {
+        if !isPrintable(c) {
+          return nil, errors.New(fmt.Sprintf(
+            "%s: %q can not be encoded as PrintableString.", self.Type, self.Value))
+        }
+      }
+    case "ia5":
+      tag = asn1.TagIA5String
+      for _, c := range self.Value {
+        if c > 127 {
+          return nil, errors.New(fmt.Sprintf(
+            "%s: %q can not be encoded as IA5String.", self.Type, self.Value))
+        }
+      }
+    default:
+      return nil, errors.New(fmt.Sprintf("Invalid encoding: %s", self.Encoding))
+  }
+  return asn1.RawValue{Class: asn1.ClassUniversal, Tag: tag, Bytes: []byte(self.Value)}, nil
+}
+
+
+func isPrintable(c rune) bool {
+  return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+    c == ' ' || c == '\'' || c == '(' || c == ')' || c == '+' || c == ',' ||
+    c == '-' || c == '.' || c == '/' || c == ':' || c == '=' || c == '?'
+}
+
+
+// Return the value of the first common name, if any.
+func (self *X509Subject) GetCommonName() string {
+  for _, rdn := range self.RDNs {
+    for _, attr := range rdn {
+      if attr.Type == "CN" {
+        return attr.Value
+      }
+    }
+  }
+  return ""
+}
+
+
+func (self *X509Subject) GetSubject() pkix.RDNSequence {
+  if len(self.RDNs) == 0 {
+    log.Fatal("Specify at least one attribute in the subject.")
+  }
+  subject := pkix.RDNSequence{}
+  for _, rdn := range self.RDNs {
+    set := []pkix.AttributeTypeAndValue{}
+    for _, attr := range rdn {
+      t, err := oid.LookupAttribute(attr.Type)
+      if err != nil {
+        log.Fatal(err)
+      }
+      value, err := attr.GetValue()
+      if err != nil {
+        log.Fatal(err)
+      }
+      set = append(set, pkix.AttributeTypeAndValue{Type: t, Value: value})
+    }
+    subject = append(subject, set)
+  }
   return subject
 }
 
@@ -0,0 +1,272 @@
+package x509
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+  "strings"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+)
+
+
+func HandleHierarchy(buf []byte, args []string, backend backends.Backend) {
+  if len(args) < 1 {
+    log.Fatal("Specify an operation: apply")
+  }
+  switch op := args[0]; op {
+    case "apply":
+      ApplyHierarchy(buf, args[1:], backend)
+    default:
+      log.Fatal("Unknown operation: ", op)
+  }
+}
+
+
+// Create the CSRs and certificates of a CA hierarchy, starting at the root.
+// Certificates that exist and match their specification are left as they
+// are.
+func ApplyHierarchy(buf []byte, args []string, backend backends.Backend) {
+  var force bool
+
+  parser := flag.NewFlagSet("apply", flag.ExitOnError)
+  parser.BoolVar(&force, "force", false,
+    "reissue certificates that do not match their specification.")
+  parser.Parse(args)
+
+  if parser.NArg() < 1 {
+    log.Fatal("Specify the hierarchy file.")
+  }
+  hierarchy := dto.X509HierarchyDTO{}
+  err := hierarchy.Load(parser.Arg(0), nil)
+  if err != nil { log.Fatal(err) }
+
+  applier := hierarchyApplier{backend: backend, force: force}
+  if err = applier.apply(&hierarchy.Root, nil); err != nil {
+    log.Fatal(err)
+  }
+}
+
+
+type hierarchyApplier struct {
+  backend backends.Backend
+  force bool
+}
+
+
+func (self *hierarchyApplier) apply(node *dto.X509HierarchyNode, parent *dto.X509HierarchyNode) error {
+  name := node.Name
+  if name == "" {
+    name = node.Subject.GetCommonName()
+  }
+  if node.Signer.KeyID == "" || node.Signer.Certificate == "" {
+    return errors.New(fmt.Sprintf("%s: signer.keyid and signer.certificate are mandatory.", name))
+  }
+
+  var issuer *x509.Certificate
+  var err error
+  if parent != nil {
+    issuer, err = parent.GetSignerCertificate()
+    if err != nil {
+      return errors.New(fmt.Sprintf("%s: %s", name, err))
+    }
+  }
+
+  pub := self.backend.GetSigner(node.Signer.KeyID).Public()
+  problems, err := self.check(node, parent, issuer, pub)
+  if err != nil {
+    return errors.New(fmt.Sprintf("%s: %s", name, err))
+  }
+  switch {
+    case problems == nil:
+      log.Printf("%s: created %s", name, node.Signer.Certificate)
+    case len(problems) == 0:
+      log.Printf("%s: %s is up to date", name, node.Signer.Certificate)
+    case !self.force:
+      return errors.New(fmt.Sprintf(
+        "%s: %s does not match its specification (%s); use -force to reissue.",
+        name, node.Signer.Certificate, strings.Join(problems, "; ")))
+    default:
+      log.Printf("%s: reissued %s (%s)", name, node.Signer.Certificate,
+        strings.Join(problems, "; "))
+  }
+
+  for i := range node.Children {
+    if err := self.apply(&node.Children[i], node); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+// Verify the certificate of node and (re)issue it if it does not exist or,
+// with -force, does not match. The returned list is nil if the certificate
+// was created, and otherwise contains the differences with the
+// specification.
+func (self *hierarchyApplier) check(node *dto.X509HierarchyNode, parent *dto.X509HierarchyNode, issuer *x509.Certificate, pub crypto.PublicKey) ([]string, error) {
+  crt, err := node.GetSignerCertificate()
+  if err != nil && !os.IsNotExist(err) {
+    return nil, err
+  }
+  if crt != nil {
+    problems, err := self.compare(node, parent, issuer, crt, pub)
+    if err != nil || len(problems) == 0 || !self.force {
+      return problems, err
+    }
+    if err = self.issue(node, parent, pub); err != nil {
+      return nil, err
+    }
+    return problems, nil
+  }
+  return nil, self.issue(node, parent, pub)
+}
+
+
+func (self *hierarchyApplier) issue(node *dto.X509HierarchyNode, parent *dto.X509HierarchyNode, pub crypto.PublicKey) error {
+  csr, err := self.getCertificateSigningRequest(node, pub)
+  if err != nil {
+    return err
+  }
+
+  // Every node is a CA, so its certificate has the AIA and CRL Distribution
+  // Points of its own configuration, as with the -intermediate parameter of
+  // the sign command.
+  issuer := &node.X509ConfigurationDTO
+  if parent != nil {
+    issuer = &parent.X509ConfigurationDTO
+  }
+  profile := dto.X509Profile{CertificateConstraints: node.Constraints}
+  der, err := IssueCertificate(self.backend, issuer, csr, &profile, parent == nil,
+    &node.X509ConfigurationDTO)
+  if err != nil {
+    return err
+  }
+  return writePEM(node.Signer.Certificate, "CERTIFICATE", der)
+}
+
+
+// Return the CSR of node, creating it if it does not exist or if it does not
+// match the subject or key of the node.
+func (self *hierarchyApplier) getCertificateSigningRequest(node *dto.X509HierarchyNode, pub crypto.PublicKey) (*x509.CertificateRequest, error) {
+  if node.CSR != "" {
+    buf, err := ioutil.ReadFile(node.CSR)
+    if err == nil {
+      block, _ := pem.Decode(buf)
+      if block != nil && block.Type == "CERTIFICATE REQUEST" {
+        csr, err := x509.ParseCertificateRequest(block.Bytes)
+        if err == nil && csr.CheckSignature() == nil &&
+        bytes.Equal(csr.RawSubject, node.Subject.GetRawSubject()) &&
+        publicKeysEqual(csr.PublicKey, pub) {
+          return csr, nil
+        }
+      }
+    } else if !os.IsNotExist(err) {
+      return nil, err
+    }
+  }
+
+  der, err := NewCertificateSigningRequest(self.backend, &node.X509ConfigurationDTO)
+  if err != nil {
+    return nil, err
+  }
+  if node.CSR != "" {
+    if err = writePEM(node.CSR, "CERTIFICATE REQUEST", der); err != nil {
+      return nil, err
+    }
+  }
+  return x509.ParseCertificateRequest(der)
+}
+
+
+// Compare an existing certificate with the specification of node.
+func (self *hierarchyApplier) compare(node *dto.X509HierarchyNode, parent *dto.X509HierarchyNode, issuer *x509.Certificate, crt *x509.Certificate, pub crypto.PublicKey) ([]string, error) {
+  problems := []string{}
+  if !publicKeysEqual(crt.PublicKey, pub) {
+    problems = append(problems, "the public key differs from the signer key")
+  }
+  if !bytes.Equal(crt.RawSubject, node.Subject.GetRawSubject()) {
+    problems = append(problems, "the subject differs")
+  }
+  if issuer == nil {
+    issuer = crt
+  }
+  if err := crt.CheckSignatureFrom(issuer); err != nil {
+    problems = append(problems, "the certificate is not signed by its issuer")
+  }
+
+  // Build the certificate that would be issued now and compare the
+  // attributes that are specified by the constraints.
+  builder := CertificateBuilder{
+    backend: self.backend,
+    opts: node.X509ConfigurationDTO,
+    issuer: issuer,
+    selfSigned: parent == nil,
+    profile: dto.X509Profile{CertificateConstraints: node.Constraints},
+  }
+  expected, err := builder.FromCSR(&x509.CertificateRequest{
+    PublicKeyAlgorithm: crt.PublicKeyAlgorithm,
+    PublicKey: crt.PublicKey,
+    RawSubject: crt.RawSubject,
+  })
+  if err != nil {
+    return nil, err
+  }
+  if crt.KeyUsage != expected.KeyUsage {
+    problems = append(problems, "the key usage differs")
+  }
+  if fmt.Sprint(crt.ExtKeyUsage) != fmt.Sprint(expected.ExtKeyUsage) {
+    problems = append(problems, "the extended key usage differs")
+  }
+  if crt.IsCA != expected.IsCA || pathLength(crt) != pathLength(expected) {
+    problems = append(problems, "the basic constraints differ")
+  }
+  if node.Constraints.End != "" && !crt.NotAfter.Equal(expected.NotAfter) {
+    problems = append(problems, "the expiry date differs")
+  }
+  if node.Constraints.Start != "" && !crt.NotBefore.Equal(expected.NotBefore) {
+    problems = append(problems, "the start date differs")
+  }
+  if time.Now().After(crt.NotAfter) {
+    problems = append(problems, "the certificate has expired")
+  }
+  return problems, nil
+}
+
+
+// Return the path length constraint of a CA certificate, or -1 if the path
+// length is not constrained.
+func pathLength(crt *x509.Certificate) int {
+  if crt.MaxPathLen > 0 || crt.MaxPathLenZero {
+    return crt.MaxPathLen
+  }
+  return -1
+}
+
+
+func publicKeysEqual(a crypto.PublicKey, b crypto.PublicKey) bool {
+  x, err := x509.MarshalPKIXPublicKey(a)
+  if err != nil {
+    return false
+  }
+  y, err := x509.MarshalPKIXPublicKey(b)
+  if err != nil {
+    return false
+  }
+  return bytes.Equal(x, y)
+}
+
+
+func writePEM(fp string, blockType string, der []byte) error {
+  buf := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+  return ioutil.WriteFile(fp, buf, 0644)
+}
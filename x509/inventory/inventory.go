@@ -0,0 +1,228 @@
+package inventory
+
+import (
+  "bufio"
+  "crypto/x509"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "sync"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/audit"
+)
+
+
+const (
+  EVENT_ISSUED = "issued"
+  EVENT_REVOKED = "revoked"
+)
+
+
+// The revocation reasons of RFC 5280, section 5.3.1, by name.
+var REASONS = map[string]int{
+  "unspecified": 0,
+  "keyCompromise": 1,
+  "cACompromise": 2,
+  "affiliationChanged": 3,
+  "superseded": 4,
+  "cessationOfOperation": 5,
+  "certificateHold": 6,
+  "privilegeWithdrawn": 9,
+  "aACompromise": 10,
+}
+
+
+// Serializes appends to the inventories of this process; a lock on the file
+// serializes them across processes.
+var mu sync.Mutex
+
+
+// An append-only log of the certificates issued by a CA, and of their
+// revocations, with one JSON record per line.
+type Inventory struct {
+  Path string
+}
+
+
+// A line of the inventory. Certificates are identified by the distinguished
+// name of their issuer and their serial number (hexadecimal).
+type Record struct {
+  Time time.Time `json:"time"`
+  Event string `json:"event"`
+  Issuer string `json:"issuer"`
+  Serial string `json:"serial"`
+  Subject string `json:"subject,omitempty"`
+  Names []string `json:"names,omitempty"`
+  NotBefore *time.Time `json:"not_before,omitempty"`
+  NotAfter *time.Time `json:"not_after,omitempty"`
+  Reason string `json:"reason,omitempty"`
+
+  // The profile with which the certificate was issued, and the serial
+  // number of the certificate of the same issuer that it renews.
+  Profile string `json:"profile,omitempty"`
+  Renews string `json:"renews,omitempty"`
+
+  // The DER-encoded certificate, for issued certificates.
+  Certificate []byte `json:"certificate,omitempty"`
+}
+
+
+func Open(path string) *Inventory {
+  return &Inventory{Path: path}
+}
+
+
+// Return the record of an issued certificate.
+func NewIssuedRecord(crt *x509.Certificate) *Record {
+  names := append([]string{}, crt.DNSNames...)
+  names = append(names, crt.EmailAddresses...)
+  for _, ip := range crt.IPAddresses {
+    names = append(names, ip.String())
+  }
+  for _, uri := range crt.URIs {
+    names = append(names, uri.String())
+  }
+  notBefore := crt.NotBefore.UTC()
+  notAfter := crt.NotAfter.UTC()
+  return &Record{
+    Time: time.Now().UTC(),
+    Event: EVENT_ISSUED,
+    Issuer: crt.Issuer.String(),
+    Serial: fmt.Sprintf("%x", crt.SerialNumber),
+    Subject: crt.Subject.String(),
+    Names: names,
+    NotBefore: &notBefore,
+    NotAfter: &notAfter,
+    Certificate: crt.Raw,
+  }
+}
+
+
+func (self *Inventory) Append(record *Record) error {
+  mu.Lock()
+  defer mu.Unlock()
+  f, err := self.lock()
+  if err != nil {
+    return err
+  }
+  defer f.Close()
+  return appendRecord(f, record)
+}
+
+
+// Open the inventory for appending and lock it; closing the file releases
+// the lock.
+func (self *Inventory) lock() (*os.File, error) {
+  f, err := os.OpenFile(self.Path, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0644)
+  if err != nil {
+    return nil, err
+  }
+  if err := audit.Lock(f); err != nil {
+    f.Close()
+    return nil, err
+  }
+  return f, nil
+}
+
+
+func appendRecord(f *os.File, record *Record) error {
+  buf, err := json.Marshal(record)
+  if err != nil {
+    return err
+  }
+  _, err = f.Write(append(buf, '\n'))
+  return err
+}
+
+
+// Return all records, in the order in which they were appended. A missing
+// inventory is empty.
+func (self *Inventory) Records() ([]Record, error) {
+  records := []Record{}
+  f, err := os.Open(self.Path)
+  if os.IsNotExist(err) {
+    return records, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  scanner := bufio.NewScanner(f)
+  scanner.Buffer(make([]byte, 64 * 1024), 1024 * 1024)
+  for n := 1; scanner.Scan(); n++ {
+    if len(scanner.Bytes()) == 0 {
+      continue
+    }
+    record := Record{}
+    if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+      return nil, errors.New(fmt.Sprintf("%s:%d: %s", self.Path, n, err))
+    }
+    records = append(records, record)
+  }
+  return records, scanner.Err()
+}
+
+
+// Return the issuance and the revocation of a certificate; either is nil if
+// the inventory does not have it.
+func (self *Inventory) Find(issuer string, serial string) (issued *Record, revoked *Record, err error) {
+  records, err := self.Records()
+  if err != nil {
+    return nil, nil, err
+  }
+  for i, record := range records {
+    if record.Issuer != issuer || record.Serial != serial {
+      continue
+    }
+    switch record.Event {
+      case EVENT_ISSUED:
+        issued = &records[i]
+      case EVENT_REVOKED:
+        if revoked == nil {
+          revoked = &records[i]
+        }
+    }
+  }
+  return issued, revoked, nil
+}
+
+
+// Record the revocation of a certificate that was issued by the CA. The
+// reason is one of REASONS; a certificate is revoked only once.
+func (self *Inventory) Revoke(issuer string, serial string, reason string) (*Record, error) {
+  if reason == "" {
+    reason = "unspecified"
+  }
+  if _, ok := REASONS[reason]; !ok {
+    return nil, errors.New(fmt.Sprintf("Unknown revocation reason: %s", reason))
+  }
+  mu.Lock()
+  defer mu.Unlock()
+  f, err := self.lock()
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+  issued, revoked, err := self.Find(issuer, serial)
+  if err != nil {
+    return nil, err
+  }
+  if issued == nil {
+    return nil, errors.New(fmt.Sprintf("Certificate %s was not issued by %s.", serial, issuer))
+  }
+  if revoked != nil {
+    return nil, errors.New(fmt.Sprintf("Certificate %s was revoked at %s.", serial,
+      revoked.Time.Format(time.RFC3339)))
+  }
+  record := &Record{
+    Time: time.Now().UTC(),
+    Event: EVENT_REVOKED,
+    Issuer: issuer,
+    Serial: serial,
+    Subject: issued.Subject,
+    Reason: reason,
+  }
+  return record, appendRecord(f, record)
+}
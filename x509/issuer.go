@@ -0,0 +1,198 @@
+package x509
+
+import (
+  "crypto/x509"
+  "errors"
+  "fmt"
+  "log"
+  "net"
+  "strings"
+  "time"
+)
+
+
+// Verify that issuer may sign crt: it must be a valid CA certificate that
+// permits keyCertSign, and crt must satisfy its path length, name
+// constraints and extended key usages. The path length of a CA certificate
+// that does not specify one is derived from the issuer. All violations are
+// reported in a single error.
+func CheckIssuer(issuer *x509.Certificate, crt *x509.Certificate, now time.Time) error {
+  violations := []string{}
+  add := func(format string, args ...interface{}) {
+    violations = append(violations, fmt.Sprintf(format, args...))
+  }
+
+  if now.After(issuer.NotAfter) {
+    add("the issuer expired at %s", issuer.NotAfter.Format(time.RFC3339))
+  }
+  if now.Before(issuer.NotBefore) {
+    add("the issuer is not valid before %s", issuer.NotBefore.Format(time.RFC3339))
+  }
+  if !issuer.BasicConstraintsValid || !issuer.IsCA {
+    add("the issuer is not a CA")
+  }
+  if issuer.KeyUsage != 0 && issuer.KeyUsage & x509.KeyUsageCertSign == 0 {
+    add("the key usage of the issuer does not include keyCertSign")
+  }
+
+  if crt.IsCA {
+    if msg := derivePathLength(issuer, crt); msg != "" {
+      add("%s", msg)
+    }
+  }
+
+  for _, name := range crt.DNSNames {
+    if !permitted(name, issuer.PermittedDNSDomains, issuer.ExcludedDNSDomains, matchDomainConstraint) {
+      add("DNS name %s is not permitted by the name constraints of the issuer", name)
+    }
+  }
+  for _, address := range crt.EmailAddresses {
+    if !permitted(address, issuer.PermittedEmailAddresses, issuer.ExcludedEmailAddresses, matchEmailConstraint) {
+      add("email address %s is not permitted by the name constraints of the issuer", address)
+    }
+  }
+  for _, uri := range crt.URIs {
+    if !permitted(uri.Hostname(), issuer.PermittedURIDomains, issuer.ExcludedURIDomains, matchDomainConstraint) {
+      add("URI %s is not permitted by the name constraints of the issuer", uri)
+    }
+  }
+  for _, ip := range crt.IPAddresses {
+    if !permittedIP(ip, issuer.PermittedIPRanges, issuer.ExcludedIPRanges) {
+      add("IP address %s is not permitted by the name constraints of the issuer", ip)
+    }
+  }
+
+  if restricted(issuer.ExtKeyUsage) {
+    for _, u := range crt.ExtKeyUsage {
+      found := false
+      for _, v := range issuer.ExtKeyUsage {
+        found = found || u == v
+      }
+      if !found {
+        add("extended key usage %s is not permitted by the issuer", extKeyUsageName(u))
+      }
+    }
+  }
+
+  if len(violations) > 0 {
+    return errors.New("The issuer can not sign the certificate:\n  - " +
+      strings.Join(violations, "\n  - "))
+  }
+  return nil
+}
+
+
+// Set the path length of the CA certificate crt to one less than that of
+// the issuer if it is not specified, or return a violation if it can not be
+// issued.
+func derivePathLength(issuer *x509.Certificate, crt *x509.Certificate) string {
+  max := pathLength(issuer)
+  if max == -1 {
+    return ""
+  }
+  if max == 0 {
+    return "the path length of the issuer does not permit subordinate CAs"
+  }
+  switch n := pathLength(crt); {
+    case n == -1:
+      log.Printf("Warning: the path length of the CA certificate is set to %d, as constrained by the issuer.", max - 1)
+      crt.MaxPathLen = max - 1
+      crt.MaxPathLenZero = crt.MaxPathLen == 0
+    case n > max - 1:
+      return fmt.Sprintf("the path length %d exceeds the maximum of %d permitted by the issuer", n, max - 1)
+  }
+  return ""
+}
+
+
+func restricted(usage []x509.ExtKeyUsage) bool {
+  if len(usage) == 0 {
+    return false
+  }
+  for _, u := range usage {
+    if u == x509.ExtKeyUsageAny {
+      return false
+    }
+  }
+  return true
+}
+
+
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+  for name, u := range EXTENDED_KEY_USAGES {
+    if u == usage {
+      return name
+    }
+  }
+  return fmt.Sprint(int(usage))
+}
+
+
+func permitted(name string, permitted []string, excluded []string, match func(string, string) bool) bool {
+  for _, c := range excluded {
+    if match(name, c) {
+      return false
+    }
+  }
+  if len(permitted) == 0 {
+    return true
+  }
+  for _, c := range permitted {
+    if match(name, c) {
+      return true
+    }
+  }
+  return false
+}
+
+
+func permittedIP(ip net.IP, permitted []*net.IPNet, excluded []*net.IPNet) bool {
+  for _, n := range excluded {
+    if n.Contains(ip) {
+      return false
+    }
+  }
+  if len(permitted) == 0 {
+    return true
+  }
+  for _, n := range permitted {
+    if n.Contains(ip) {
+      return true
+    }
+  }
+  return false
+}
+
+
+// A constraint "example.com" matches example.com and its subdomains,
+// ".example.com" only the subdomains (RFC 5280, section 4.2.1.10).
+func matchDomainConstraint(name string, constraint string) bool {
+  name = strings.ToLower(name)
+  constraint = strings.ToLower(constraint)
+  if constraint == "" {
+    return true
+  }
+  if strings.HasPrefix(constraint, ".") {
+    return strings.HasSuffix(name, constraint)
+  }
+  return name == constraint || strings.HasSuffix(name, "." + constraint)
+}
+
+
+// A constraint is an address, a host ("example.com") or a domain
+// (".example.com").
+func matchEmailConstraint(address string, constraint string) bool {
+  if strings.Contains(constraint, "@") {
+    return strings.EqualFold(address, constraint)
+  }
+  i := strings.LastIndex(address, "@")
+  if i < 0 {
+    return false
+  }
+  host := strings.ToLower(address[i+1:])
+  constraint = strings.ToLower(constraint)
+  if strings.HasPrefix(constraint, ".") {
+    return strings.HasSuffix(host, constraint)
+  }
+  return host == constraint
+}
@@ -0,0 +1,128 @@
+package lint
+
+import (
+  "crypto/x509"
+  "errors"
+  "fmt"
+  "strings"
+)
+
+
+const (
+  SOURCE_RFC5280 = "rfc5280"
+  SOURCE_CABF = "cabf-br"
+  SOURCE_INTERNAL = "internal"
+
+  SEVERITY_ERROR = "error"
+  SEVERITY_WARNING = "warning"
+  SEVERITY_IGNORE = "ignore"
+)
+
+
+// A check of a certificate. Check returns a message for each problem that
+// it finds.
+type Rule struct {
+  Name string
+  Source string
+  Severity string
+  Description string
+  Check func(crt *x509.Certificate) []string
+}
+
+
+type Result struct {
+  Rule string `json:"rule"`
+  Source string `json:"source"`
+  Severity string `json:"severity"`
+  Message string `json:"message"`
+}
+
+
+type Results []Result
+
+
+// Run the rules against crt. The severity of a rule is overridden by
+// severities, which maps rule names or sources to error, warning or
+// ignore; rule names take precedence.
+func Run(crt *x509.Certificate, severities map[string]string) (Results, error) {
+  if err := checkSeverities(severities); err != nil {
+    return nil, err
+  }
+  results := Results{}
+  for _, rule := range RULES {
+    severity := rule.Severity
+    if s, ok := severities[rule.Source]; ok {
+      severity = s
+    }
+    if s, ok := severities[rule.Name]; ok {
+      severity = s
+    }
+    if severity == SEVERITY_IGNORE {
+      continue
+    }
+    for _, msg := range rule.Check(crt) {
+      results = append(results, Result{
+        Rule: rule.Name,
+        Source: rule.Source,
+        Severity: severity,
+        Message: msg,
+      })
+    }
+  }
+  return results, nil
+}
+
+
+func checkSeverities(severities map[string]string) error {
+  for key, severity := range severities {
+    switch severity {
+      case SEVERITY_ERROR, SEVERITY_WARNING, SEVERITY_IGNORE:
+      default:
+        return errors.New(fmt.Sprintf("Invalid severity for %s: %s", key, severity))
+    }
+    if key == SOURCE_RFC5280 || key == SOURCE_CABF || key == SOURCE_INTERNAL {
+      continue
+    }
+    found := false
+    for _, rule := range RULES {
+      found = found || rule.Name == key
+    }
+    if !found {
+      return errors.New(fmt.Sprintf("Unknown lint rule: %s", key))
+    }
+  }
+  return nil
+}
+
+
+func (self Results) Errors() Results {
+  results := Results{}
+  for _, r := range self {
+    if r.Severity == SEVERITY_ERROR {
+      results = append(results, r)
+    }
+  }
+  return results
+}
+
+
+func (self Result) String() string {
+  return fmt.Sprintf("%s: %s [%s, %s]", strings.Title(self.Severity),
+    self.Message, self.Source, self.Rule)
+}
+
+
+// Return an error that lists the results with severity error, or nil if
+// there are none.
+func (self Results) Err() error {
+  results := self.Errors()
+  if len(results) == 0 {
+    return nil
+  }
+  lines := []string{}
+  for _, r := range results {
+    lines = append(lines, fmt.Sprintf("%s [%s, %s]", r.Message, r.Source, r.Rule))
+  }
+  return errors.New("The certificate does not pass the lint checks:\n  - " +
+    strings.Join(lines, "\n  - "))
+}
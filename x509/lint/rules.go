@@ -0,0 +1,389 @@
+package lint
+
+import (
+  "bytes"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "crypto/rsa"
+  "crypto/sha1"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/asn1"
+  "fmt"
+  "net"
+  "strings"
+  "time"
+)
+
+
+var (
+  oidKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 15}
+  oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+  oidBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+  oidNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+)
+
+
+// The maximum validity of a subscriber certificate, as required by the
+// CA/Browser Forum Baseline Requirements (section 6.3.2).
+const CABF_MAX_VALIDITY = 398 * 24 * time.Hour
+
+
+var RULES = []Rule{
+  {
+    Name: "serial-not-positive",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "The serial number must be a positive integer (4.1.2.2).",
+    Check: func(crt *x509.Certificate) []string {
+      if crt.SerialNumber == nil || crt.SerialNumber.Sign() <= 0 {
+        return []string{"the serial number is not positive"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "serial-too-long",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "The serial number must not be longer than 20 octets (4.1.2.2).",
+    Check: func(crt *x509.Certificate) []string {
+      if crt.SerialNumber != nil && len(crt.SerialNumber.Bytes()) > 20 {
+        return []string{"the serial number is longer than 20 octets"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "empty-subject-without-san",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "A certificate with an empty subject must have a Subject Alternative Name (4.1.2.6).",
+    Check: func(crt *x509.Certificate) []string {
+      if emptySubject(crt) && findExtension(crt, oidSubjectAltName) == nil {
+        return []string{"the subject is empty and there is no Subject Alternative Name"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "empty-subject-san-not-critical",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "The Subject Alternative Name must be critical if the subject is empty (4.2.1.6).",
+    Check: func(crt *x509.Certificate) []string {
+      e := findExtension(crt, oidSubjectAltName)
+      if emptySubject(crt) && e != nil && !e.Critical {
+        return []string{"the subject is empty, but the Subject Alternative Name is not critical"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "san-critical",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_WARNING,
+    Description: "The Subject Alternative Name should not be critical if the subject is not empty (4.2.1.6).",
+    Check: func(crt *x509.Certificate) []string {
+      e := findExtension(crt, oidSubjectAltName)
+      if !emptySubject(crt) && e != nil && e.Critical {
+        return []string{"the Subject Alternative Name is critical, but the subject is not empty"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "ca-basic-constraints-not-critical",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "The basic constraints of a CA certificate must be critical (4.2.1.9).",
+    Check: func(crt *x509.Certificate) []string {
+      e := findExtension(crt, oidBasicConstraints)
+      if crt.IsCA && e != nil && !e.Critical {
+        return []string{"the basic constraints of the CA certificate are not critical"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "ca-key-usage",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "A CA certificate must have a key usage that includes keyCertSign (4.2.1.3).",
+    Check: func(crt *x509.Certificate) []string {
+      if !crt.IsCA {
+        return nil
+      }
+      if findExtension(crt, oidKeyUsage) == nil {
+        return []string{"the CA certificate has no key usage"}
+      }
+      if crt.KeyUsage & x509.KeyUsageCertSign == 0 {
+        return []string{"the key usage of the CA certificate does not include keyCertSign"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "key-usage-not-critical",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_WARNING,
+    Description: "The key usage should be critical (4.2.1.3).",
+    Check: func(crt *x509.Certificate) []string {
+      if e := findExtension(crt, oidKeyUsage); e != nil && !e.Critical {
+        return []string{"the key usage is not critical"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "path-length-without-ca",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "A path length may only be specified for CA certificates that assert keyCertSign (4.2.1.9).",
+    Check: func(crt *x509.Certificate) []string {
+      constrained := crt.MaxPathLen > 0 || crt.MaxPathLenZero
+      if constrained && (!crt.IsCA || crt.KeyUsage & x509.KeyUsageCertSign == 0) {
+        return []string{"a path length is specified, but the certificate can not sign certificates"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "ca-ski-missing",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "A CA certificate must have a Subject Key Identifier (4.2.1.2).",
+    Check: func(crt *x509.Certificate) []string {
+      if crt.IsCA && len(crt.SubjectKeyId) == 0 {
+        return []string{"the CA certificate has no Subject Key Identifier"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "ski-missing",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_WARNING,
+    Description: "An end-entity certificate should have a Subject Key Identifier (4.2.1.2).",
+    Check: func(crt *x509.Certificate) []string {
+      if !crt.IsCA && len(crt.SubjectKeyId) == 0 {
+        return []string{"the certificate has no Subject Key Identifier"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "ski-method",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_WARNING,
+    Description: "The Subject Key Identifier should be derived with method 1 or 2 (4.2.1.2).",
+    Check: func(crt *x509.Certificate) []string {
+      if len(crt.SubjectKeyId) == 0 {
+        return nil
+      }
+      spk, err := subjectPublicKey(crt)
+      if err != nil {
+        return nil
+      }
+      h := sha1.Sum(spk)
+      method2 := append([]byte{0x40 | h[12] & 0x0f}, h[13:]...)
+      if !bytes.Equal(crt.SubjectKeyId, h[:]) && !bytes.Equal(crt.SubjectKeyId, method2) {
+        return []string{"the Subject Key Identifier is not the SHA-1 hash of the public key"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "aki-missing",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "A certificate that is not self-signed must have an Authority Key Identifier (4.2.1.1).",
+    Check: func(crt *x509.Certificate) []string {
+      if !bytes.Equal(crt.RawIssuer, crt.RawSubject) && len(crt.AuthorityKeyId) == 0 {
+        return []string{"the certificate has no Authority Key Identifier"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "name-constraints",
+    Source: SOURCE_RFC5280,
+    Severity: SEVERITY_ERROR,
+    Description: "Name constraints must be critical and may only appear in CA certificates (4.2.1.10).",
+    Check: func(crt *x509.Certificate) []string {
+      e := findExtension(crt, oidNameConstraints)
+      switch {
+        case e == nil:
+          return nil
+        case !crt.IsCA:
+          return []string{"the certificate has name constraints, but it is not a CA certificate"}
+        case !e.Critical:
+          return []string{"the name constraints are not critical"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "cabf-san-missing",
+    Source: SOURCE_CABF,
+    Severity: SEVERITY_ERROR,
+    Description: "A TLS server certificate must have a Subject Alternative Name (7.1.2.3).",
+    Check: func(crt *x509.Certificate) []string {
+      if isServer(crt) && len(crt.DNSNames) + len(crt.IPAddresses) == 0 {
+        return []string{"the server certificate has no DNS names or IP addresses"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "cabf-cn-not-in-san",
+    Source: SOURCE_CABF,
+    Severity: SEVERITY_ERROR,
+    Description: "The common name of a TLS server certificate must be one of its Subject Alternative Names (7.1.4.2).",
+    Check: func(crt *x509.Certificate) []string {
+      cn := crt.Subject.CommonName
+      if !isServer(crt) || cn == "" {
+        return nil
+      }
+      for _, name := range crt.DNSNames {
+        if strings.EqualFold(name, cn) {
+          return nil
+        }
+      }
+      for _, ip := range crt.IPAddresses {
+        if ip.Equal(net.ParseIP(cn)) {
+          return nil
+        }
+      }
+      return []string{fmt.Sprintf("the common name %s is not a Subject Alternative Name", cn)}
+    },
+  },
+  {
+    Name: "cabf-validity-too-long",
+    Source: SOURCE_CABF,
+    Severity: SEVERITY_ERROR,
+    Description: "A TLS server certificate must not be valid for more than 398 days (6.3.2).",
+    Check: func(crt *x509.Certificate) []string {
+      if isServer(crt) && crt.NotAfter.Sub(crt.NotBefore) > CABF_MAX_VALIDITY {
+        return []string{"the server certificate is valid for more than 398 days"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "cabf-serial-entropy",
+    Source: SOURCE_CABF,
+    Severity: SEVERITY_ERROR,
+    Description: "The serial number must contain at least 64 bits of entropy (7.1).",
+    Check: func(crt *x509.Certificate) []string {
+      if crt.SerialNumber != nil && crt.SerialNumber.BitLen() < 64 {
+        return []string{"the serial number is shorter than 64 bits"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "cabf-key-strength",
+    Source: SOURCE_CABF,
+    Severity: SEVERITY_ERROR,
+    Description: "RSA keys must have at least 2048 bits, and ECDSA keys must use P-256, P-384 or P-521 (6.1.5).",
+    Check: func(crt *x509.Certificate) []string {
+      switch key := crt.PublicKey.(type) {
+        case *rsa.PublicKey:
+          if key.N.BitLen() < 2048 {
+            return []string{fmt.Sprintf("the RSA key has %d bits", key.N.BitLen())}
+          }
+        case *ecdsa.PublicKey:
+          switch key.Curve {
+            case elliptic.P256(), elliptic.P384(), elliptic.P521():
+            default:
+              return []string{fmt.Sprintf("the ECDSA key uses curve %s", key.Curve.Params().Name)}
+          }
+      }
+      return nil
+    },
+  },
+  {
+    Name: "sha1-signature",
+    Source: SOURCE_INTERNAL,
+    Severity: SEVERITY_ERROR,
+    Description: "Certificates must not be signed with SHA-1.",
+    Check: func(crt *x509.Certificate) []string {
+      switch crt.SignatureAlgorithm {
+        case x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1:
+          return []string{"the certificate is signed with SHA-1"}
+      }
+      return nil
+    },
+  },
+  {
+    Name: "key-usage-key-type",
+    Source: SOURCE_INTERNAL,
+    Severity: SEVERITY_ERROR,
+    Description: "The key usage must be possible with the type of the public key.",
+    Check: func(crt *x509.Certificate) []string {
+      problems := []string{}
+      encipherment := x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment
+      switch crt.PublicKey.(type) {
+        case *ecdsa.PublicKey:
+          if crt.KeyUsage & encipherment != 0 {
+            problems = append(problems, "an ECDSA key can not be used for encipherment")
+          }
+        case ed25519.PublicKey:
+          if crt.KeyUsage & (encipherment | x509.KeyUsageKeyAgreement) != 0 {
+            problems = append(problems, "an Ed25519 key can only be used for signatures")
+          }
+        case *rsa.PublicKey:
+          if crt.KeyUsage & x509.KeyUsageKeyAgreement != 0 {
+            problems = append(problems, "an RSA key can not be used for key agreement")
+          }
+      }
+      return problems
+    },
+  },
+}
+
+
+func emptySubject(crt *x509.Certificate) bool {
+  var subject pkix.RDNSequence
+  if _, err := asn1.Unmarshal(crt.RawSubject, &subject); err != nil {
+    return false
+  }
+  return len(subject) == 0
+}
+
+
+func isServer(crt *x509.Certificate) bool {
+  if crt.IsCA {
+    return false
+  }
+  for _, u := range crt.ExtKeyUsage {
+    if u == x509.ExtKeyUsageServerAuth {
+      return true
+    }
+  }
+  return false
+}
+
+
+func findExtension(crt *x509.Certificate, id asn1.ObjectIdentifier) *pkix.Extension {
+  for i := range crt.Extensions {
+    if crt.Extensions[i].Id.Equal(id) {
+      return &crt.Extensions[i]
+    }
+  }
+  return nil
+}
+
+
+func subjectPublicKey(crt *x509.Certificate) ([]byte, error) {
+  info := struct {
+    Algorithm pkix.AlgorithmIdentifier
+    PublicKey asn1.BitString
+  }{}
+  if _, err := asn1.Unmarshal(crt.RawSubjectPublicKeyInfo, &info); err != nil {
+    return nil, err
+  }
+  return info.PublicKey.Bytes, nil
+}
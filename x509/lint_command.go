@@ -0,0 +1,94 @@
+package x509
+
+import (
+  "crypto/x509"
+  "encoding/json"
+  "flag"
+  "fmt"
+  "io/ioutil"
+  "log"
+  "os"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/lint"
+)
+
+
+type lintReport struct {
+  File string `json:"file"`
+  Subject string `json:"subject"`
+  Results lint.Results `json:"results"`
+}
+
+
+// Run the lint rules against existing certificates, which are read from
+// the files given as arguments or from stdin.
+func LintCertificate(buf []byte, args []string, backend backends.Backend) {
+  var asJSON bool
+  var caConf string
+  var profileName string
+
+  parser := flag.NewFlagSet("lint", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies a CA configuration file with the severities of the rules.")
+  parser.StringVar(&profileName, "profile", "",
+    "specifies the profile whose severities are applied.")
+  parser.BoolVar(&asJSON, "json", false,
+    "writes the results as JSON.")
+  parser.Parse(args)
+
+  severities := map[string]string{}
+  if caConf != "" {
+    opts := dto.X509ConfigurationDTO{}
+    err := opts.Load(caConf, nil)
+    if err != nil { log.Fatal(err) }
+    profile, err := opts.GetProfile(profileName)
+    if err != nil { log.Fatal(err) }
+    severities = opts.Lint.GetSeverities(&profile.Lint)
+  }
+
+  files := parser.Args()
+  if len(files) == 0 {
+    files = []string{"-"}
+  }
+  reports := []lintReport{}
+  failed := false
+  for _, fp := range files {
+    var crt *x509.Certificate
+    var err error
+    if fp == "-" {
+      crt, err = ParseCertificate(buf)
+    } else {
+      var der []byte
+      if der, err = ioutil.ReadFile(fp); err == nil {
+        crt, err = ParseCertificate(der)
+      }
+    }
+    if err != nil { log.Fatal(err) }
+
+    results, err := lint.Run(crt, severities)
+    if err != nil { log.Fatal(err) }
+    failed = failed || len(results.Errors()) > 0
+    reports = append(reports, lintReport{fp, crt.Subject.String(), results})
+  }
+
+  if asJSON {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(reports)
+  } else {
+    for _, r := range reports {
+      fmt.Printf("%s (%s)\n", r.File, r.Subject)
+      for _, result := range r.Results {
+        fmt.Printf("  %s\n", result)
+      }
+      if len(r.Results) == 0 {
+        fmt.Println("  OK")
+      }
+    }
+  }
+  if failed {
+    os.Exit(1)
+  }
+}
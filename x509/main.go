@@ -13,6 +13,9 @@ var (
   oidEmailAddress = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
   oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
   oidCrlDistribution = asn1.ObjectIdentifier{2,5,29,31}
+  oidBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+  oidAuthorityKeyId = asn1.ObjectIdentifier{2, 5, 29, 35}
+  oidAuthorityInfoAccess = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
 )
 
 
@@ -25,6 +28,24 @@ func Handle(buf []byte, args []string, backend backends.Backend) {
       CreateCertificateSigningRequest(buf, args[1:], backend)
     case "sign":
       SignCertificate(buf, args[1:], backend)
+    case "cross-sign":
+      CrossSignCertificate(buf, args[1:], backend)
+    case "renew":
+      RenewCertificate(buf, args[1:], backend)
+    case "submit":
+      SubmitRequest(buf, args[1:], backend)
+    case "approve":
+      ApproveRequest(buf, args[1:], backend)
+    case "issue":
+      IssueRequest(buf, args[1:], backend)
+    case "assemble":
+      AssembleBundle(buf, args[1:], backend)
+    case "hierarchy":
+      HandleHierarchy(buf, args[1:], backend)
+    case "lint":
+      LintCertificate(buf, args[1:], backend)
+    case "verify":
+      VerifyCertificateChain(buf, args[1:], backend)
     default:
       log.Fatal("Unknown operation: ", op)
       os.Exit(1)
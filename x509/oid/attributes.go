@@ -0,0 +1,77 @@
+package oid
+
+import (
+  "encoding/asn1"
+  "errors"
+  "fmt"
+  "strconv"
+  "strings"
+)
+
+
+// Short names of the attribute types that are commonly used in
+// distinguished names.
+var ATTRIBUTES = map[string]asn1.ObjectIdentifier{
+  "CN": {2, 5, 4, 3},
+  "SN": {2, 5, 4, 4},
+  "serialNumber": {2, 5, 4, 5},
+  "C": {2, 5, 4, 6},
+  "L": {2, 5, 4, 7},
+  "ST": {2, 5, 4, 8},
+  "street": {2, 5, 4, 9},
+  "O": {2, 5, 4, 10},
+  "OU": {2, 5, 4, 11},
+  "title": {2, 5, 4, 12},
+  "postalCode": {2, 5, 4, 17},
+  "GN": {2, 5, 4, 42},
+  "initials": {2, 5, 4, 43},
+  "generationQualifier": {2, 5, 4, 44},
+  "dnQualifier": {2, 5, 4, 46},
+  "pseudonym": {2, 5, 4, 65},
+  "organizationIdentifier": {2, 5, 4, 97},
+  "UID": {0, 9, 2342, 19200300, 100, 1, 1},
+  "DC": {0, 9, 2342, 19200300, 100, 1, 25},
+  "emailAddress": OID_EMAIL,
+}
+
+
+// Parse a dotted object identifier, e.g. "2.5.4.3".
+func Parse(s string) (asn1.ObjectIdentifier, error) {
+  oid := asn1.ObjectIdentifier{}
+  for _, part := range strings.Split(s, ".") {
+    n, err := strconv.Atoi(part)
+    if err != nil || n < 0 {
+      return nil, errors.New(fmt.Sprintf("Invalid object identifier: %s", s))
+    }
+    oid = append(oid, n)
+  }
+  if len(oid) < 2 {
+    return nil, errors.New(fmt.Sprintf("Invalid object identifier: %s", s))
+  }
+  return oid, nil
+}
+
+
+// Return the attribute type with the given short name or dotted object
+// identifier.
+func LookupAttribute(name string) (asn1.ObjectIdentifier, error) {
+  if oid, ok := ATTRIBUTES[name]; ok {
+    return oid, nil
+  }
+  if oid, err := Parse(name); err == nil {
+    return oid, nil
+  }
+  return nil, errors.New(fmt.Sprintf("Unknown attribute type: %s", name))
+}
+
+
+// Return the short name of an attribute type, or its dotted representation
+// if it has no short name.
+func AttributeName(oid asn1.ObjectIdentifier) string {
+  for name, o := range ATTRIBUTES {
+    if o.Equal(oid) {
+      return name
+    }
+  }
+  return oid.String()
+}
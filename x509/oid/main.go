@@ -5,3 +5,4 @@ import "encoding/asn1"
 
 var OID_EMAIL = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}
 var OID_SAN = asn1.ObjectIdentifier{2, 5, 29, 17}
+var OID_EXTENSION_REQUEST = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
@@ -0,0 +1,239 @@
+package pkcs7
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/aes"
+  "crypto/cipher"
+  "crypto/des"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/asn1"
+  "errors"
+  "fmt"
+)
+
+
+var (
+  OID_ENVELOPED_DATA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+  OID_RSA_OAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+
+  OID_DES_CBC = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
+  OID_DES_EDE3_CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+  OID_AES128_CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+  OID_AES192_CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+  OID_AES256_CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+
+type envelopedData struct {
+  Version int
+  RecipientInfos []keyTransRecipientInfo `asn1:"set"`
+  EncryptedContentInfo encryptedContentInfo
+}
+
+
+type keyTransRecipientInfo struct {
+  Version int
+  IssuerAndSerialNumber issuerAndSerialNumber
+  KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+  EncryptedKey []byte
+}
+
+
+type encryptedContentInfo struct {
+  ContentType asn1.ObjectIdentifier
+  ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+  EncryptedContent []byte `asn1:"optional,tag:0"`
+}
+
+
+type oaepParameters struct {
+  HashFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+}
+
+
+// Decrypt a ContentInfo with EnvelopedData for the recipient crt, whose
+// private key is key. The content encryption key must be transported with
+// RSA (PKCS #1 v1.5 or OAEP). Return the content and the algorithm with
+// which it was encrypted.
+func Decrypt(der []byte, crt *x509.Certificate, key crypto.Decrypter) ([]byte, asn1.ObjectIdentifier, error) {
+  ci := contentInfo{}
+  if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) > 0 {
+    return nil, nil, errors.New("Malformed ContentInfo.")
+  }
+  if !ci.ContentType.Equal(OID_ENVELOPED_DATA) {
+    return nil, nil, errors.New(fmt.Sprintf("Not an EnvelopedData structure: %s", ci.ContentType))
+  }
+  ed := envelopedData{}
+  if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+    return nil, nil, errors.New(fmt.Sprintf("Malformed EnvelopedData: %s", err))
+  }
+  var recipient *keyTransRecipientInfo
+  for i, ri := range ed.RecipientInfos {
+    id := ri.IssuerAndSerialNumber
+    if bytes.Equal(id.Issuer.FullBytes, crt.RawIssuer) && id.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+      recipient = &ed.RecipientInfos[i]
+    }
+  }
+  if recipient == nil {
+    return nil, nil, errors.New("The content is not encrypted for this recipient.")
+  }
+
+  eci := ed.EncryptedContentInfo
+  keySize, err := getKeySize(eci.ContentEncryptionAlgorithm.Algorithm)
+  if err != nil {
+    return nil, nil, err
+  }
+
+  // PKCS #1 v1.5 padding errors are not reported: a random key is used
+  // instead, so that the key of the recipient is not a padding oracle
+  // (Bleichenbacher). The content then fails to decrypt like any other
+  // content that was encrypted with the wrong key.
+  var opts crypto.DecrypterOpts
+  switch alg := recipient.KeyEncryptionAlgorithm; {
+    case alg.Algorithm.Equal(OID_RSA):
+      opts = &rsa.PKCS1v15DecryptOptions{SessionKeyLen: keySize}
+    case alg.Algorithm.Equal(OID_RSA_OAEP):
+      params := oaepParameters{}
+      hash := crypto.SHA1
+      if len(alg.Parameters.FullBytes) > 0 {
+        if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+          return nil, nil, err
+        }
+      }
+      if params.HashFunc.Algorithm != nil {
+        h, ok := DIGEST_ALGORITHMS[params.HashFunc.Algorithm.String()]
+        if !ok {
+          return nil, nil, errors.New(fmt.Sprintf("Unsupported OAEP hash: %s", params.HashFunc.Algorithm))
+        }
+        hash = h
+      }
+      opts = &rsa.OAEPOptions{Hash: hash}
+    default:
+      return nil, nil, errors.New(fmt.Sprintf("Unsupported key encryption algorithm: %s", alg.Algorithm))
+  }
+  cek, err := key.Decrypt(rand.Reader, recipient.EncryptedKey, opts)
+  if err != nil {
+    return nil, nil, err
+  }
+  if len(cek) != keySize {
+    cek = make([]byte, keySize)
+    if _, err := rand.Read(cek); err != nil {
+      return nil, nil, err
+    }
+  }
+
+  block, err := newBlockCipher(eci.ContentEncryptionAlgorithm.Algorithm, cek)
+  if err != nil {
+    return nil, nil, err
+  }
+  iv := []byte{}
+  if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+    return nil, nil, errors.New("Malformed initialization vector.")
+  }
+  content := eci.EncryptedContent
+  if len(iv) != block.BlockSize() || len(content) == 0 || len(content) % block.BlockSize() != 0 {
+    return nil, nil, errors.New("Malformed encrypted content.")
+  }
+  plaintext := make([]byte, len(content))
+  cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, content)
+  padding := int(plaintext[len(plaintext) - 1])
+  if padding == 0 || padding > block.BlockSize() {
+    return nil, nil, errors.New("Invalid padding.")
+  }
+  return plaintext[:len(plaintext) - padding], eci.ContentEncryptionAlgorithm.Algorithm, nil
+}
+
+
+// Encrypt content for the recipient crt, which must have an RSA key, with
+// the given content encryption algorithm, and return a ContentInfo with
+// EnvelopedData.
+func Encrypt(content []byte, crt *x509.Certificate, algorithm asn1.ObjectIdentifier) ([]byte, error) {
+  pub, ok := crt.PublicKey.(*rsa.PublicKey)
+  if !ok {
+    return nil, errors.New("The recipient must have an RSA key.")
+  }
+  size, err := getKeySize(algorithm)
+  if err != nil {
+    return nil, err
+  }
+  cek := make([]byte, size)
+  if _, err := rand.Read(cek); err != nil {
+    return nil, err
+  }
+  block, err := newBlockCipher(algorithm, cek)
+  if err != nil {
+    return nil, err
+  }
+  iv := make([]byte, block.BlockSize())
+  if _, err := rand.Read(iv); err != nil {
+    return nil, err
+  }
+  padding := block.BlockSize() - len(content) % block.BlockSize()
+  ciphertext := append(append([]byte{}, content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+  cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+  params, err := asn1.Marshal(iv)
+  if err != nil {
+    return nil, err
+  }
+  encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, cek)
+  if err != nil {
+    return nil, err
+  }
+
+  buf, err := asn1.Marshal(envelopedData{
+    Version: 0,
+    RecipientInfos: []keyTransRecipientInfo{{
+      Version: 0,
+      IssuerAndSerialNumber: issuerAndSerialNumber{asn1.RawValue{FullBytes: crt.RawIssuer}, crt.SerialNumber},
+      KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: OID_RSA, Parameters: asn1.NullRawValue},
+      EncryptedKey: encryptedKey,
+    }},
+    EncryptedContentInfo: encryptedContentInfo{
+      ContentType: OID_DATA,
+      ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm, Parameters: asn1.RawValue{FullBytes: params}},
+      EncryptedContent: ciphertext,
+    },
+  })
+  if err != nil {
+    return nil, err
+  }
+  return asn1.Marshal(contentInfo{
+    ContentType: OID_ENVELOPED_DATA,
+    Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: buf},
+  })
+}
+
+
+// Return the size of the key of a content encryption algorithm, in bytes.
+func getKeySize(algorithm asn1.ObjectIdentifier) (int, error) {
+  switch {
+    case algorithm.Equal(OID_DES_CBC):
+      return 8, nil
+    case algorithm.Equal(OID_DES_EDE3_CBC), algorithm.Equal(OID_AES192_CBC):
+      return 24, nil
+    case algorithm.Equal(OID_AES128_CBC):
+      return 16, nil
+    case algorithm.Equal(OID_AES256_CBC):
+      return 32, nil
+    default:
+      return 0, errors.New(fmt.Sprintf("Unsupported content encryption algorithm: %s", algorithm))
+  }
+}
+
+
+func newBlockCipher(algorithm asn1.ObjectIdentifier, key []byte) (cipher.Block, error) {
+  switch {
+    case algorithm.Equal(OID_DES_CBC):
+      return des.NewCipher(key)
+    case algorithm.Equal(OID_DES_EDE3_CBC):
+      return des.NewTripleDESCipher(key)
+    case algorithm.Equal(OID_AES128_CBC), algorithm.Equal(OID_AES192_CBC), algorithm.Equal(OID_AES256_CBC):
+      return aes.NewCipher(key)
+    default:
+      return nil, errors.New(fmt.Sprintf("Unsupported content encryption algorithm: %s", algorithm))
+  }
+}
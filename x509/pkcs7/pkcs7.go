@@ -0,0 +1,52 @@
+package pkcs7
+
+import (
+  "crypto/x509/pkix"
+  "encoding/asn1"
+)
+
+
+var (
+  OID_DATA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+  OID_SIGNED_DATA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+
+type contentInfo struct {
+  ContentType asn1.ObjectIdentifier
+  // [0] EXPLICIT; the tag is part of the raw value.
+  Content asn1.RawValue `asn1:"optional"`
+}
+
+
+type signedData struct {
+  Version int
+  DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+  ContentInfo contentInfo
+  Certificates asn1.RawValue `asn1:"optional,tag:0"`
+  SignerInfos []asn1.RawValue `asn1:"set"`
+}
+
+
+// Return a degenerate ("certs-only") SignedData structure that holds the
+// DER-encoded certificates, as used by EST and SCEP to convey certificates.
+func CertificatesOnly(certs ...[]byte) ([]byte, error) {
+  raw := []byte{}
+  for _, der := range certs {
+    raw = append(raw, der...)
+  }
+  sd, err := asn1.Marshal(signedData{
+    Version: 1,
+    DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+    ContentInfo: contentInfo{ContentType: OID_DATA},
+    Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+    SignerInfos: []asn1.RawValue{},
+  })
+  if err != nil {
+    return nil, err
+  }
+  return asn1.Marshal(contentInfo{
+    ContentType: OID_SIGNED_DATA,
+    Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+  })
+}
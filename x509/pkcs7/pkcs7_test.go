@@ -0,0 +1,173 @@
+package pkcs7
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/asn1"
+  "math/big"
+  "testing"
+  "time"
+)
+
+
+var CONTENT_ENCRYPTION_ALGORITHMS = map[string]asn1.ObjectIdentifier{
+  "DES": OID_DES_CBC,
+  "3DES": OID_DES_EDE3_CBC,
+  "AES-128": OID_AES128_CBC,
+  "AES-192": OID_AES192_CBC,
+  "AES-256": OID_AES256_CBC,
+}
+
+
+// Return a self-signed certificate for a new RSA key.
+func newRecipient(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+  key, err := rsa.GenerateKey(rand.Reader, 2048)
+  if err != nil {
+    t.Fatal(err)
+  }
+  template := &x509.Certificate{
+    SerialNumber: big.NewInt(1),
+    Subject: pkix.Name{CommonName: "recipient"},
+    NotBefore: time.Now(),
+    NotAfter: time.Now().Add(time.Hour),
+  }
+  der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+  if err != nil {
+    t.Fatal(err)
+  }
+  crt, err := x509.ParseCertificate(der)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return crt, key
+}
+
+
+// Return the EnvelopedData in der after it is changed by f.
+func modifyEnvelope(t *testing.T, der []byte, f func(*envelopedData)) []byte {
+  ci := contentInfo{}
+  if _, err := asn1.Unmarshal(der, &ci); err != nil {
+    t.Fatal(err)
+  }
+  ed := envelopedData{}
+  if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+    t.Fatal(err)
+  }
+  f(&ed)
+  buf, err := asn1.Marshal(ed)
+  if err != nil {
+    t.Fatal(err)
+  }
+  der, err = asn1.Marshal(contentInfo{
+    ContentType: OID_ENVELOPED_DATA,
+    Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: buf},
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  return der
+}
+
+
+func TestEncryptDecrypt(t *testing.T) {
+  crt, key := newRecipient(t)
+  for name, algorithm := range CONTENT_ENCRYPTION_ALGORITHMS {
+    for _, size := range []int{0, 1, 15, 16, 17, 100} {
+      content := bytes.Repeat([]byte{0x42}, size)
+      der, err := Encrypt(content, crt, algorithm)
+      if err != nil {
+        t.Fatalf("%s: %s", name, err)
+      }
+      decrypted, decryptedWith, err := Decrypt(der, crt, key)
+      if err != nil {
+        t.Fatalf("%s: %s", name, err)
+      }
+      if !bytes.Equal(decrypted, content) {
+        t.Errorf("%s: the content of %d bytes differs after decryption", name, size)
+      }
+      if !decryptedWith.Equal(algorithm) {
+        t.Errorf("%s: decrypted with %s", name, decryptedWith)
+      }
+    }
+  }
+}
+
+
+// A corrupted encrypted key must fail like a content encryption key that
+// is wrong, so that the key of the recipient is not a padding oracle.
+func TestDecryptCorruptedKey(t *testing.T) {
+  crt, key := newRecipient(t)
+  der, err := Encrypt([]byte("content"), crt, OID_AES128_CBC)
+  if err != nil {
+    t.Fatal(err)
+  }
+  corrupted := modifyEnvelope(t, der, func(ed *envelopedData) {
+    ed.RecipientInfos[0].EncryptedKey[10] ^= 0xff
+  })
+  wrongKey := modifyEnvelope(t, der, func(ed *envelopedData) {
+    cek := make([]byte, 16)
+    rand.Read(cek)
+    encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, cek)
+    if err != nil {
+      t.Fatal(err)
+    }
+    ed.RecipientInfos[0].EncryptedKey = encryptedKey
+  })
+
+  // Decryption with a wrong key is random; it fails on the padding, or
+  // returns garbage when the padding happens to be valid.
+  for i := 0; i < 20; i++ {
+    for name, der := range map[string][]byte{"corrupted key": corrupted, "wrong key": wrongKey} {
+      content, _, err := Decrypt(der, crt, key)
+      if err != nil && err.Error() != "Invalid padding." {
+        t.Fatalf("%s: %s", name, err)
+      }
+      if err == nil && bytes.Equal(content, []byte("content")) {
+        t.Fatalf("%s: the content was decrypted", name)
+      }
+    }
+  }
+}
+
+
+func TestSignParse(t *testing.T) {
+  crt, key := newRecipient(t)
+  attr, err := NewAttribute(OID_ATTRIBUTE_SIGNING_TIME, time.Now().UTC())
+  if err != nil {
+    t.Fatal(err)
+  }
+  extra := Attribute{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}, Values: attr.Values}
+  for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA512} {
+    der, err := Sign([]byte("content"), crt, key, hash, []Attribute{extra})
+    if err != nil {
+      t.Fatal(err)
+    }
+    sd, err := ParseSignedData(der)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !bytes.Equal(sd.Content, []byte("content")) || sd.DigestAlgorithm != hash {
+      t.Errorf("%v: the parsed content or digest algorithm differs", hash)
+    }
+    if sd.GetAttribute(extra.Type) == nil {
+      t.Errorf("%v: the attribute is missing", hash)
+    }
+    signer, err := sd.Verify()
+    if err != nil {
+      t.Fatalf("%v: %s", hash, err)
+    }
+    if !signer.Equal(crt) {
+      t.Errorf("%v: the signer differs", hash)
+    }
+
+    // A changed content must not verify.
+    sd.Content = []byte("changed")
+    if _, err := sd.Verify(); err == nil {
+      t.Errorf("%v: the changed content was verified", hash)
+    }
+  }
+}
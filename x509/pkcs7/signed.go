@@ -0,0 +1,337 @@
+package pkcs7
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/asn1"
+  "errors"
+  "fmt"
+  "math/big"
+  "sort"
+  "time"
+)
+
+
+var (
+  OID_ATTRIBUTE_CONTENT_TYPE = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+  OID_ATTRIBUTE_MESSAGE_DIGEST = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+  OID_ATTRIBUTE_SIGNING_TIME = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+  OID_RSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+  OID_ECDSA_SHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+  OID_ECDSA_SHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+  OID_ECDSA_SHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+
+  OID_SHA1 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+  OID_SHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+  OID_SHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+  OID_SHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+
+// The digest algorithms by object identifier.
+var DIGEST_ALGORITHMS = map[string]crypto.Hash{
+  OID_SHA1.String(): crypto.SHA1,
+  OID_SHA256.String(): crypto.SHA256,
+  OID_SHA384.String(): crypto.SHA384,
+  OID_SHA512.String(): crypto.SHA512,
+}
+
+
+// An attribute with a single value, which is the DER encoding of an ASN.1
+// value.
+type Attribute struct {
+  Type asn1.ObjectIdentifier
+  Values []asn1.RawValue `asn1:"set"`
+}
+
+
+type issuerAndSerialNumber struct {
+  Issuer asn1.RawValue
+  SerialNumber *big.Int
+}
+
+
+type encapsulatedContentInfo struct {
+  ContentType asn1.ObjectIdentifier
+  Content []byte `asn1:"explicit,optional,tag:0"`
+}
+
+
+type signedDataWithContent struct {
+  Version int
+  DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+  ContentInfo encapsulatedContentInfo
+  Certificates asn1.RawValue `asn1:"optional,tag:0"`
+  CRLs asn1.RawValue `asn1:"optional,tag:1"`
+  SignerInfos []signerInfo `asn1:"set"`
+}
+
+
+type signerInfo struct {
+  Version int
+  IssuerAndSerialNumber issuerAndSerialNumber
+  DigestAlgorithm pkix.AlgorithmIdentifier
+  SignedAttributes asn1.RawValue `asn1:"optional,tag:0"`
+  SignatureAlgorithm pkix.AlgorithmIdentifier
+  Signature []byte
+  UnsignedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+
+// A parsed SignedData structure with a single signer.
+type SignedData struct {
+  ContentType asn1.ObjectIdentifier
+  Content []byte
+  Certificates []*x509.Certificate
+  DigestAlgorithm crypto.Hash
+
+  // The signed attributes of the signer.
+  Attributes []Attribute
+
+  signer signerInfo
+}
+
+
+// Return a new attribute with a single value.
+func NewAttribute(t asn1.ObjectIdentifier, value interface{}) (Attribute, error) {
+  buf, err := asn1.Marshal(value)
+  if err != nil {
+    return Attribute{}, err
+  }
+  return Attribute{Type: t, Values: []asn1.RawValue{{FullBytes: buf}}}, nil
+}
+
+
+// Parse a ContentInfo with SignedData. Only DER is supported.
+func ParseSignedData(der []byte) (*SignedData, error) {
+  ci := contentInfo{}
+  if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) > 0 {
+    return nil, errors.New("Malformed ContentInfo.")
+  }
+  if !ci.ContentType.Equal(OID_SIGNED_DATA) {
+    return nil, errors.New(fmt.Sprintf("Not a SignedData structure: %s", ci.ContentType))
+  }
+  sd := signedDataWithContent{}
+  if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+    return nil, errors.New(fmt.Sprintf("Malformed SignedData: %s", err))
+  }
+  if len(sd.SignerInfos) != 1 {
+    return nil, errors.New(fmt.Sprintf("Expected one signer, found %d.", len(sd.SignerInfos)))
+  }
+  result := &SignedData{
+    ContentType: sd.ContentInfo.ContentType,
+    Content: sd.ContentInfo.Content,
+    DigestAlgorithm: DIGEST_ALGORITHMS[sd.SignerInfos[0].DigestAlgorithm.Algorithm.String()],
+    signer: sd.SignerInfos[0],
+  }
+  if len(sd.Certificates.Bytes) > 0 {
+    certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+    if err != nil {
+      return nil, err
+    }
+    result.Certificates = certs
+  }
+  if len(result.signer.SignedAttributes.Bytes) > 0 {
+    rest := result.signer.SignedAttributes.Bytes
+    for len(rest) > 0 {
+      attr := Attribute{}
+      var err error
+      if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+        return nil, errors.New(fmt.Sprintf("Malformed signed attributes: %s", err))
+      }
+      result.Attributes = append(result.Attributes, attr)
+    }
+  }
+  return result, nil
+}
+
+
+// Return the value of the attribute with the given type, or nil if the
+// signer did not include it.
+func (self *SignedData) GetAttribute(t asn1.ObjectIdentifier) *asn1.RawValue {
+  for _, attr := range self.Attributes {
+    if attr.Type.Equal(t) && len(attr.Values) > 0 {
+      return &attr.Values[0]
+    }
+  }
+  return nil
+}
+
+
+// Return the certificate of the signer, from the certificates in the
+// structure.
+func (self *SignedData) GetSigner() (*x509.Certificate, error) {
+  id := self.signer.IssuerAndSerialNumber
+  for _, crt := range self.Certificates {
+    if bytes.Equal(crt.RawIssuer, id.Issuer.FullBytes) && crt.SerialNumber.Cmp(id.SerialNumber) == 0 {
+      return crt, nil
+    }
+  }
+  return nil, errors.New("The certificate of the signer is not included.")
+}
+
+
+// Verify the signature over the signed attributes and the digest of the
+// content, and return the certificate of the signer. The certificate itself
+// is not validated.
+func (self *SignedData) Verify() (*x509.Certificate, error) {
+  crt, err := self.GetSigner()
+  if err != nil {
+    return nil, err
+  }
+  hash := self.DigestAlgorithm
+  if hash == 0 {
+    return nil, errors.New(fmt.Sprintf("Unsupported digest algorithm: %s", self.signer.DigestAlgorithm.Algorithm))
+  }
+  signed := self.Content
+  if len(self.signer.SignedAttributes.FullBytes) > 0 {
+    digest := self.GetAttribute(OID_ATTRIBUTE_MESSAGE_DIGEST)
+    expected := []byte{}
+    if digest == nil {
+      return nil, errors.New("The messageDigest attribute is missing.")
+    }
+    if _, err := asn1.Unmarshal(digest.FullBytes, &expected); err != nil {
+      return nil, err
+    }
+    h := hash.New()
+    h.Write(self.Content)
+    if !bytes.Equal(h.Sum(nil), expected) {
+      return nil, errors.New("The digest of the content does not match.")
+    }
+    // The signature is computed over the DER encoding of the attributes as
+    // a SET OF, instead of the implicit tag.
+    signed = append([]byte{0x31}, self.signer.SignedAttributes.FullBytes[1:]...)
+  }
+  h := hash.New()
+  h.Write(signed)
+  if err := verifySignature(crt.PublicKey, hash, h.Sum(nil), self.signer.Signature); err != nil {
+    return nil, err
+  }
+  return crt, nil
+}
+
+
+// Sign the content with key and return a ContentInfo with SignedData. The
+// content type, message digest and signing time are added to the signed
+// attributes. If content is nil, the structure has no content.
+func Sign(content []byte, crt *x509.Certificate, key crypto.Signer, hash crypto.Hash, attrs []Attribute) ([]byte, error) {
+  digestAlgorithm, err := getDigestAlgorithm(hash)
+  if err != nil {
+    return nil, err
+  }
+  h := hash.New()
+  h.Write(content)
+  digest, err := NewAttribute(OID_ATTRIBUTE_MESSAGE_DIGEST, h.Sum(nil))
+  if err != nil {
+    return nil, err
+  }
+  contentType, _ := NewAttribute(OID_ATTRIBUTE_CONTENT_TYPE, OID_DATA)
+  signingTime, _ := NewAttribute(OID_ATTRIBUTE_SIGNING_TIME, time.Now().UTC())
+  attrs = append([]Attribute{contentType, digest, signingTime}, attrs...)
+
+  // SET OF is sorted by the encoding of its elements.
+  encoded := [][]byte{}
+  for _, attr := range attrs {
+    buf, err := asn1.Marshal(attr)
+    if err != nil {
+      return nil, err
+    }
+    encoded = append(encoded, buf)
+  }
+  sort.Slice(encoded, func(i, j int) bool {
+    return bytes.Compare(encoded[i], encoded[j]) < 0
+  })
+  attributes := bytes.Join(encoded, nil)
+  signed, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attributes})
+  if err != nil {
+    return nil, err
+  }
+  h = hash.New()
+  h.Write(signed)
+  signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+  if err != nil {
+    return nil, err
+  }
+  signatureAlgorithm, err := getSignatureAlgorithm(key.Public(), hash)
+  if err != nil {
+    return nil, err
+  }
+
+  sd := signedDataWithContent{
+    Version: 1,
+    DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+    ContentInfo: encapsulatedContentInfo{ContentType: OID_DATA, Content: content},
+    Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: crt.Raw},
+    SignerInfos: []signerInfo{{
+      Version: 1,
+      IssuerAndSerialNumber: issuerAndSerialNumber{asn1.RawValue{FullBytes: crt.RawIssuer}, crt.SerialNumber},
+      DigestAlgorithm: digestAlgorithm,
+      SignedAttributes: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributes},
+      SignatureAlgorithm: signatureAlgorithm,
+      Signature: signature,
+    }},
+  }
+  buf, err := asn1.Marshal(sd)
+  if err != nil {
+    return nil, err
+  }
+  return asn1.Marshal(contentInfo{
+    ContentType: OID_SIGNED_DATA,
+    Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: buf},
+  })
+}
+
+
+func getDigestAlgorithm(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+  switch hash {
+    case crypto.SHA1:
+      return pkix.AlgorithmIdentifier{Algorithm: OID_SHA1}, nil
+    case crypto.SHA256:
+      return pkix.AlgorithmIdentifier{Algorithm: OID_SHA256}, nil
+    case crypto.SHA384:
+      return pkix.AlgorithmIdentifier{Algorithm: OID_SHA384}, nil
+    case crypto.SHA512:
+      return pkix.AlgorithmIdentifier{Algorithm: OID_SHA512}, nil
+  }
+  return pkix.AlgorithmIdentifier{}, errors.New(fmt.Sprintf("Unsupported digest algorithm: %v", hash))
+}
+
+
+func getSignatureAlgorithm(pub crypto.PublicKey, hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+  switch pub.(type) {
+    case *rsa.PublicKey:
+      return pkix.AlgorithmIdentifier{Algorithm: OID_RSA, Parameters: asn1.NullRawValue}, nil
+    case *ecdsa.PublicKey:
+      switch hash {
+        case crypto.SHA256:
+          return pkix.AlgorithmIdentifier{Algorithm: OID_ECDSA_SHA256}, nil
+        case crypto.SHA384:
+          return pkix.AlgorithmIdentifier{Algorithm: OID_ECDSA_SHA384}, nil
+        case crypto.SHA512:
+          return pkix.AlgorithmIdentifier{Algorithm: OID_ECDSA_SHA512}, nil
+      }
+  }
+  return pkix.AlgorithmIdentifier{}, errors.New(fmt.Sprintf("Unsupported key type or digest: %T, %v", pub, hash))
+}
+
+
+func verifySignature(pub crypto.PublicKey, hash crypto.Hash, digest []byte, signature []byte) error {
+  switch key := pub.(type) {
+    case *rsa.PublicKey:
+      return rsa.VerifyPKCS1v15(key, hash, digest, signature)
+    case *ecdsa.PublicKey:
+      sig := struct{ R, S *big.Int }{}
+      if _, err := asn1.Unmarshal(signature, &sig); err != nil || !ecdsa.Verify(key, digest, sig.R, sig.S) {
+        return errors.New("Invalid ECDSA signature.")
+      }
+      return nil
+    default:
+      return errors.New(fmt.Sprintf("Unsupported public key type: %T", pub))
+  }
+}
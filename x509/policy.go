@@ -0,0 +1,185 @@
+package x509
+
+import (
+  "crypto/x509"
+  "fmt"
+  "net"
+  "regexp"
+  "strings"
+
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/oid"
+)
+
+
+// All violations of the policies by a CSR.
+type PolicyViolations []string
+
+
+func (self PolicyViolations) Error() string {
+  return "The CSR violates the policy of the CA:\n  - " +
+    strings.Join(self, "\n  - ")
+}
+
+
+// Check the names and subject requested by csr against each policy. All
+// violations are reported in a single PolicyViolations error.
+func CheckPolicy(csr *x509.CertificateRequest, policies ...*dto.X509Policy) error {
+  violations := PolicyViolations{}
+  for _, p := range policies {
+    violations = append(violations, checkPolicy(csr, p)...)
+  }
+  if len(violations) > 0 {
+    return violations
+  }
+  return nil
+}
+
+
+func checkPolicy(csr *x509.CertificateRequest, policy *dto.X509Policy) []string {
+  violations := []string{}
+  add := func(format string, args ...interface{}) {
+    violations = append(violations, fmt.Sprintf(format, args...))
+  }
+
+  total := len(csr.DNSNames) + len(csr.EmailAddresses) + len(csr.IPAddresses) + len(csr.URIs)
+  if policy.MaxNames > 0 && total > policy.MaxNames {
+    add("%d Subject Alternative Names are requested; the maximum is %d",
+      total, policy.MaxNames)
+  }
+
+  for _, name := range csr.DNSNames {
+    if policy.DNS.ForbidWildcards && strings.Contains(name, "*") {
+      add("DNS name %s: wildcards are not allowed", name)
+      continue
+    }
+    if !allowed(&policy.DNS.X509NamePolicy, name, matchDomain) {
+      add("DNS name %s is not allowed", name)
+    }
+  }
+
+  // Clients may still match the common name as a host name, so a common
+  // name that looks like one is subject to the same policy.
+  cn := csr.Subject.CommonName
+  switch {
+    case net.ParseIP(cn) != nil:
+      if !allowed(&policy.IP, cn, matchIP) {
+        add("common name %s is not an allowed IP address", cn)
+      }
+    case hostnamePattern.MatchString(cn):
+      if policy.DNS.ForbidWildcards && strings.Contains(cn, "*") {
+        add("common name %s: wildcards are not allowed", cn)
+      } else if !allowed(&policy.DNS.X509NamePolicy, cn, matchDomain) {
+        add("common name %s is not an allowed DNS name", cn)
+      }
+  }
+  for _, address := range csr.EmailAddresses {
+    if !allowed(&policy.Email, address, matchEmail) {
+      add("email address %s is not allowed", address)
+    }
+  }
+  for _, ip := range csr.IPAddresses {
+    if !allowed(&policy.IP, ip.String(), matchIP) {
+      add("IP address %s is not allowed", ip)
+    }
+  }
+  for _, uri := range csr.URIs {
+    if !allowed(&policy.URI, uri.String(), matchURI) {
+      add("URI %s is not allowed", uri)
+    }
+  }
+
+  present := map[string]bool{}
+  for _, atv := range csr.Subject.Names {
+    present[atv.Type.String()] = true
+  }
+  for _, name := range policy.Subject.Require {
+    t, err := oid.LookupAttribute(name)
+    if err != nil {
+      add("%s", err)
+    } else if !present[t.String()] {
+      add("the subject must contain %s", name)
+    }
+  }
+  for _, name := range policy.Subject.Forbid {
+    t, err := oid.LookupAttribute(name)
+    if err != nil {
+      add("%s", err)
+    } else if present[t.String()] {
+      add("the subject must not contain %s", name)
+    }
+  }
+  return violations
+}
+
+
+// Host names with at least two labels, optionally with a wildcard label.
+var hostnamePattern = regexp.MustCompile(`^(\*\.)?([A-Za-z0-9_-]+\.)+[A-Za-z0-9_-]+\.?$`)
+
+
+func allowed(policy *dto.X509NamePolicy, name string, match func(string, string) bool) bool {
+  for _, pattern := range policy.Deny {
+    if match(name, pattern) {
+      return false
+    }
+  }
+  if len(policy.Allow) == 0 {
+    return true
+  }
+  for _, pattern := range policy.Allow {
+    if match(name, pattern) {
+      return true
+    }
+  }
+  return false
+}
+
+
+// Patterns are exact names, "*.example.com" (exactly one label below
+// example.com) or ".example.com" (any name below example.com).
+func matchDomain(name string, pattern string) bool {
+  name = strings.ToLower(strings.TrimSuffix(name, "."))
+  pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+  switch {
+    case strings.HasPrefix(pattern, "*."):
+      i := strings.Index(name, ".")
+      return i > 0 && name[i:] == pattern[1:]
+    case strings.HasPrefix(pattern, "."):
+      return strings.HasSuffix(name, pattern)
+    default:
+      return name == pattern
+  }
+}
+
+
+// Patterns are addresses, domains or domain suffixes starting with a dot.
+func matchEmail(address string, pattern string) bool {
+  if strings.Contains(pattern, "@") {
+    return strings.EqualFold(address, pattern)
+  }
+  i := strings.LastIndex(address, "@")
+  if i < 0 {
+    return false
+  }
+  return matchDomain(address[i+1:], pattern)
+}
+
+
+func matchIP(address string, pattern string) bool {
+  ip := net.ParseIP(address)
+  if !strings.Contains(pattern, "/") {
+    return ip.Equal(net.ParseIP(pattern))
+  }
+  _, network, err := net.ParseCIDR(pattern)
+  return err == nil && network.Contains(ip)
+}
+
+
+// Patterns are schemes, or prefixes if they contain "://".
+func matchURI(uri string, pattern string) bool {
+  if strings.Contains(pattern, "://") {
+    return strings.HasPrefix(uri, pattern)
+  }
+  i := strings.Index(uri, ":")
+  return i > 0 && strings.EqualFold(uri[:i], pattern)
+}
@@ -0,0 +1,196 @@
+package x509
+
+import (
+  "bytes"
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "log"
+  "net"
+  "net/url"
+  "os"
+  "strings"
+  "time"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/inventory"
+)
+
+
+// Issue a new certificate with the subject, Subject Alternative Names and
+// profile of an existing certificate. The public key is taken from a CSR on
+// stdin, or from the existing certificate if there is none.
+func RenewCertificate(buf []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var certFile string
+  var intConf string
+  var profileName string
+
+  parser := flag.NewFlagSet("renew", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&certFile, "cert", "",
+    "specifies the certificate (PEM) that is renewed.")
+  parser.StringVar(&intConf, "intermediate", "",
+    "specifies the intermediate (CA) configuration file.")
+  parser.StringVar(&profileName, "profile", "",
+    "specifies the profile, if the inventory does not record it.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  if certFile == "" {
+    log.Fatal("The -cert parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+
+  certificates, err := ReadCertificates(certFile)
+  if err != nil { log.Fatal(err) }
+  previous := certificates[0]
+
+  var csr *x509.CertificateRequest
+  if len(buf) > 0 {
+    block, _ := pem.Decode(buf)
+    if block == nil || block.Type != "CERTIFICATE REQUEST" {
+      log.Fatal("failed to decode PEM block containing the CSR")
+    }
+    csr, err = x509.ParseCertificateRequest(block.Bytes)
+    if err == nil {
+      err = csr.CheckSignature()
+    }
+    if err != nil { log.Fatal(err) }
+  }
+
+  aia := opts
+  if intConf != "" {
+    aia = dto.X509ConfigurationDTO{}
+    err = aia.Load(intConf, nil)
+    if err != nil { log.Fatal(err) }
+  }
+
+  der, err := IssueRenewal(backend, &opts, previous, csr, profileName, &aia)
+  if err != nil { log.Fatal(err) }
+  if err := pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+    log.Fatal(err)
+  }
+}
+
+
+// Renew a certificate that was issued by the CA configured in opts. If csr
+// is nil, the public key of the previous certificate is certified again;
+// otherwise the key of the CSR replaces it. If profileName is empty, the
+// profile that the inventory recorded for the previous certificate is used,
+// or the default profile of the CA.
+func IssueRenewal(backend backends.Backend, opts *dto.X509ConfigurationDTO, previous *x509.Certificate, csr *x509.CertificateRequest, profileName string, aia *dto.X509ConfigurationDTO) ([]byte, error) {
+  if opts.Approval.IsRequired() {
+    return nil, errors.New(fmt.Sprintf("The CA requires the approval of %d operators; use x509 submit.",
+      opts.Approval.Quorum))
+  }
+
+  // A self-signed certificate is renewed by signing it again with its own
+  // key; other certificates must have been issued by the CA.
+  selfSigned := bytes.Equal(previous.RawIssuer, previous.RawSubject) &&
+    previous.CheckSignatureFrom(previous) == nil
+  if !selfSigned {
+    issuer, err := opts.GetSignerCertificate()
+    if err != nil {
+      return nil, err
+    }
+    if err := previous.CheckSignatureFrom(issuer); err != nil {
+      return nil, errors.New(fmt.Sprintf("The certificate was not issued by %s: %s",
+        issuer.Subject, err))
+    }
+  } else if csr != nil {
+    return nil, errors.New("A self-signed certificate can not be renewed with another key.")
+  }
+
+  serial := fmt.Sprintf("%x", previous.SerialNumber)
+  if opts.Inventory != "" {
+    issued, revoked, err := inventory.Open(opts.Inventory).Find(previous.Issuer.String(), serial)
+    if err != nil {
+      return nil, err
+    }
+    if revoked != nil {
+      return nil, errors.New(fmt.Sprintf("Certificate %s was revoked at %s.", serial,
+        revoked.Time.Format(time.RFC3339)))
+    }
+    if profileName == "" && issued != nil {
+      profileName = issued.Profile
+    }
+  }
+  profile, err := opts.GetProfile(profileName)
+  if err != nil {
+    return nil, err
+  }
+  return issueCertificate(backend, opts, renewalRequest(previous, csr), profile,
+    selfSigned, aia, previous)
+}
+
+
+// Return a request with the subject and the Subject Alternative Names of the
+// previous certificate, and the public key of csr or, if csr is nil, of the
+// previous certificate.
+func renewalRequest(previous *x509.Certificate, csr *x509.CertificateRequest) *x509.CertificateRequest {
+  req := &x509.CertificateRequest{
+    RawSubject: previous.RawSubject,
+    Subject: previous.Subject,
+    DNSNames: previous.DNSNames,
+    EmailAddresses: previous.EmailAddresses,
+    IPAddresses: previous.IPAddresses,
+    URIs: previous.URIs,
+    PublicKeyAlgorithm: previous.PublicKeyAlgorithm,
+    PublicKey: previous.PublicKey,
+  }
+  if csr != nil {
+    req.PublicKeyAlgorithm = csr.PublicKeyAlgorithm
+    req.PublicKey = csr.PublicKey
+  }
+  return req
+}
+
+
+// Return an error unless csr requests the subject and the Subject
+// Alternative Names of crt, the current certificate of a client that
+// re-enrolls with EST or renews with SCEP.
+func CheckReenrollment(crt *x509.Certificate, csr *x509.CertificateRequest) error {
+  if !bytes.Equal(crt.RawSubject, csr.RawSubject) {
+    return errors.New("The subject of the CSR differs from the current certificate.")
+  }
+  current := alternativeNames(crt.DNSNames, crt.EmailAddresses, crt.IPAddresses, crt.URIs)
+  requested := alternativeNames(csr.DNSNames, csr.EmailAddresses, csr.IPAddresses, csr.URIs)
+  equal := len(current) == len(requested)
+  for name := range current {
+    equal = equal && requested[name]
+  }
+  if !equal {
+    return errors.New("The Subject Alternative Names of the CSR differ from the current certificate.")
+  }
+  return nil
+}
+
+
+// Return the Subject Alternative Names as a set of normalized names, so
+// that names that differ only in order or in the case of a DNS name are
+// equal.
+func alternativeNames(dns []string, emails []string, ips []net.IP, uris []*url.URL) map[string]bool {
+  names := map[string]bool{}
+  for _, name := range dns {
+    names["dns:" + strings.ToLower(name)] = true
+  }
+  for _, address := range emails {
+    names["email:" + address] = true
+  }
+  for _, ip := range ips {
+    names["ip:" + ip.String()] = true
+  }
+  for _, uri := range uris {
+    names["uri:" + uri.String()] = true
+  }
+  return names
+}
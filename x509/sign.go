@@ -1,15 +1,20 @@
 package x509
 
 import (
-  "crypto/rand"
+  "bytes"
   "crypto/x509"
   "encoding/pem"
+  "errors"
   "flag"
+  "fmt"
   "log"
   "os"
 
   "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/offline"
+  "github.com/cochiseruhulessin/cloud-pki/preview"
   "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/inventory"
 )
 
 
@@ -20,8 +25,12 @@ func SignCertificate(buf []byte, args []string, backend backends.Backend) {
   var constraintsConf string
   var csr *x509.CertificateRequest
   var intConf string
-  var issuer *x509.Certificate
   var err error
+  var asJSON bool
+  var diffFile string
+  var dryRun bool
+  var prepare bool
+  var profileName string
   var selfSigned bool
 
   parser := flag.NewFlagSet("sign", flag.ExitOnError)
@@ -33,6 +42,16 @@ func SignCertificate(buf []byte, args []string, backend backends.Backend) {
     "indicates that the certificate will be self-signed.")
   parser.StringVar(&constraintsConf, "p", "",
     "specifies a configuration file with constraints.")
+  parser.StringVar(&profileName, "profile", "",
+    "specifies the profile of the CA that is used to issue the certificate.")
+  parser.BoolVar(&prepare, "prepare", false,
+    "writes a bundle to be signed with sign-digest instead of signing the certificate.")
+  parser.BoolVar(&dryRun, "dry-run", false,
+    "describes the certificate, signed with an ephemeral key, instead of signing it.")
+  parser.BoolVar(&asJSON, "json", false,
+    "writes the description of -dry-run as JSON.")
+  parser.StringVar(&diffFile, "diff", "",
+    "compares the certificate of -dry-run with a previous certificate (PEM).")
   parser.Parse(args)
 
   if caConf == "" {
@@ -50,73 +69,255 @@ func SignCertificate(buf []byte, args []string, backend backends.Backend) {
     log.Fatal("failed to decode PEM block containing public key")
   }
 
-  constraints := opts.Constraints
+  profile, err := opts.GetProfile(profileName)
+  if err != nil { log.Fatal(err) }
   if constraintsConf != "" {
-    err = constraints.Load(constraintsConf, nil)
+    err = profile.CertificateConstraints.Load(constraintsConf, nil)
     if err != nil { log.Fatal(err) }
   }
 
   csr, err = x509.ParseCertificateRequest(block.Bytes)
   if err != nil { log.Fatal(err) }
 
-  if !selfSigned {
-    caIssuer, err := opts.GetSignerCertificate()
+  // Self-signed certificates and intermediate CAs add their own
+  // Authority Information Access extension, end-certificates inherit
+  // from the issuer. The -intermediate parameter is used to specify
+  // these values for an intermediate CA.
+  aia := opts
+  if intConf != "" {
+    aia = dto.X509ConfigurationDTO{}
+    err = aia.Load(intConf, nil)
+    if err != nil { log.Fatal(err) }
+  }
+
+  if dryRun {
+    previewed, err := PreviewCertificate(&opts, csr, profile, selfSigned, &aia)
+    if err != nil { log.Fatal(err) }
+    err = writeDryRun(previewed, diffFile, asJSON)
     if err != nil { log.Fatal(err) }
-    issuer = caIssuer
+    return
+  }
+  if prepare {
+    bundle, err := PrepareCertificate(&opts, csr, profile, selfSigned, &aia)
+    if err != nil { log.Fatal(err) }
+    os.Stdout.Write(bundle.Marshal())
+    return
+  }
+  der, err := IssueCertificate(backend, &opts, csr, profile, selfSigned, &aia)
+  if err != nil {
+    log.Fatal(err)
+  }
+  if err := pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der});
+  err != nil {
+    log.Fatalf("Failed to write data to cert.pem: %v", err)
+  }
+
+  return
+}
+
+
+// Issue a certificate for csr with the CA configured in opts, applying the
+// given profile. The Authority Information Access and CRL Distribution
+// Points extensions are taken from aia.
+func IssueCertificate(backend backends.Backend, opts *dto.X509ConfigurationDTO, csr *x509.CertificateRequest, profile *dto.X509Profile, selfSigned bool, aia *dto.X509ConfigurationDTO) ([]byte, error) {
+  if opts.Approval.IsRequired() {
+    return nil, errors.New(fmt.Sprintf("The CA requires the approval of %d operators; use x509 submit.",
+      opts.Approval.Quorum))
+  }
+  return issueCertificate(backend, opts, csr, profile, selfSigned, aia, nil)
+}
+
+
+// Issue a certificate for csr; renews is the certificate that it replaces,
+// if any, and is recorded in the inventory.
+func issueCertificate(backend backends.Backend, opts *dto.X509ConfigurationDTO, csr *x509.CertificateRequest, profile *dto.X509Profile, selfSigned bool, aia *dto.X509ConfigurationDTO, renews *x509.Certificate) ([]byte, error) {
+  builder, crt, err := newCertificate(backend, opts, csr, profile, selfSigned, aia)
+  if err != nil {
+    return nil, err
+  }
+  der, err := builder.Sign(crt, csr.PublicKey)
+  if err != nil {
+    return nil, err
+  }
+  if opts.Inventory != "" {
+    if err = recordIssued(opts.Inventory, der, profile.Name, renews); err != nil {
+      return nil, err
+    }
+  }
+  return der, nil
+}
+
+
+// Return a bundle with the TBSCertificate of the certificate that the CA
+// would issue for csr, so that it can be signed on a host with access to
+// the key of the CA.
+func PrepareCertificate(opts *dto.X509ConfigurationDTO, csr *x509.CertificateRequest, profile *dto.X509Profile, selfSigned bool, aia *dto.X509ConfigurationDTO) (*offline.Bundle, error) {
+  if opts.Approval.IsRequired() {
+    return nil, errors.New(fmt.Sprintf("The CA requires the approval of %d operators; use x509 submit.",
+      opts.Approval.Quorum))
+  }
+  previewed, err := PreviewCertificate(opts, csr, profile, selfSigned, aia)
+  if err != nil {
+    return nil, err
+  }
+  return offline.NewX509Bundle(previewed)
+}
+
+
+// Return the certificate that the CA would issue for csr, signed with an
+// ephemeral key instead of the key of the CA. The public key of the CA is
+// taken from its certificate, or from the CSR if the certificate is
+// self-signed, so the backend is not used.
+func PreviewCertificate(opts *dto.X509ConfigurationDTO, csr *x509.CertificateRequest, profile *dto.X509Profile, selfSigned bool, aia *dto.X509ConfigurationDTO) (*x509.Certificate, error) {
+  builder, crt, err := newCertificate(nil, opts, csr, profile, selfSigned, aia)
+  if err != nil {
+    return nil, err
+  }
+  caKey := builder.issuer.PublicKey
+  if selfSigned {
+    caKey = csr.PublicKey
+  }
+  if err = builder.checkLint(crt, csr.PublicKey, caKey); err != nil {
+    return nil, err
+  }
+  der, err := builder.Preview(crt, csr.PublicKey, caKey)
+  if err != nil {
+    return nil, err
+  }
+  return x509.ParseCertificate(der)
+}
+
+
+// Combine the TBSCertificate of a signed bundle with its signature, and
+// verify the signature with the public key of the CA.
+func AssembleCertificate(opts *dto.X509ConfigurationDTO, bundle *offline.Bundle) ([]byte, error) {
+  der, err := bundle.GetCertificate()
+  if err != nil {
+    return nil, err
+  }
+  crt, err := x509.ParseCertificate(der)
+  if err != nil {
+    return nil, err
+  }
+
+  // Self-signed certificates are verified with their own key; the CA may
+  // not have a certificate yet.
+  keys := []*x509.Certificate{}
+  if issuer, err := opts.GetSignerCertificate(); err == nil {
+    keys = append(keys, issuer)
+  }
+  if bytes.Equal(crt.RawIssuer, crt.RawSubject) {
+    keys = append(keys, crt)
+  }
+  err = errors.New(fmt.Sprintf("No certificate of the issuer %s.", crt.Issuer))
+  for _, key := range keys {
+    if err = key.CheckSignature(crt.SignatureAlgorithm, crt.RawTBSCertificate, crt.Signature); err == nil {
+      break
+    }
+  }
+  if err != nil {
+    return nil, errors.New(fmt.Sprintf("Invalid signature: %s", err))
+  }
+  if opts.Inventory != "" {
+    if err = recordIssued(opts.Inventory, der, "", nil); err != nil {
+      return nil, err
+    }
+  }
+  return der, nil
+}
+
+
+// Check csr against the policies and build the certificate that the CA
+// issues for it.
+func newCertificate(backend backends.Backend, opts *dto.X509ConfigurationDTO, csr *x509.CertificateRequest, profile *dto.X509Profile, selfSigned bool, aia *dto.X509ConfigurationDTO) (*CertificateBuilder, *x509.Certificate, error) {
+  var issuer *x509.Certificate
+  var err error
+
+  if err = CheckPolicy(csr, &opts.Policy, &profile.Policy); err != nil {
+    return nil, nil, err
+  }
+  if !selfSigned {
+    issuer, err = opts.GetSignerCertificate()
+    if err != nil {
+      return nil, nil, err
+    }
   } else {
     issuer = &x509.Certificate{}
   }
 
-  builder := CertificateBuilder{
+  builder := &CertificateBuilder{
     backend: backend,
-    opts: opts,
+    opts: *opts,
     issuer: issuer,
     selfSigned: selfSigned,
-    constraints: constraints,
+    profile: *profile,
   }
 
   crt, err := builder.FromCSR(csr)
   if err != nil {
-    log.Fatal(err)
+    return nil, nil, err
   }
+  builder.SetAuthorityInformation(crt, aia)
+  return builder, crt, nil
+}
 
-  // Self-signed certificates and intermediate CAs add their own
-  // Authority Information Access extension, end-certificates inherit
-  // from the issuer. The -intermediate parameter is used to specify
-  // these values for an intermediate CA.
-  aia := opts
-  if intConf != "" {
-    aia = dto.X509ConfigurationDTO{}
-    err = aia.Load(intConf, nil)
-    if err != nil { log.Fatal(err) }
+
+// Write the description of a certificate that would be issued, and the
+// differences with a previous certificate if diffFile is not empty.
+func writeDryRun(crt *x509.Certificate, diffFile string, asJSON bool) error {
+  var previous preview.Description
+  if diffFile != "" {
+    certificates, err := ReadCertificates(diffFile)
+    if err != nil {
+      return err
+    }
+    previous = DescribeCertificate(certificates[0])
   }
+  return preview.NewReport(DescribeCertificate(crt), previous).Write(os.Stdout, asJSON, diffFile)
+}
+
+
+// Combine a bundle that was signed with sign-digest with its
+// TBSCertificate and write the certificate to stdout.
+func AssembleBundle(buf []byte, args []string, backend backends.Backend) {
+  var caConf string
 
-  if len(aia.CRLDistribution.URLS) > 0 {
-    crt.CRLDistributionPoints = aia.CRLDistribution.URLS
+  parser := flag.NewFlagSet("assemble", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
   }
-  if len(aia.AuthorityInfoAccess.URLS) > 0 {
-    crt.IssuingCertificateURL = aia.AuthorityInfoAccess.URLS
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+  if len(buf) == 0 && parser.NArg() == 0 {
+    log.Fatal("Provide the signed bundle through stdin.")
   }
-  if len(aia.AuthorityInfoAccess.OCSP) > 0 {
-    crt.OCSPServer = aia.AuthorityInfoAccess.OCSP
+  bundle, err := offline.Load(parser.Arg(0), buf)
+  if err != nil { log.Fatal(err) }
+  der, err := AssembleCertificate(&opts, bundle)
+  if err != nil { log.Fatal(err) }
+  if err := pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+    log.Fatal(err)
   }
+}
 
-  // If we are self-signing, then the issuer is also the certificate
-  // to be signed.
-  if selfSigned {
-    issuer = crt
-  }
 
-  signer := backend.GetSigner(opts.Signer.KeyID)
-  der, err := x509.CreateCertificate(rand.Reader, crt, issuer,
-    csr.PublicKey, signer)
+// Append an issued certificate to the inventory of the CA, with the name of
+// its profile and the certificate that it renews, if known. The certificate
+// is not returned if it can not be recorded.
+func recordIssued(path string, der []byte, profile string, renews *x509.Certificate) error {
+  crt, err := x509.ParseCertificate(der)
   if err != nil {
-    log.Fatal(err)
+    return err
   }
-  if err := pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: der});
-  err != nil {
-    log.Fatalf("Failed to write data to cert.pem: %v", err)
+  record := inventory.NewIssuedRecord(crt)
+  record.Profile = profile
+  if renews != nil {
+    record.Renews = fmt.Sprintf("%x", renews.SerialNumber)
   }
-
-  return
+  return inventory.Open(path).Append(record)
 }
@@ -0,0 +1,197 @@
+package x509
+
+import (
+  "bytes"
+  "crypto"
+  "crypto/rand"
+  "crypto/x509"
+  "errors"
+  "flag"
+  "fmt"
+  "log"
+  "os"
+  "time"
+
+  "golang.org/x/crypto/ssh"
+
+  "github.com/cochiseruhulessin/cloud-pki/audit"
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/offline"
+  sshca "github.com/cochiseruhulessin/cloud-pki/ssh"
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/lint"
+)
+
+
+// Sign a bundle from stdin (or the file given as the first positional
+// argument) with the key of the CA and write the signed bundle to stdout.
+// The bundle is not trusted: the certificate is checked against the
+// configuration of the CA on this host, as if it were issued here.
+func HandleSignDigest(stdin []byte, args []string, backend backends.Backend) {
+  var caConf string
+  var profileName string
+  var selfSigned bool
+
+  parser := flag.NewFlagSet("sign-digest", flag.ExitOnError)
+  parser.StringVar(&caConf, "ca", "",
+    "specifies the Certificate Authority (CA) configuration file.")
+  parser.StringVar(&profileName, "profile", "",
+    "specifies the X.509 or SSH profile of the CA that the certificate must satisfy.")
+  parser.BoolVar(&selfSigned, "selfsigned", false,
+    "indicates that the X.509 certificate is self-signed by the key of the CA.")
+  parser.Parse(args)
+
+  if caConf == "" {
+    log.Fatal("The -ca parameter is mandatory.")
+  }
+  opts := dto.X509ConfigurationDTO{}
+  err := opts.Load(caConf, nil)
+  if err != nil { log.Fatal(err) }
+  if opts.Approval.IsRequired() {
+    log.Fatalf("The CA requires the approval of %d operators; use x509 submit.",
+      opts.Approval.Quorum)
+  }
+  if len(stdin) == 0 && parser.NArg() == 0 {
+    log.Fatal("Provide the bundle through stdin.")
+  }
+  bundle, err := offline.Load(parser.Arg(0), stdin)
+  if err != nil { log.Fatal(err) }
+  if len(bundle.Signature) > 0 {
+    log.Fatal("The bundle is already signed.")
+  }
+  if _, _, err := bundle.GetSigningInput(); err != nil { log.Fatal(err) }
+
+  signer := backend.GetSigner(opts.Signer.KeyID)
+  var record *audit.Record
+  switch bundle.Type {
+    case offline.TYPE_X509_CERTIFICATE:
+      record, err = checkX509Bundle(bundle, &opts, profileName, selfSigned, signer.Public())
+    case offline.TYPE_SSH_CERTIFICATE:
+      record, err = checkSecureShellBundle(bundle, &opts, profileName, backend)
+    default:
+      err = errors.New(fmt.Sprintf("Unknown bundle type: %s", bundle.Type))
+  }
+  if err != nil { log.Fatal(err) }
+  log.Printf("Signing %s %s for %s (issuer: %s)", bundle.Type, record.Serial, record.Subject,
+    record.Issuer)
+  if err := bundle.Sign(rand.Reader, signer); err != nil { log.Fatal(err) }
+  if opts.Audit != "" {
+    if err := audit.Open(opts.Audit).Append(record); err != nil { log.Fatal(err) }
+  }
+  os.Stdout.Write(bundle.Marshal())
+}
+
+
+// Check the certificate of a bundle as x509 sign would before it signs:
+// the issuer must be the CA, or the certificate must be self-signed with
+// the key of the CA, and the certificate must satisfy the constraints of
+// the issuer, the policies and validity of the profile, and the lint rules.
+// Return the audit record of its signature.
+func checkX509Bundle(bundle *offline.Bundle, opts *dto.X509ConfigurationDTO, profileName string, selfSigned bool, pub crypto.PublicKey) (*audit.Record, error) {
+  der, err := bundle.GetCertificate()
+  if err != nil {
+    return nil, err
+  }
+  crt, err := x509.ParseCertificate(der)
+  if err != nil {
+    return nil, err
+  }
+  profile, err := opts.GetProfile(profileName)
+  if err != nil {
+    return nil, err
+  }
+
+  if selfSigned {
+    if !bytes.Equal(crt.RawIssuer, crt.RawSubject) {
+      return nil, errors.New(fmt.Sprintf("The certificate is issued by %s, not self-signed.",
+        crt.Issuer))
+    }
+    if !samePublicKey(crt.PublicKey, pub) {
+      return nil, errors.New("The certificate does not contain the public key of the CA.")
+    }
+  } else {
+    issuer, err := opts.GetSignerCertificate()
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf(
+        "Can not load the certificate of the CA (%s); use -selfsigned for a self-signed certificate.", err))
+    }
+    if !bytes.Equal(issuer.RawSubject, crt.RawIssuer) {
+      return nil, errors.New(fmt.Sprintf("The certificate is issued by %s, not by %s.",
+        crt.Issuer, issuer.Subject))
+    }
+    if !samePublicKey(issuer.PublicKey, pub) {
+      return nil, errors.New("The certificate of the CA does not contain the public key of its signer.")
+    }
+    if err := CheckIssuer(issuer, crt, time.Now()); err != nil {
+      return nil, err
+    }
+    if crt.NotAfter.After(issuer.NotAfter) || crt.NotBefore.Before(issuer.NotBefore) {
+      return nil, errors.New("The validity period of the certificate is outside that of its issuer.")
+    }
+  }
+
+  if crt.IsCA && !profile.CA.Issuer {
+    return nil, errors.New("The profile does not issue CA certificates.")
+  }
+  if err := profile.CheckMaxValidity(crt); err != nil {
+    return nil, err
+  }
+  if err := CheckPolicy(renewalRequest(crt, nil), &opts.Policy, &profile.Policy); err != nil {
+    return nil, err
+  }
+  results, err := lint.Run(crt, opts.Lint.GetSeverities(&profile.Lint))
+  if err != nil {
+    return nil, err
+  }
+  if err := reportLint(results); err != nil {
+    return nil, err
+  }
+
+  record := audit.NewRecord(audit.OPERATION_X509_CERTIFICATE, opts.Signer.KeyID,
+    crt.RawTBSCertificate)
+  record.Issuer = crt.Issuer.String()
+  record.Subject = crt.Subject.String()
+  record.Serial = fmt.Sprintf("%x", crt.SerialNumber)
+  return record, nil
+}
+
+
+// Check the SSH certificate of a bundle as ssh sign would before it signs:
+// it must name the key of the CA as its signer and satisfy the SSH profile.
+// Return the audit record of its signature.
+func checkSecureShellBundle(bundle *offline.Bundle, opts *dto.X509ConfigurationDTO, profileName string, backend backends.Backend) (*audit.Record, error) {
+  crt, err := bundle.GetSecureShellCertificate()
+  if err != nil {
+    return nil, err
+  }
+  builder, err := sshca.NewCertificateBuilder(backend, opts, profileName)
+  if err != nil {
+    return nil, err
+  }
+  key, err := ssh.NewPublicKey(backend.GetSigner(opts.Signer.KeyID).Public())
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(key.Marshal(), crt.SignatureKey.Marshal()) {
+    return nil, errors.New(fmt.Sprintf("The certificate is signed by %s, not by %s.",
+      ssh.FingerprintSHA256(crt.SignatureKey), ssh.FingerprintSHA256(key)))
+  }
+  if err := builder.Check(crt); err != nil {
+    return nil, err
+  }
+  record := audit.NewRecord(audit.OPERATION_SSH_CERTIFICATE, opts.Signer.KeyID, bundle.TBS)
+  record.Issuer = ssh.FingerprintSHA256(crt.SignatureKey)
+  record.Subject = crt.KeyId
+  record.Serial = fmt.Sprintf("%d", crt.Serial)
+  return record, nil
+}
+
+
+func samePublicKey(a crypto.PublicKey, b crypto.PublicKey) bool {
+  derA, err := x509.MarshalPKIXPublicKey(a)
+  if err != nil {
+    return false
+  }
+  derB, err := x509.MarshalPKIXPublicKey(b)
+  return err == nil && bytes.Equal(derA, derB)
+}
@@ -0,0 +1,83 @@
+package x509
+
+import (
+  "bytes"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/asn1"
+  "errors"
+  "fmt"
+  "strings"
+  "text/template"
+
+  "github.com/cochiseruhulessin/cloud-pki/x509/dto"
+  "github.com/cochiseruhulessin/cloud-pki/x509/oid"
+)
+
+
+// Return the DER-encoded subject of the certificate that is issued for csr.
+// Without a template, the subject of the CSR is used as-is.
+func RenderSubject(t *dto.X509SubjectTemplate, csr *x509.CertificateRequest) ([]byte, error) {
+  if t == nil {
+    return csr.RawSubject, nil
+  }
+
+  subject := pkix.RDNSequence{}
+  if !t.Replace && len(csr.RawSubject) > 0 {
+    if _, err := asn1.Unmarshal(csr.RawSubject, &subject); err != nil {
+      return nil, err
+    }
+  }
+
+  funcs := template.FuncMap{
+    "lower": strings.ToLower,
+    "upper": strings.ToUpper,
+  }
+  for _, item := range t.Attributes {
+    name := fmt.Sprint(item.Key)
+    attributeType, err := oid.LookupAttribute(name)
+    if err != nil {
+      return nil, err
+    }
+    tpl, err := template.New(name).Funcs(funcs).Parse(fmt.Sprint(item.Value))
+    if err != nil {
+      return nil, errors.New(fmt.Sprintf("Invalid template for %s: %s", name, err))
+    }
+    b := &bytes.Buffer{}
+    if err = tpl.Execute(b, csr); err != nil {
+      return nil, errors.New(fmt.Sprintf("Can not render %s: %s", name, err))
+    }
+    subject = setAttribute(subject, attributeType, strings.TrimSpace(b.String()))
+  }
+  if len(subject) == 0 {
+    return nil, errors.New("The subject template yields an empty subject.")
+  }
+  return asn1.Marshal(subject)
+}
+
+
+// Replace the first attribute of the given type and remove the others, or
+// append it if the subject does not have it. An empty value removes all
+// attributes of the type.
+func setAttribute(subject pkix.RDNSequence, t asn1.ObjectIdentifier, value string) pkix.RDNSequence {
+  result := pkix.RDNSequence{}
+  found := false
+  for _, rdn := range subject {
+    set := []pkix.AttributeTypeAndValue{}
+    for _, atv := range rdn {
+      if !atv.Type.Equal(t) {
+        set = append(set, atv)
+      } else if !found && value != "" {
+        set = append(set, pkix.AttributeTypeAndValue{Type: t, Value: value})
+        found = true
+      }
+    }
+    if len(set) > 0 {
+      result = append(result, set)
+    }
+  }
+  if !found && value != "" {
+    result = append(result, []pkix.AttributeTypeAndValue{{Type: t, Value: value}})
+  }
+  return result
+}
@@ -1,17 +1,19 @@
 package x509
 
 import (
-  "crypto/rsa"
+  "crypto"
   "crypto/sha1"
+  "crypto/x509"
+  "crypto/x509/pkix"
   "encoding/asn1"
   "crypto/rand"
   "math/big"
 )
 
 
-type pkcs1PublicKey struct {
-	N *big.Int
-	E int
+type subjectPublicKeyInfo struct {
+  Algorithm pkix.AlgorithmIdentifier
+  PublicKey asn1.BitString
 }
 
 
@@ -24,15 +26,17 @@ func GenerateX509Serial() (*big.Int, error) {
 }
 
 
-func GetPublicKeyIdentifier(key *rsa.PublicKey) ([]byte, error) {
-  var err error
-  buf, err := asn1.Marshal(pkcs1PublicKey{
-    N: key.N,
-    E: key.E,
-  })
+// Return the key identifier of a public key: the SHA-1 hash of the
+// subjectPublicKey BIT STRING (RFC 5280, section 4.2.1.2, method 1).
+func GetPublicKeyIdentifier(key crypto.PublicKey) ([]byte, error) {
+  der, err := x509.MarshalPKIXPublicKey(key)
   if err != nil {
     return nil, err
   }
-  h := sha1.Sum(buf)
+  info := subjectPublicKeyInfo{}
+  if _, err = asn1.Unmarshal(der, &info); err != nil {
+    return nil, err
+  }
+  h := sha1.Sum(info.PublicKey.Bytes)
   return h[:], nil
 }
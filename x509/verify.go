@@ -0,0 +1,447 @@
+package x509
+
+import (
+  "bytes"
+  "crypto/x509"
+  "crypto/x509/pkix"
+  "encoding/asn1"
+  "encoding/json"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "log"
+  "os"
+  "strings"
+  "time"
+
+  "golang.org/x/crypto/ocsp"
+
+  "github.com/cochiseruhulessin/cloud-pki/backends"
+  "github.com/cochiseruhulessin/cloud-pki/x509/inventory"
+  "github.com/cochiseruhulessin/cloud-pki/x509/oid"
+)
+
+
+var oidAnyPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+
+
+// The result of the verification of a certificate chain, ordered from the
+// leaf to the root.
+type VerificationReport struct {
+  Valid bool `json:"valid"`
+  Error string `json:"error,omitempty"`
+  Chain []CertificateReport `json:"chain"`
+}
+
+
+type CertificateReport struct {
+  Subject string `json:"subject"`
+  Issuer string `json:"issuer"`
+  Serial string `json:"serial"`
+  NotBefore time.Time `json:"not_before"`
+  NotAfter time.Time `json:"not_after"`
+
+  // The revocation status: good, revoked or unknown, or trusted for the
+  // root.
+  Status string `json:"status"`
+  Findings []Finding `json:"findings"`
+}
+
+
+type Finding struct {
+  // Either error or warning. Errors invalidate the chain.
+  Severity string `json:"severity"`
+  Message string `json:"message"`
+}
+
+
+type VerifyOptions struct {
+  Roots []*x509.Certificate
+  Intermediates []*x509.Certificate
+  CRLs []*pkix.CertificateList
+
+  // DER-encoded OCSP responses.
+  OCSP [][]byte
+
+  // The policies that every certificate below the root must assert.
+  Policies []asn1.ObjectIdentifier
+  KeyUsages []x509.ExtKeyUsage
+  CurrentTime time.Time
+
+  // The inventory of the CA, whose revocations are applied in addition to
+  // the CRLs and OCSP responses.
+  Inventory *inventory.Inventory
+}
+
+
+// Verify that a certificate chains to a trusted root and report the
+// findings for each certificate in the chain.
+func VerifyCertificateChain(buf []byte, args []string, backend backends.Backend) {
+  var asJSON bool
+  var at string
+  var crlFiles string
+  var intermediates string
+  var inventoryFile string
+  var ocspFiles string
+  var policies string
+  var roots string
+  var usages string
+
+  parser := flag.NewFlagSet("verify", flag.ExitOnError)
+  parser.StringVar(&roots, "root", "",
+    "specifies a PEM file with the trusted root certificates.")
+  parser.StringVar(&intermediates, "intermediates", "",
+    "specifies a PEM file with intermediate certificates.")
+  parser.StringVar(&crlFiles, "crl", "",
+    "specifies a comma-separated list of CRL files (PEM or DER).")
+  parser.StringVar(&ocspFiles, "ocsp", "",
+    "specifies a comma-separated list of DER-encoded OCSP responses.")
+  parser.StringVar(&inventoryFile, "inventory", "",
+    "specifies the inventory of the CA, with the revoked certificates.")
+  parser.StringVar(&policies, "policy", "",
+    "specifies a comma-separated list of required policy OIDs.")
+  parser.StringVar(&usages, "usage", "any",
+    "specifies a comma-separated list of required extended key usages.")
+  parser.StringVar(&at, "at", "",
+    "verifies the chain at the given RFC 3339 time instead of now.")
+  parser.BoolVar(&asJSON, "json", false,
+    "writes the report as JSON.")
+  parser.Parse(args)
+
+  if roots == "" {
+    log.Fatal("The -root parameter is mandatory.")
+  }
+  opts := VerifyOptions{CurrentTime: time.Now()}
+  var err error
+  if opts.Roots, err = ReadCertificates(roots); err != nil {
+    log.Fatal(err)
+  }
+  if intermediates != "" {
+    if opts.Intermediates, err = ReadCertificates(intermediates); err != nil {
+      log.Fatal(err)
+    }
+  }
+  for _, fp := range splitList(crlFiles) {
+    crl, err := readCRL(fp)
+    if err != nil { log.Fatal(err) }
+    opts.CRLs = append(opts.CRLs, crl)
+  }
+  for _, fp := range splitList(ocspFiles) {
+    der, err := ioutil.ReadFile(fp)
+    if err != nil { log.Fatal(err) }
+    opts.OCSP = append(opts.OCSP, der)
+  }
+  if inventoryFile != "" {
+    opts.Inventory = inventory.Open(inventoryFile)
+  }
+  for _, s := range splitList(policies) {
+    p, err := oid.Parse(s)
+    if err != nil { log.Fatal(err) }
+    opts.Policies = append(opts.Policies, p)
+  }
+  for _, s := range splitList(usages) {
+    u, ok := EXTENDED_KEY_USAGES[s]
+    if !ok {
+      log.Fatal("Invalid extKeyUsage: ", s)
+    }
+    opts.KeyUsages = append(opts.KeyUsages, u)
+  }
+  if at != "" {
+    if opts.CurrentTime, err = time.Parse(time.RFC3339, at); err != nil {
+      log.Fatal(err)
+    }
+  }
+
+  if parser.NArg() > 0 {
+    if buf, err = ioutil.ReadFile(parser.Arg(0)); err != nil {
+      log.Fatal(err)
+    }
+  }
+  leaf, err := ParseCertificate(buf)
+  if err != nil { log.Fatal(err) }
+
+  report := Verify(leaf, &opts)
+  if asJSON {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(report)
+  } else {
+    report.Write(os.Stdout)
+  }
+  if !report.Valid {
+    os.Exit(1)
+  }
+}
+
+
+func Verify(leaf *x509.Certificate, opts *VerifyOptions) *VerificationReport {
+  pool := x509.NewCertPool()
+  for _, c := range opts.Roots {
+    pool.AddCert(c)
+  }
+  intermediates := x509.NewCertPool()
+  for _, c := range opts.Intermediates {
+    intermediates.AddCert(c)
+  }
+
+  report := &VerificationReport{}
+  chains, err := leaf.Verify(x509.VerifyOptions{
+    Roots: pool,
+    Intermediates: intermediates,
+    CurrentTime: opts.CurrentTime,
+    KeyUsages: opts.KeyUsages,
+  })
+  if err != nil {
+    report.Error = err.Error()
+    report.Chain = []CertificateReport{newCertificateReport(leaf)}
+    report.Chain[0].Findings = append(report.Chain[0].Findings,
+      Finding{Severity: "error", Message: err.Error()})
+    return report
+  }
+
+  chain := chains[0]
+  for i, crt := range chain {
+    r := newCertificateReport(crt)
+    add := func(severity string, format string, args ...interface{}) {
+      r.Findings = append(r.Findings, Finding{severity, fmt.Sprintf(format, args...)})
+    }
+    root := i == len(chain) - 1
+
+    if i > 0 {
+      checkCA(crt, chain[:i], add)
+    } else if crt.IsCA {
+      add("warning", "the end-entity certificate is a CA certificate")
+    }
+    checkKeyUsage(crt, i == 0, add)
+    if !root {
+      checkPolicies(crt, opts.Policies, add)
+      r.Status = checkRevocation(crt, chain[i+1], opts, add)
+    } else {
+      r.Status = "trusted"
+    }
+    report.Chain = append(report.Chain, r)
+  }
+
+  report.Valid = true
+  for _, r := range report.Chain {
+    for _, f := range r.Findings {
+      report.Valid = report.Valid && f.Severity != "error"
+    }
+  }
+  return report
+}
+
+
+func newCertificateReport(crt *x509.Certificate) CertificateReport {
+  return CertificateReport{
+    Subject: crt.Subject.String(),
+    Issuer: crt.Issuer.String(),
+    Serial: fmt.Sprintf("%x", crt.SerialNumber),
+    NotBefore: crt.NotBefore,
+    NotAfter: crt.NotAfter,
+    Status: "unknown",
+    Findings: []Finding{},
+  }
+}
+
+
+// Check the basic constraints, key usage and path length of a CA
+// certificate. below are the certificates that it issued, directly or
+// indirectly.
+func checkCA(crt *x509.Certificate, below []*x509.Certificate, add func(string, string, ...interface{})) {
+  if !crt.BasicConstraintsValid || !crt.IsCA {
+    add("error", "the certificate is not a CA certificate")
+  }
+  if crt.KeyUsage != 0 && crt.KeyUsage & x509.KeyUsageCertSign == 0 {
+    add("error", "the key usage does not include keyCertSign")
+  }
+  if crt.KeyUsage == 0 {
+    add("warning", "the certificate has no key usage extension")
+  }
+
+  // Self-issued intermediates do not count towards the path length
+  // (RFC 5280, section 4.2.1.9).
+  n := 0
+  for _, c := range below[1:] {
+    if !bytes.Equal(c.RawIssuer, c.RawSubject) {
+      n++
+    }
+  }
+  if max := pathLength(crt); max != -1 && n > max {
+    add("error", "%d intermediate certificates follow, but the path length is %d", n, max)
+  }
+}
+
+
+func checkKeyUsage(crt *x509.Certificate, leaf bool, add func(string, string, ...interface{})) {
+  if leaf && crt.KeyUsage & (x509.KeyUsageCertSign | x509.KeyUsageCRLSign) != 0 && !crt.IsCA {
+    add("error", "the key usage includes keyCertSign or cRLSign, but the certificate is not a CA")
+  }
+  if crt.KeyUsage == 0 {
+    return
+  }
+  for _, u := range crt.ExtKeyUsage {
+    switch u {
+      case x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth:
+        required := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
+        if crt.KeyUsage & required == 0 {
+          add("warning", "%s requires digitalSignature, keyEncipherment or keyAgreement", extKeyUsageName(u))
+        }
+      case x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageTimeStamping, x509.ExtKeyUsageOCSPSigning:
+        if crt.KeyUsage & (x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment) == 0 {
+          add("warning", "%s requires digitalSignature or nonRepudiation", extKeyUsageName(u))
+        }
+    }
+  }
+}
+
+
+func checkPolicies(crt *x509.Certificate, policies []asn1.ObjectIdentifier, add func(string, string, ...interface{})) {
+  for _, p := range policies {
+    found := false
+    for _, q := range crt.PolicyIdentifiers {
+      found = found || q.Equal(p) || q.Equal(oidAnyPolicy)
+    }
+    if !found {
+      add("error", "the certificate does not assert policy %s", p)
+    }
+  }
+}
+
+
+// Return the revocation status of crt according to the CRLs and OCSP
+// responses signed by issuer.
+func checkRevocation(crt *x509.Certificate, issuer *x509.Certificate, opts *VerifyOptions, add func(string, string, ...interface{})) string {
+  status := "unknown"
+  for _, crl := range opts.CRLs {
+    // CRLs that are not signed by the issuer are ignored.
+    if issuer.CheckCRLSignature(crl) != nil {
+      continue
+    }
+    if crl.HasExpired(opts.CurrentTime) {
+      add("warning", "the CRL expired at %s", crl.TBSCertList.NextUpdate.Format(time.RFC3339))
+    }
+    status = "good"
+    for _, revoked := range crl.TBSCertList.RevokedCertificates {
+      if revoked.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+        add("error", "the certificate was revoked at %s (CRL)",
+          revoked.RevocationTime.Format(time.RFC3339))
+        return "revoked"
+      }
+    }
+  }
+  for _, der := range opts.OCSP {
+    resp, err := ocsp.ParseResponseForCert(der, crt, issuer)
+    if err != nil {
+      continue
+    }
+    if !resp.NextUpdate.IsZero() && opts.CurrentTime.After(resp.NextUpdate) {
+      add("warning", "the OCSP response expired at %s", resp.NextUpdate.Format(time.RFC3339))
+    }
+    switch resp.Status {
+      case ocsp.Good:
+        status = "good"
+      case ocsp.Revoked:
+        add("error", "the certificate was revoked at %s (OCSP)",
+          resp.RevokedAt.Format(time.RFC3339))
+        return "revoked"
+    }
+  }
+  if opts.Inventory != nil {
+    issued, revoked, err := opts.Inventory.Find(crt.Issuer.String(), fmt.Sprintf("%x", crt.SerialNumber))
+    switch {
+      case err != nil:
+        add("warning", "the inventory can not be read: %s", err)
+      case revoked != nil:
+        add("error", "the certificate was revoked at %s (inventory: %s)",
+          revoked.Time.Format(time.RFC3339), revoked.Reason)
+        return "revoked"
+      case issued != nil:
+        status = "good"
+    }
+  }
+  return status
+}
+
+
+func (self *VerificationReport) Write(w io.Writer) {
+  for i, r := range self.Chain {
+    fmt.Fprintf(w, "%d: %s\n", i, r.Subject)
+    fmt.Fprintf(w, "   Serial: %s\n", r.Serial)
+    fmt.Fprintf(w, "   Valid: %s to %s\n", r.NotBefore.Format(time.RFC3339),
+      r.NotAfter.Format(time.RFC3339))
+    fmt.Fprintf(w, "   Status: %s\n", r.Status)
+    for _, f := range r.Findings {
+      fmt.Fprintf(w, "   %s: %s\n", strings.Title(f.Severity), f.Message)
+    }
+  }
+  if self.Valid {
+    fmt.Fprintln(w, "OK")
+  } else {
+    fmt.Fprintln(w, "FAILED")
+  }
+}
+
+
+// Read all PEM-encoded certificates in a file.
+func ReadCertificates(fp string) ([]*x509.Certificate, error) {
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil {
+    return nil, err
+  }
+  certificates := []*x509.Certificate{}
+  for {
+    var block *pem.Block
+    block, buf = pem.Decode(buf)
+    if block == nil {
+      break
+    }
+    if block.Type != "CERTIFICATE" {
+      continue
+    }
+    crt, err := x509.ParseCertificate(block.Bytes)
+    if err != nil {
+      return nil, err
+    }
+    certificates = append(certificates, crt)
+  }
+  if len(certificates) == 0 {
+    return nil, errors.New(fmt.Sprintf("%s does not contain certificates.", fp))
+  }
+  return certificates, nil
+}
+
+
+// Parse a PEM- or DER-encoded certificate.
+func ParseCertificate(buf []byte) (*x509.Certificate, error) {
+  if len(buf) == 0 {
+    return nil, errors.New("Provide the certificate as a file or through stdin.")
+  }
+  if block, _ := pem.Decode(buf); block != nil {
+    buf = block.Bytes
+  }
+  return x509.ParseCertificate(buf)
+}
+
+
+func readCRL(fp string) (*pkix.CertificateList, error) {
+  buf, err := ioutil.ReadFile(fp)
+  if err != nil {
+    return nil, err
+  }
+  return x509.ParseCRL(buf)
+}
+
+
+func splitList(s string) []string {
+  items := []string{}
+  for _, item := range strings.Split(s, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      items = append(items, item)
+    }
+  }
+  return items
+}